@@ -0,0 +1,586 @@
+package generator
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"time"
+
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+	protoV1 "github.com/qubic/go-archiver/protobuff"
+	archiverV1Store "github.com/qubic/go-archiver/store"
+	"github.com/qubic/go-archiver/validator/chain"
+	"github.com/qubic/go-node-connector/types"
+	"github.com/schollz/progressbar/v3"
+)
+
+// TargetTickVoteSignatureEpoch is the last epoch without a target tick vote signature. The archiver stores one for
+// every later epoch.
+const TargetTickVoteSignatureEpoch = 158
+
+// storeDigestFirstTick is the first tick for which the archiver computes store digests.
+const storeDigestFirstTick = 13752150
+
+// Options describe the archive to generate. All random values are drawn from Seed, so the same options always produce
+// the same records.
+type Options struct {
+	Seed       uint64
+	FirstEpoch uint32
+	Epochs     int
+	// FirstTick is the initial tick of the first interval of the first epoch.
+	FirstTick uint32
+	// IntervalsPerEpoch is the number of processed tick intervals of every epoch. The ticks between two intervals are
+	// recorded as skipped, like the archiver does when it restarts behind the network.
+	IntervalsPerEpoch int
+	TicksPerInterval  uint32
+	// IntervalGap is the number of skipped ticks between two consecutive intervals, also across epochs.
+	IntervalGap uint32
+	// EmptyTickRatio is the share of ticks that are stored as empty tick data, without transactions.
+	EmptyTickRatio float64
+	// TransactionsPerTick is the average number of transactions of a non empty tick.
+	TransactionsPerTick int
+	// TransferRatio is the share of transactions that move an amount and are added to the identity transfer index.
+	TransferRatio float64
+	// MoneyFlewRatio is the share of transaction statuses with money flew set.
+	MoneyFlewRatio float64
+	// MissingStatusRatio is the share of transactions without a stored status.
+	MissingStatusRatio float64
+	// Identities is the number of distinct identities that send and receive the transactions.
+	Identities int
+	// Computors is the number of identities in the computor list of every epoch.
+	Computors int
+	// QuorumVotesPerTick is the number of computor votes in the quorum data of every tick.
+	QuorumVotesPerTick int
+}
+
+// Summary counts the records written by a generator run.
+type Summary struct {
+	Epochs       int
+	Ticks        int
+	EmptyTicks   int
+	Transactions int
+	Transfers    int
+	Statuses     int
+}
+
+type generator struct {
+	options    Options
+	rand       *rand.Rand
+	store      *archiverV1Store.PebbleStore
+	identities []string
+	// lastTick is the last tick of the previous interval, zero before the first one.
+	lastTick uint32
+	// previousChainDigest and previousStoreDigest are the digests of the previous tick, the ones a tick is chained to.
+	// They are empty for the first tick of an epoch and after skipped ticks.
+	previousChainDigest [32]byte
+	previousStoreDigest [32]byte
+	summary             Summary
+}
+
+// Generate writes a synthetic v1 archive into a new pebble database at path. The records are written through the
+// setters of the go-archiver store, in the order the archiver processes ticks, so the result is laid out like an
+// archive written by the archiver itself.
+func Generate(ctx context.Context, path string, options Options) (Summary, error) {
+	err := validateOptions(options)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	_, err = os.Stat(path)
+	if err == nil {
+		return Summary{}, fmt.Errorf("database path %s already exists", path)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return Summary{}, fmt.Errorf("checking database path %s: %w", path, err)
+	}
+
+	// The archive is created in the format the migration expects, like an archive upgraded with the migrator.
+	oldStore, err := v1.CreateArchiverStoreV1(path)
+	if err != nil {
+		return Summary{}, err
+	}
+	defer oldStore.Close()
+
+	g := generator{
+		options: options,
+		rand:    rand.New(rand.NewPCG(options.Seed, options.Seed)),
+		store:   oldStore.ArchiverStore,
+	}
+	g.identities = make([]string, options.Identities)
+	for index := range g.identities {
+		g.identities[index] = g.randomIdentity()
+	}
+
+	tickNumber := options.FirstTick
+	for epochIndex := range options.Epochs {
+		epoch := options.FirstEpoch + uint32(epochIndex)
+
+		tickNumber, err = g.generateEpoch(ctx, epoch, tickNumber)
+		if err != nil {
+			return Summary{}, fmt.Errorf("generating epoch %d: %w", epoch, err)
+		}
+	}
+
+	err = oldStore.Flush()
+	if err != nil {
+		return Summary{}, fmt.Errorf("flushing archiver v1 database: %w", err)
+	}
+
+	return g.summary, nil
+}
+
+func validateOptions(options Options) error {
+	if options.Epochs < 1 || options.IntervalsPerEpoch < 1 || options.TicksPerInterval < 1 {
+		return errors.New("at least one epoch, interval and tick per interval are required")
+	}
+	if options.FirstEpoch == 0 || options.FirstTick == 0 {
+		return errors.New("the first epoch and the first tick must not be zero")
+	}
+	if options.TransactionsPerTick < 0 || options.Identities < 2 || options.Computors < 1 {
+		return errors.New("at least two identities and one computor are required and transactions per tick must not be negative")
+	}
+	if options.QuorumVotesPerTick < 0 || options.QuorumVotesPerTick > options.Computors {
+		return fmt.Errorf("quorum votes per tick must be between 0 and the number of computors %d", options.Computors)
+	}
+	for _, ratio := range []float64{options.EmptyTickRatio, options.TransferRatio, options.MoneyFlewRatio, options.MissingStatusRatio} {
+		if ratio < 0 || ratio > 1 {
+			return fmt.Errorf("ratio %v is not between 0 and 1", ratio)
+		}
+	}
+	return nil
+}
+
+// generateEpoch writes the intervals of the epoch starting at tickNumber and returns the initial tick of the next
+// interval.
+func (g *generator) generateEpoch(ctx context.Context, epoch, tickNumber uint32) (uint32, error) {
+	log.Printf("Generating epoch %d with %d intervals of %d ticks, starting at tick %d", epoch, g.options.IntervalsPerEpoch, g.options.TicksPerInterval, tickNumber)
+
+	computors := g.generateComputors(epoch)
+	err := g.store.SetComputors(ctx, epoch, computors)
+	if err != nil {
+		return 0, fmt.Errorf("setting computors: %w", err)
+	}
+
+	if epoch > TargetTickVoteSignatureEpoch {
+		err = g.store.SetTargetTickVoteSignature(epoch, g.rand.Uint32())
+		if err != nil {
+			return 0, fmt.Errorf("setting target tick vote signature: %w", err)
+		}
+	}
+
+	bar := progressbar.Default(int64(g.options.IntervalsPerEpoch)*int64(g.options.TicksPerInterval), fmt.Sprintf("Generating epoch %d", epoch))
+
+	var emptyTicks []uint32
+	g.previousChainDigest, g.previousStoreDigest = [32]byte{}, [32]byte{}
+	for range g.options.IntervalsPerEpoch {
+		if g.lastTick != 0 && tickNumber > g.lastTick+1 {
+			g.previousChainDigest, g.previousStoreDigest = [32]byte{}, [32]byte{}
+
+			err = g.store.SetSkippedTicksInterval(ctx, &protoV1.SkippedTicksInterval{StartTick: g.lastTick + 1, EndTick: tickNumber - 1})
+			if err != nil {
+				return 0, fmt.Errorf("setting skipped ticks interval: %w", err)
+			}
+		}
+
+		err = g.store.AppendProcessedTickInterval(ctx, epoch, &protoV1.ProcessedTickInterval{InitialProcessedTick: tickNumber, LastProcessedTick: tickNumber})
+		if err != nil {
+			return 0, fmt.Errorf("appending processed tick interval: %w", err)
+		}
+
+		var quorumData *protoV1.QuorumTickData
+		g.lastTick = tickNumber + g.options.TicksPerInterval - 1
+		for ; tickNumber <= g.lastTick; tickNumber++ {
+			err = ctx.Err()
+			if err != nil {
+				return 0, fmt.Errorf("stopped at tick %d: %w", tickNumber, err)
+			}
+
+			empty := g.rand.Float64() < g.options.EmptyTickRatio
+			quorumData, err = g.generateTick(ctx, epoch, tickNumber, empty, computors)
+			if err != nil {
+				return 0, fmt.Errorf("generating tick %d: %w", tickNumber, err)
+			}
+			if empty {
+				emptyTicks = append(emptyTicks, tickNumber)
+			}
+
+			err = g.store.SetLastProcessedTick(ctx, &protoV1.ProcessedTick{TickNumber: tickNumber, Epoch: epoch})
+			if err != nil {
+				return 0, fmt.Errorf("setting last processed tick %d: %w", tickNumber, err)
+			}
+			_ = bar.Add(1)
+		}
+
+		err = g.store.SetQuorumDataForCurrentEpochInterval(epoch, quorumData)
+		if err != nil {
+			return 0, fmt.Errorf("setting last tick quorum data of the interval: %w", err)
+		}
+
+		tickNumber += g.options.IntervalGap
+	}
+
+	err = g.store.SetEmptyTicksForEpoch(epoch, uint32(len(emptyTicks)))
+	if err != nil {
+		return 0, fmt.Errorf("setting empty ticks count: %w", err)
+	}
+	err = g.store.SetEmptyTickListPerEpoch(epoch, emptyTicks)
+	if err != nil {
+		return 0, fmt.Errorf("setting empty tick list: %w", err)
+	}
+
+	g.summary.Epochs++
+	g.summary.EmptyTicks += len(emptyTicks)
+	return tickNumber, nil
+}
+
+// generateTick writes the tick data, quorum data, transactions, identity transfers, statuses and digests of the tick,
+// and returns the quorum data of the tick as it is kept for the last tick of an interval.
+func (g *generator) generateTick(ctx context.Context, epoch, tickNumber uint32, empty bool, computors *protoV1.Computors) (*protoV1.QuorumTickData, error) {
+	timestamp := uint64(1700000000000) + uint64(tickNumber)*1000
+
+	quorumData, link := g.generateQuorumData(epoch, tickNumber, timestamp, len(computors.Identities))
+	quorumDataStored := &protoV1.QuorumTickDataStored{
+		QuorumTickStructure:   quorumData.QuorumTickStructure,
+		QuorumDiffPerComputor: make(map[uint32]*protoV1.QuorumDiffStored, len(quorumData.QuorumDiffPerComputor)),
+	}
+	for computorIndex, diff := range quorumData.QuorumDiffPerComputor {
+		quorumDataStored.QuorumDiffPerComputor[computorIndex] = &protoV1.QuorumDiffStored{
+			ExpectedNextTickTxDigestHex: diff.ExpectedNextTickTxDigestHex,
+			SignatureHex:                diff.SignatureHex,
+		}
+	}
+	err := g.store.SetQuorumTickData(ctx, tickNumber, quorumDataStored)
+	if err != nil {
+		return nil, fmt.Errorf("setting quorum data: %w", err)
+	}
+
+	err = g.storeChainDigest(ctx, tickNumber, link)
+	if err != nil {
+		return nil, err
+	}
+
+	g.summary.Ticks++
+	if empty {
+		// The archiver stores an empty message for empty ticks.
+		err = g.store.SetTickData(ctx, tickNumber, &protoV1.TickData{})
+		if err != nil {
+			return nil, fmt.Errorf("setting empty tick data: %w", err)
+		}
+
+		err = g.storeStoreDigest(ctx, tickNumber, nil, &protoV1.TickTransactionsStatus{})
+		if err != nil {
+			return nil, err
+		}
+		return quorumData, nil
+	}
+
+	txs := g.generateTransactions(tickNumber)
+	tickData := &protoV1.TickData{
+		ComputorIndex: tickNumber % uint32(len(computors.Identities)),
+		Epoch:         epoch,
+		TickNumber:    tickNumber,
+		Timestamp:     timestamp,
+		TimeLock:      g.randomBytes(32),
+		SignatureHex:  g.randomHex(64),
+	}
+	for _, tx := range txs {
+		tickData.TransactionIds = append(tickData.TransactionIds, tx.TxId)
+	}
+	err = g.store.SetTickData(ctx, tickNumber, tickData)
+	if err != nil {
+		return nil, fmt.Errorf("setting tick data: %w", err)
+	}
+
+	err = g.store.SetTransactions(ctx, txs)
+	if err != nil {
+		return nil, fmt.Errorf("setting transactions: %w", err)
+	}
+	g.summary.Transactions += len(txs)
+
+	err = g.storeTransfers(ctx, tickNumber, txs)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := &protoV1.TickTransactionsStatus{}
+	for _, tx := range txs {
+		if g.rand.Float64() < g.options.MissingStatusRatio {
+			continue
+		}
+		statuses.Transactions = append(statuses.Transactions, &protoV1.TransactionStatus{
+			TxId:      tx.TxId,
+			MoneyFlew: tx.Amount > 0 && g.rand.Float64() < g.options.MoneyFlewRatio,
+		})
+	}
+	err = g.store.SetTickTransactionsStatus(ctx, uint64(tickNumber), statuses)
+	if err != nil {
+		return nil, fmt.Errorf("setting transaction statuses: %w", err)
+	}
+	g.summary.Statuses += len(statuses.Transactions)
+
+	err = g.storeStoreDigest(ctx, tickNumber, txs, statuses)
+	if err != nil {
+		return nil, err
+	}
+	return quorumData, nil
+}
+
+// storeChainDigest chains the quorum vote of the tick to the chain digest of the previous tick and stores the result,
+// like the archiver does for every tick.
+func (g *generator) storeChainDigest(ctx context.Context, tickNumber uint32, link chain.Chain) error {
+	link.PreviousTickChainDigest = g.previousChainDigest
+	digest, err := link.Digest()
+	if err != nil {
+		return fmt.Errorf("computing chain digest: %w", err)
+	}
+
+	err = g.store.PutChainDigest(ctx, tickNumber, digest[:])
+	if err != nil {
+		return fmt.Errorf("setting chain digest: %w", err)
+	}
+	g.previousChainDigest = digest
+	return nil
+}
+
+// storeStoreDigest chains the transactions and statuses of the tick to the store digest of the previous tick and
+// stores the result. Like the archiver, ticks before storeDigestFirstTick have no store digest.
+func (g *generator) storeStoreDigest(ctx context.Context, tickNumber uint32, txs []*protoV1.Transaction, statuses *protoV1.TickTransactionsStatus) error {
+	if tickNumber < storeDigestFirstTick {
+		return nil
+	}
+
+	validTxs := make([]types.Transaction, 0, len(txs))
+	for _, tx := range txs {
+		transaction, err := qubicTransaction(tx)
+		if err != nil {
+			return fmt.Errorf("converting transaction %s: %w", tx.TxId, err)
+		}
+		validTxs = append(validTxs, transaction)
+	}
+
+	store := chain.Store{
+		PreviousTickStoreDigest: g.previousStoreDigest,
+		ValidTxs:                validTxs,
+		TickTxsStatus:           statuses,
+	}
+	digest, err := store.Digest()
+	if err != nil {
+		return fmt.Errorf("computing store digest: %w", err)
+	}
+
+	err = g.store.PutStoreDigest(ctx, tickNumber, digest[:])
+	if err != nil {
+		return fmt.Errorf("setting store digest: %w", err)
+	}
+	g.previousStoreDigest = digest
+	return nil
+}
+
+// qubicTransaction returns the transaction in the form the archiver hashes into the store digest.
+func qubicTransaction(tx *protoV1.Transaction) (types.Transaction, error) {
+	source := types.Identity(tx.SourceId)
+	sourcePublicKey, err := source.ToPubKey(false)
+	if err != nil {
+		return types.Transaction{}, fmt.Errorf("decoding source id: %w", err)
+	}
+
+	destination := types.Identity(tx.DestId)
+	destinationPublicKey, err := destination.ToPubKey(false)
+	if err != nil {
+		return types.Transaction{}, fmt.Errorf("decoding destination id: %w", err)
+	}
+
+	input, err := hex.DecodeString(tx.InputHex)
+	if err != nil {
+		return types.Transaction{}, fmt.Errorf("decoding input: %w", err)
+	}
+	signature, err := hex.DecodeString(tx.SignatureHex)
+	if err != nil {
+		return types.Transaction{}, fmt.Errorf("decoding signature: %w", err)
+	}
+
+	transaction := types.Transaction{
+		SourcePublicKey:      sourcePublicKey,
+		DestinationPublicKey: destinationPublicKey,
+		Amount:               tx.Amount,
+		Tick:                 tx.TickNumber,
+		InputType:            uint16(tx.InputType),
+		InputSize:            uint16(tx.InputSize),
+		Input:                input,
+	}
+	copy(transaction.Signature[:], signature)
+	return transaction, nil
+}
+
+// storeTransfers adds the transfers of the tick to the identity transfer index of their source and destination, like
+// the archiver's transaction validator does.
+func (g *generator) storeTransfers(ctx context.Context, tickNumber uint32, txs []*protoV1.Transaction) error {
+	var identities []string
+	transfersPerIdentity := make(map[string][]*protoV1.Transaction)
+	for _, tx := range txs {
+		if tx.Amount == 0 {
+			continue
+		}
+		g.summary.Transfers++
+
+		for _, identity := range []string{tx.SourceId, tx.DestId} {
+			if _, ok := transfersPerIdentity[identity]; !ok {
+				identities = append(identities, identity)
+			}
+			transfersPerIdentity[identity] = append(transfersPerIdentity[identity], tx)
+		}
+	}
+
+	for _, identity := range identities {
+		err := g.store.PutTransferTransactionsPerTick(ctx, identity, tickNumber, &protoV1.TransferTransactionsPerTick{
+			TickNumber:   tickNumber,
+			Identity:     identity,
+			Transactions: transfersPerIdentity[identity],
+		})
+		if err != nil {
+			return fmt.Errorf("setting transfers of identity %s: %w", identity, err)
+		}
+	}
+	return nil
+}
+
+func (g *generator) generateTransactions(tickNumber uint32) []*protoV1.Transaction {
+	count := 0
+	if g.options.TransactionsPerTick > 0 {
+		count = g.rand.IntN(2*g.options.TransactionsPerTick + 1)
+	}
+
+	txs := make([]*protoV1.Transaction, 0, count)
+	for range count {
+		source := g.rand.IntN(len(g.identities))
+		destination := (source + 1 + g.rand.IntN(len(g.identities)-1)) % len(g.identities)
+
+		tx := &protoV1.Transaction{
+			SourceId:     g.identities[source],
+			DestId:       g.identities[destination],
+			TickNumber:   tickNumber,
+			SignatureHex: g.randomHex(64),
+			TxId:         g.randomTxId(),
+		}
+		if g.rand.Float64() < g.options.TransferRatio {
+			tx.Amount = 1 + g.rand.Int64N(1000000000)
+		} else {
+			input := g.randomBytes(g.rand.IntN(256))
+			tx.InputType = 1 + g.rand.Uint32N(20)
+			tx.InputSize = uint32(len(input))
+			tx.InputHex = hex.EncodeToString(input)
+		}
+		txs = append(txs, tx)
+	}
+	return txs
+}
+
+func (g *generator) generateComputors(epoch uint32) *protoV1.Computors {
+	computors := &protoV1.Computors{
+		Epoch:        epoch,
+		Identities:   make([]string, g.options.Computors),
+		SignatureHex: g.randomHex(64),
+	}
+	for index := range computors.Identities {
+		computors.Identities[index] = g.randomIdentity()
+	}
+	return computors
+}
+
+// generateQuorumData returns the quorum data of the tick with the votes of QuorumVotesPerTick distinct computors, and
+// the quorum vote as it is hashed into the chain digest, without the previous chain digest.
+func (g *generator) generateQuorumData(epoch, tickNumber uint32, timestamp uint64, computorCount int) (*protoV1.QuorumTickData, chain.Chain) {
+	// The archiver stores the vote date as a unix timestamp in milliseconds.
+	date := time.UnixMilli(int64(timestamp)).UTC()
+	link := chain.Chain{
+		Epoch:                         uint16(epoch),
+		Tick:                          tickNumber,
+		Millisecond:                   uint16(date.Nanosecond() / int(time.Millisecond)),
+		Second:                        uint8(date.Second()),
+		Minute:                        uint8(date.Minute()),
+		Hour:                          uint8(date.Hour()),
+		Day:                           uint8(date.Day()),
+		Month:                         uint8(date.Month()),
+		Year:                          uint8(date.Year() - 2000),
+		PreviousResourceTestingDigest: g.rand.Uint32(),
+		PreviousTransactionBodyDigest: g.rand.Uint32(),
+		PreviousSpectrumDigest:        g.randomDigest(),
+		PreviousUniverseDigest:        g.randomDigest(),
+		PreviousComputerDigest:        g.randomDigest(),
+		TxDigest:                      g.randomDigest(),
+	}
+
+	quorumData := &protoV1.QuorumTickData{
+		QuorumTickStructure: &protoV1.QuorumTickStructure{
+			Epoch:                        epoch,
+			TickNumber:                   tickNumber,
+			Timestamp:                    timestamp,
+			PrevResourceTestingDigestHex: hexUint32(link.PreviousResourceTestingDigest),
+			PrevSpectrumDigestHex:        hex.EncodeToString(link.PreviousSpectrumDigest[:]),
+			PrevUniverseDigestHex:        hex.EncodeToString(link.PreviousUniverseDigest[:]),
+			PrevComputerDigestHex:        hex.EncodeToString(link.PreviousComputerDigest[:]),
+			TxDigestHex:                  hex.EncodeToString(link.TxDigest[:]),
+			PrevTransactionBodyHex:       hexUint32(link.PreviousTransactionBodyDigest),
+		},
+		QuorumDiffPerComputor: make(map[uint32]*protoV1.QuorumDiff, g.options.QuorumVotesPerTick),
+	}
+
+	for _, computorIndex := range g.rand.Perm(computorCount)[:g.options.QuorumVotesPerTick] {
+		quorumData.QuorumDiffPerComputor[uint32(computorIndex)] = &protoV1.QuorumDiff{
+			SaltedResourceTestingDigestHex: g.randomHex(4),
+			SaltedSpectrumDigestHex:        g.randomHex(32),
+			SaltedUniverseDigestHex:        g.randomHex(32),
+			SaltedComputerDigestHex:        g.randomHex(32),
+			ExpectedNextTickTxDigestHex:    g.randomHex(32),
+			SignatureHex:                   g.randomHex(64),
+			SaltedTransactionBodyHex:       g.randomHex(4),
+		}
+	}
+	return quorumData, link
+}
+
+// randomIdentity returns a random identity in the format of the archiver, 60 upper case letters.
+func (g *generator) randomIdentity() string {
+	return g.randomLetters('A')
+}
+
+// randomTxId returns a random transaction id in the format of the archiver, 60 lower case letters.
+func (g *generator) randomTxId() string {
+	return g.randomLetters('a')
+}
+
+func (g *generator) randomLetters(first byte) string {
+	letters := make([]byte, 60)
+	for index := range letters {
+		letters[index] = first + byte(g.rand.IntN(26))
+	}
+	return string(letters)
+}
+
+func (g *generator) randomHex(length int) string {
+	return hex.EncodeToString(g.randomBytes(length))
+}
+
+func (g *generator) randomDigest() [32]byte {
+	var digest [32]byte
+	copy(digest[:], g.randomBytes(len(digest)))
+	return digest
+}
+
+// hexUint32 encodes a 4 byte digest the way the archiver does, as little endian hex.
+func hexUint32(value uint32) string {
+	return hex.EncodeToString(binary.LittleEndian.AppendUint32(nil, value))
+}
+
+func (g *generator) randomBytes(length int) []byte {
+	data := make([]byte, length)
+	for index := range data {
+		data[index] = byte(g.rand.Uint32())
+	}
+	return data
+}
@@ -1,36 +1,113 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"maps"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/ardanlabs/conf/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/qubic/archiver-db-migrator/generator"
 	"github.com/qubic/archiver-db-migrator/migration"
 	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+	v2 "github.com/qubic/archiver-db-migrator/store/v2"
 )
 
 const confPrefix = "ARCHIVER_MIGRATOR_V2"
 
+// exitInterrupted is the exit status of a run stopped by SIGINT or SIGTERM, which tells it apart from a failed run.
+// Interrupted epochs are resumed by the next run.
+const exitInterrupted = 130
+
 func main() {
-	err := run()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	// After the first signal the default handling is restored, so a second one terminates the process immediately.
+	stopNotice := context.AfterFunc(ctx, func() {
+		stop()
+		log.Println("Received signal, stopping at the next batch boundary. Send it again to exit immediately.")
+	})
+
+	var subcommand string
+	if len(os.Args) > 1 {
+		subcommand = os.Args[1]
+	}
+
+	var err error
+	switch subcommand {
+	case "generate":
+		// The subcommands have options of their own, they are parsed without the subcommand.
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		err = runGenerate(ctx)
+	case "reverse":
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		err = runReverse(ctx)
+	default:
+		err = run(ctx)
+	}
+	stopNotice()
+	stop()
 	if err != nil {
-		log.Printf("error while running migrator: %v", err)
+		if errors.Is(err, context.Canceled) {
+			log.Printf("migrator interrupted: %v", err)
+			os.Exit(exitInterrupted)
+		}
+		log.Fatalf("error while running migrator: %v", err)
 	}
 }
 
-func run() error {
+func run(ctx context.Context) error {
 
 	var config struct {
 		Database struct {
 			PathOld             string `conf:"default:storage/old"`
 			PathNew             string `conf:"default:storage/new"`
 			CompactAfterMigrate bool   `conf:"default:false"`
+			Checkpoint          bool   `conf:"default:false"`
+			UpgradeFormat       string `conf:"default:none"`
+			ReuseUpgraded       bool   `conf:"default:false"`
 		}
-		BatchSize int `conf:"default:10000"`
-		Migrate   struct {
+		BatchSize                 int    `conf:"default:10000"`
+		EpochConcurrency          int    `conf:"default:1"`
+		PipelineWorkers           int    `conf:"default:0"`
+		WriteMode                 string `conf:"default:batch"`
+		SortBufferSize            int    `conf:"default:268435456"` // 256 MB
+		TxIdBufferSize            int    `conf:"default:268435456"` // 256 MB
+		BenchmarkWriteModes       bool   `conf:"default:false"`
+		Verify                    bool   `conf:"default:false"`
+		RecomputeDigests          bool   `conf:"default:false"`
+		ReportPath                string
+		EventsPath                string
+		MetricsAddress            string
+		StagingLeftovers          string        `conf:"default:resume"`
+		ExistingTarget            string        `conf:"default:skip"`
+		MissingStatus             string        `conf:"default:abort"`
+		StatusStrategy            string        `conf:"default:auto"`
+		StatusScanMinTransactions int           `conf:"default:10000"`
+		Steps                     string        `conf:"default:all"`
+		BenchmarkStatusStrategies bool          `conf:"default:false"`
+		Plan                      bool          `conf:"default:false"`
+		PlanSampleTicks           uint32        `conf:"default:1000"`
+		SkipLiveEpoch             bool          `conf:"default:false"`
+		SkipPublished             bool          `conf:"default:false"`
+		Follow                    bool          `conf:"default:false"`
+		FollowInterval            time.Duration `conf:"default:1m"`
+		Migrate                   struct {
 			All        bool   `conf:"default:false"`
 			Epoch      uint32 `conf:"default:0"`
+			Epochs     string
 			EpochRange struct {
 				Start uint32 `conf:"default:0"`
 				End   uint32 `conf:"default:0"`
@@ -47,42 +124,466 @@ func run() error {
 		return fmt.Errorf("parsing config: %w", err)
 	}
 
-	oldStore, err := v1.NewArchiverStoreV1(config.Database.PathOld)
+	if config.BatchSize <= 0 {
+		return fmt.Errorf("invalid batch size %d, it has to be positive", config.BatchSize)
+	}
+	if !slices.Contains([]string{v1.UpgradeFormatNone, v1.UpgradeFormatCheckpoint, v1.UpgradeFormatInPlace}, config.Database.UpgradeFormat) {
+		return fmt.Errorf("unknown format upgrade %s", config.Database.UpgradeFormat)
+	}
+	if config.Follow && config.Database.UpgradeFormat == v1.UpgradeFormatCheckpoint {
+		return errors.New("follow mode reads the v1 store itself, upgrade its format in place instead of a checkpoint of it")
+	}
+	if config.Database.UpgradeFormat != v1.UpgradeFormatNone {
+		upgradedPath := config.Database.PathOld
+		if config.Database.UpgradeFormat == v1.UpgradeFormatCheckpoint {
+			upgradedPath = filepath.Clean(config.Database.PathOld) + ".upgraded"
+		}
+
+		before, after, err := v1.UpgradeFormatMajorVersion(ctx, config.Database.PathOld, upgradedPath, config.Database.ReuseUpgraded)
+		if errors.Is(err, v1.ErrUpgradedCheckpointExists) {
+			return fmt.Errorf("upgrading format of old archiver store v1: %w, remove it or reuse it with --database-reuse-upgraded true", err)
+		}
+		if err != nil {
+			return fmt.Errorf("upgrading format of old archiver store v1: %w", err)
+		}
+		if before >= v1.UpgradedFormatMajorVersion {
+			log.Printf("Format major version of %s is already %s, it is not upgraded", config.Database.PathOld, before)
+		} else {
+			if upgradedPath != config.Database.PathOld {
+				// The upgraded checkpoint is only needed for this run. It is removed after the v1 store opened from it
+				// is closed.
+				defer func() {
+					err := v1.RemoveUpgradedCheckpoint(upgradedPath)
+					if err != nil {
+						log.Printf("Failed to remove upgraded checkpoint: %v\n", err)
+					}
+				}()
+			}
+			log.Printf("Format major version of %s upgraded from %s to %s at %s", config.Database.PathOld, before, after, upgradedPath)
+
+			// The upgraded checkpoint is migrated in place of the v1 store.
+			config.Database.PathOld = upgradedPath
+		}
+	}
+
+	// Opening the v1 store takes the archiver's lock. A checkpoint only holds it while it is created, next to the v1
+	// store so that its files can be hard linked.
+	openOldStore := func(ctx context.Context) (*v1.ArchiverStoreV1, error) {
+		if config.Database.Checkpoint {
+			return v1.NewArchiverStoreV1Checkpoint(ctx, config.Database.PathOld, filepath.Clean(config.Database.PathOld)+".checkpoint")
+		}
+		return v1.NewArchiverStoreV1(ctx, config.Database.PathOld)
+	}
+	oldStore, err := openOldStore(ctx)
 	if err != nil {
 		return fmt.Errorf("opening old archiver store v1: %w", err)
 	}
 
-	defer oldStore.Close()
+	closeOldStore := sync.OnceValue(oldStore.Close)
+	defer closeOldStore()
 
-	migrator := migration.NewMigrator(oldStore, config.Database.PathNew, config.BatchSize, config.Database.CompactAfterMigrate)
+	epochs, err := selectedEpochs(oldStore.StoreMetadata, config.Migrate.All, config.Migrate.Epoch, config.Migrate.EpochRange.Start, config.Migrate.EpochRange.End, config.Migrate.Epochs)
+	if err != nil {
+		return fmt.Errorf("selecting epochs: %w", err)
+	}
 
-	if config.Migrate.All {
-		log.Println("Starting migration of all epochs")
+	if !slices.Contains([]string{migration.MissingStatusAbort, migration.MissingStatusSkip, migration.MissingStatusRebuild}, config.MissingStatus) {
+		return fmt.Errorf("unknown missing status policy %s", config.MissingStatus)
+	}
+
+	if config.Verify {
+		if len(epochs) == 0 {
+			return errors.New("no epochs selected for verification")
+		}
+
+		log.Printf("Starting verification of epochs %v", epochs)
 
-		err := migrator.MigrateAllEpochs()
+		verifier := migration.NewVerifier(migration.NewV1Source(oldStore), config.Database.PathNew, migration.VerifierOptions{
+			RecomputeDigests: config.RecomputeDigests,
+			MissingStatus:    config.MissingStatus,
+			TxIdBufferSize:   config.TxIdBufferSize,
+			SortBufferSize:   config.SortBufferSize,
+		})
+		results, err := verifier.VerifyEpochs(ctx, epochs)
 		if err != nil {
-			return fmt.Errorf("migrating all epochs: %w", err)
+			return fmt.Errorf("verifying epochs: %w", err)
+		}
+
+		migration.PrintVerificationResults(results)
+		if migration.HasMismatches(results) {
+			return migration.ErrVerificationFailed
 		}
 		return nil
-	} else if config.Migrate.Epoch != 0 {
-		log.Printf("Starting migration of epoch %d", config.Migrate.Epoch)
+	}
+
+	if config.WriteMode != migration.WriteModeBatch && config.WriteMode != migration.WriteModeIngest {
+		return fmt.Errorf("unknown write mode %s", config.WriteMode)
+	}
+	if config.StagingLeftovers != migration.StagingLeftoversResume && config.StagingLeftovers != migration.StagingLeftoversClean {
+		return fmt.Errorf("unknown staging leftovers policy %s", config.StagingLeftovers)
+	}
+	if !slices.Contains([]string{migration.ExistingTargetFail, migration.ExistingTargetSkip, migration.ExistingTargetOverwrite, migration.ExistingTargetVerifyAndSkip}, config.ExistingTarget) {
+		return fmt.Errorf("unknown existing target policy %s", config.ExistingTarget)
+	}
+	if !slices.Contains([]string{migration.StatusStrategyAuto, migration.StatusStrategyLookup, migration.StatusStrategyScan}, config.StatusStrategy) {
+		return fmt.Errorf("unknown status strategy %s", config.StatusStrategy)
+	}
+	steps, err := migration.ParseSteps(config.Steps)
+	if err != nil {
+		return fmt.Errorf("parsing steps: %w", err)
+	}
+	if config.Follow && steps != nil {
+		return errors.New("follow mode migrates all steps, it cannot be combined with --steps")
+	}
+	if config.Follow && config.FollowInterval <= 0 {
+		return fmt.Errorf("invalid follow interval %s", config.FollowInterval)
+	}
+
+	events, closeEvents, err := openOutput(config.EventsPath)
+	if err != nil {
+		return fmt.Errorf("opening event stream: %w", err)
+	}
+	defer closeEvents()
+
+	reporter := migration.NewReporter(events)
 
-		err := migrator.MigrateEpoch(config.Migrate.Epoch)
+	var metrics *migration.Metrics
+	if config.MetricsAddress != "" {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+		// The v1 store of follow mode is opened again every cycle, so its pebble metrics are not collected.
+		oldDB := oldStore.GetDB()
+		if config.Follow {
+			oldDB = nil
+		}
+		metrics, err = migration.NewMetrics(registry, oldDB)
+		if err != nil {
+			return fmt.Errorf("registering metrics: %w", err)
+		}
+
+		go serveMetrics(config.MetricsAddress, registry)
+	}
+
+	migrator := migration.NewMigrator(migration.NewV1Source(oldStore), config.Database.PathNew, migration.Options{
+		BatchSize:                 config.BatchSize,
+		CompactAfterMigrate:       config.Database.CompactAfterMigrate,
+		EpochConcurrency:          config.EpochConcurrency,
+		PipelineWorkers:           config.PipelineWorkers,
+		WriteMode:                 config.WriteMode,
+		SortBufferSize:            config.SortBufferSize,
+		TxIdBufferSize:            config.TxIdBufferSize,
+		Reporter:                  reporter,
+		Metrics:                   metrics,
+		StagingLeftovers:          config.StagingLeftovers,
+		ExistingTarget:            config.ExistingTarget,
+		MissingStatus:             config.MissingStatus,
+		StatusStrategy:            config.StatusStrategy,
+		StatusScanMinTransactions: config.StatusScanMinTransactions,
+		Steps:                     steps,
+	})
+
+	if config.Plan {
+		if len(epochs) == 0 {
+			return errors.New("no epochs selected for planning")
+		}
+
+		log.Printf("Planning migration of epochs %v", epochs)
+
+		plans, err := migrator.PlanEpochs(ctx, epochs, config.PlanSampleTicks)
 		if err != nil {
-			return fmt.Errorf("migrating epoch %d: %w", config.Migrate.Epoch, err)
+			return fmt.Errorf("planning epochs: %w", err)
 		}
+
+		migration.PrintPlan(plans)
 		return nil
+	}
+
+	if config.BenchmarkWriteModes {
+		if config.Migrate.Epoch == 0 {
+			return errors.New("benchmarking write modes requires an epoch to be selected with --migrate-epoch")
+		}
+
+		log.Printf("Starting write mode benchmark for epoch %d", config.Migrate.Epoch)
+
+		err := migrator.BenchmarkWriteModes(ctx, config.Migrate.Epoch)
+		if err != nil {
+			return fmt.Errorf("benchmarking write modes for epoch %d: %w", config.Migrate.Epoch, err)
+		}
+		return nil
+	}
+
+	if config.BenchmarkStatusStrategies {
+		if config.Migrate.Epoch == 0 {
+			return errors.New("benchmarking status strategies requires an epoch to be selected with --migrate-epoch")
+		}
+
+		log.Printf("Starting status strategy benchmark for epoch %d", config.Migrate.Epoch)
+
+		err := migrator.BenchmarkStatusStrategies(ctx, config.Migrate.Epoch)
+		if err != nil {
+			return fmt.Errorf("benchmarking status strategies for epoch %d: %w", config.Migrate.Epoch, err)
+		}
+		return nil
+	}
+
+	if config.Follow || config.Migrate.All || config.Migrate.Epoch != 0 || (config.Migrate.EpochRange.Start != 0 && config.Migrate.EpochRange.End != 0) || config.Migrate.Epochs != "" {
+		err = migrator.HandleStagingLeftovers()
+		if err != nil {
+			return fmt.Errorf("handling staging leftovers: %w", err)
+		}
+
+		epochs, err = migrator.SkipEpochs(epochs, config.SkipLiveEpoch, config.SkipPublished)
+		if err != nil {
+			return fmt.Errorf("skipping epochs: %w", err)
+		}
+	}
+
+	var migrateErr error
+	if config.Follow {
+		log.Printf("Following archive v1 at %s every %s", config.Database.PathOld, config.FollowInterval)
+
+		// Every cycle opens the v1 store again, the first one is not kept open, and its lock held, while waiting for the
+		// next cycle.
+		err = closeOldStore()
+		if err != nil {
+			return fmt.Errorf("closing old archiver store v1: %w", err)
+		}
+
+		err = migrator.Follow(ctx, func(ctx context.Context) (migration.Source, func() error, error) {
+			store, err := openOldStore(ctx)
+			if err != nil {
+				return nil, nil, err
+			}
+			return migration.NewV1Source(store), store.Close, nil
+		}, config.FollowInterval)
+		if err != nil {
+			migrateErr = fmt.Errorf("following archive v1: %w", err)
+		}
+	} else if config.Migrate.All {
+		log.Println("Starting migration of all epochs")
+
+		err := migrator.MigrateEpochs(ctx, epochs)
+		if err != nil {
+			migrateErr = fmt.Errorf("migrating all epochs: %w", err)
+		}
+	} else if config.Migrate.Epoch != 0 {
+		if len(epochs) > 0 {
+			log.Printf("Starting migration of epoch %d", config.Migrate.Epoch)
+
+			err := migrator.MigrateEpoch(ctx, config.Migrate.Epoch)
+			if err != nil {
+				migrateErr = fmt.Errorf("migrating epoch %d: %w", config.Migrate.Epoch, err)
+			}
+		}
 	} else if config.Migrate.EpochRange.Start != 0 && config.Migrate.EpochRange.End != 0 {
 		log.Printf("Starting migration of epoch range %d to %d", config.Migrate.EpochRange.Start, config.Migrate.EpochRange.End)
 
-		err := migrator.MigrateEpochRange(config.Migrate.EpochRange.Start, config.Migrate.EpochRange.End)
+		err := migrator.MigrateEpochs(ctx, epochs)
 		if err != nil {
-			return fmt.Errorf("migrating epoch range %d to %d: %w", config.Migrate.EpochRange.Start, config.Migrate.EpochRange.End, err)
+			migrateErr = fmt.Errorf("migrating epoch range %d to %d: %w", config.Migrate.EpochRange.Start, config.Migrate.EpochRange.End, err)
+		}
+	} else if config.Migrate.Epochs != "" {
+		log.Printf("Starting migration of epochs %v", epochs)
+
+		err := migrator.MigrateEpochs(ctx, epochs)
+		if err != nil {
+			migrateErr = fmt.Errorf("migrating epochs %s: %w", config.Migrate.Epochs, err)
 		}
-		return nil
 	} else {
 		oldStore.StoreMetadata.PrintStoreMetadata()
+		return nil
+	}
+
+	// The report is also written for failed runs, it lists the epochs that failed and why.
+	err = writeReport(reporter, config.ReportPath)
+	if err != nil {
+		return errors.Join(migrateErr, fmt.Errorf("writing report: %w", err))
+	}
+	return migrateErr
+}
+
+// runGenerate writes a synthetic v1 archive for testing and benchmarking migrations.
+func runGenerate(ctx context.Context) error {
+
+	var config struct {
+		Path                string  `conf:"default:storage/generated"`
+		Seed                uint64  `conf:"default:1"`
+		FirstEpoch          uint32  `conf:"default:157"`
+		Epochs              int     `conf:"default:3"`
+		FirstTick           uint32  `conf:"default:10000000"`
+		IntervalsPerEpoch   int     `conf:"default:2"`
+		TicksPerInterval    uint32  `conf:"default:200"`
+		IntervalGap         uint32  `conf:"default:1000"`
+		EmptyTickRatio      float64 `conf:"default:0.1"`
+		TransactionsPerTick int     `conf:"default:10"`
+		TransferRatio       float64 `conf:"default:0.8"`
+		MoneyFlewRatio      float64 `conf:"default:0.9"`
+		MissingStatusRatio  float64 `conf:"default:0"`
+		Identities          int     `conf:"default:1000"`
+		Computors           int     `conf:"default:676"`
+		QuorumVotesPerTick  int     `conf:"default:451"`
+	}
+
+	help, err := conf.Parse(confPrefix+"_GENERATE", &config)
+	if err != nil {
+		if errors.Is(err, conf.ErrHelpWanted) {
+			log.Println(help)
+			return nil
+		}
+		return fmt.Errorf("parsing config: %w", err)
+	}
+
+	log.Printf("Generating archive v1 at %s with seed %d", config.Path, config.Seed)
+
+	summary, err := generator.Generate(ctx, config.Path, generator.Options{
+		Seed:                config.Seed,
+		FirstEpoch:          config.FirstEpoch,
+		Epochs:              config.Epochs,
+		FirstTick:           config.FirstTick,
+		IntervalsPerEpoch:   config.IntervalsPerEpoch,
+		TicksPerInterval:    config.TicksPerInterval,
+		IntervalGap:         config.IntervalGap,
+		EmptyTickRatio:      config.EmptyTickRatio,
+		TransactionsPerTick: config.TransactionsPerTick,
+		TransferRatio:       config.TransferRatio,
+		MoneyFlewRatio:      config.MoneyFlewRatio,
+		MissingStatusRatio:  config.MissingStatusRatio,
+		Identities:          config.Identities,
+		Computors:           config.Computors,
+		QuorumVotesPerTick:  config.QuorumVotesPerTick,
+	})
+	if err != nil {
+		return fmt.Errorf("generating archive v1: %w", err)
+	}
+
+	log.Printf("Generated %d epochs with %d ticks (%d empty), %d transactions (%d transfers) and %d statuses",
+		summary.Epochs, summary.Ticks, summary.EmptyTicks, summary.Transactions, summary.Transfers, summary.Statuses)
+	return nil
+}
+
+// runReverse rebuilds a v1 archive from the v2 epoch stores, to roll back to the v1 archiver.
+func runReverse(ctx context.Context) error {
+
+	var config struct {
+		Path      string `conf:"default:storage/reversed"`
+		StorePath string `conf:"default:storage/new"`
+		Epochs    string
+	}
+
+	help, err := conf.Parse(confPrefix+"_REVERSE", &config)
+	if err != nil {
+		if errors.Is(err, conf.ErrHelpWanted) {
+			log.Println(help)
+			return nil
+		}
+		return fmt.Errorf("parsing config: %w", err)
+	}
+
+	epochs, err := v2.ListEpochStores(config.StorePath)
+	if err != nil {
+		return fmt.Errorf("listing epoch stores: %w", err)
+	}
+	if len(epochs) == 0 {
+		return fmt.Errorf("no epoch stores found in %s", config.StorePath)
 	}
+	if config.Epochs != "" {
+		// The selector is resolved against the epochs that have a store.
+		metadata := v1.StoreMetadata{Epochs: make(map[uint32]v1.EpochMetadata)}
+		for _, epoch := range epochs {
+			metadata.Epochs[epoch] = v1.EpochMetadata{Epoch: epoch}
+		}
+		epochs, err = migration.ResolveEpochSelector(config.Epochs, metadata)
+		if err != nil {
+			return fmt.Errorf("selecting epochs: %w", err)
+		}
+	}
+
+	log.Printf("Reversing epochs %v from %s into archive v1 at %s", epochs, config.StorePath, config.Path)
 
+	oldStore, err := v1.CreateArchiverStoreV1(config.Path)
+	if err != nil {
+		return fmt.Errorf("creating archiver store v1: %w", err)
+	}
+
+	err = migration.NewReverser(oldStore, config.StorePath, migration.ReverserOptions{}).ReverseEpochs(ctx, epochs)
+	closeErr := oldStore.Close()
+	if err != nil {
+		return fmt.Errorf("reversing epochs, remove %s before running again: %w", config.Path, err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("closing archiver store v1: %w", closeErr)
+	}
+
+	// The archive is opened again like the migration opens it, which checks that it can be migrated once more.
+	reversedStore, err := v1.NewArchiverStoreV1(ctx, config.Path)
+	if err != nil {
+		return fmt.Errorf("opening reversed archiver store v1: %w", err)
+	}
+	defer reversedStore.Close()
+
+	log.Printf("Reversed %d epochs into %s", len(epochs), config.Path)
+	reversedStore.StoreMetadata.PrintStoreMetadata()
 	return nil
 }
+
+// selectedEpochs returns the epochs selected by the migrate options in ascending order, or nil if none are selected.
+func selectedEpochs(metadata v1.StoreMetadata, all bool, epoch, rangeStart, rangeEnd uint32, selector string) ([]uint32, error) {
+	if all {
+		return slices.Sorted(maps.Keys(metadata.Epochs)), nil
+	}
+	if epoch != 0 {
+		return []uint32{epoch}, nil
+	}
+	if rangeStart != 0 && rangeEnd != 0 {
+		return migration.ResolveEpochSelector(fmt.Sprintf("%d-%d", rangeStart, rangeEnd), metadata)
+	}
+	if selector != "" {
+		return migration.ResolveEpochSelector(selector, metadata)
+	}
+	return nil, nil
+}
+
+// openOutput opens the file at path for writing, or returns stdout if the path is "-". An empty path returns a nil
+// writer.
+func openOutput(path string) (io.Writer, func(), error) {
+	switch path {
+	case "":
+		return nil, func() {}, nil
+	case "-":
+		return os.Stdout, func() {}, nil
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, func() { _ = file.Close() }, nil
+}
+
+// writeReport writes the report of the migration run to the path, if it is set.
+func writeReport(reporter *migration.Reporter, path string) error {
+	output, closeOutput, err := openOutput(path)
+	if err != nil {
+		return fmt.Errorf("opening report file: %w", err)
+	}
+	defer closeOutput()
+
+	if output == nil {
+		return nil
+	}
+	return reporter.WriteReport(output)
+}
+
+// serveMetrics serves the metrics of the registry until the process exits. The migration keeps running if the listener
+// fails.
+func serveMetrics(address string, registry *prometheus.Registry) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	log.Printf("Serving metrics on %s/metrics", address)
+	err := http.ListenAndServe(address, mux)
+	if err != nil {
+		log.Printf("Failed to serve metrics on %s: %v", address, err)
+	}
+}
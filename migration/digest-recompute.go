@@ -0,0 +1,290 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	migratorStore "github.com/qubic/archiver-db-migrator/store"
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+	v2 "github.com/qubic/archiver-db-migrator/store/v2"
+	archiverV2Store "github.com/qubic/go-archiver-v2/db"
+	protoV2 "github.com/qubic/go-archiver-v2/protobuf"
+	protoV1 "github.com/qubic/go-archiver/protobuff"
+	"github.com/qubic/go-archiver/validator/chain"
+	"github.com/qubic/go-node-connector/types"
+	"github.com/schollz/progressbar/v3"
+)
+
+// storeDigestFirstTick is the first tick for which the v1 archiver computed store digests.
+const storeDigestFirstTick = 13752150
+
+// recomputeDigestsRange recomputes the chain and store digest of every tick in the range the same way the v1 archiver
+// computed them, but from the migrated records, and compares them with the migrated digests.
+//
+// Like the archiver, every tick is chained to the stored digest of the previous tick, so a mismatch points at the tick
+// whose records differ instead of cascading to the end of the epoch. The first tick of the epoch, and any tick whose
+// previous tick has no digest, is chained to an empty digest.
+func (v *Verifier) recomputeDigestsRange(ctx context.Context, ev *EpochVerification, tickRange v1.TickRange, firstRange bool, newStore EpochSink) error {
+
+	bar := progressbar.Default(int64(tickRange.End-tickRange.Start)+1, fmt.Sprintf("Recomputing digests ticks %d to %d", tickRange.Start, tickRange.End))
+
+	return newStore.IterateTicks(archiverV2Store.QuorumData, tickRange, func(tickNumber uint32, value []byte) error {
+		_ = bar.Add(1)
+
+		var quorumData protoV2.QuorumTickDataStored
+		err := proto.Unmarshal(value, &quorumData)
+		if err != nil {
+			return fmt.Errorf("unmarshaling v2 quorum data for tick %d: %w", tickNumber, err)
+		}
+
+		chainDigest, found, err := getDigest(newStore, v2.ChainDigest, tickNumber)
+		if err != nil {
+			return fmt.Errorf("getting chain digest for tick %d: %w", tickNumber, err)
+		}
+		// Missing digests are already reported when the migrated digests are compared with v1.
+		if found {
+			previous, err := previousDigest(newStore, v2.ChainDigest, tickNumber, firstRange && tickNumber == tickRange.Start)
+			if err != nil {
+				return fmt.Errorf("getting previous chain digest for tick %d: %w", tickNumber, err)
+			}
+
+			recomputed, err := computeChainDigest(quorumData.QuorumTickStructure, previous)
+			if err != nil {
+				return fmt.Errorf("computing chain digest for tick %d: %w", tickNumber, err)
+			}
+
+			ev.Checked[DataTypeRecomputedChainDigest]++
+			if !bytes.Equal(chainDigest, recomputed[:]) {
+				ev.addMismatch(DataTypeRecomputedChainDigest, fmt.Sprint(tickNumber), MismatchDifferent, fmt.Sprintf("stored %x, recomputed %x", chainDigest, recomputed))
+			}
+		}
+
+		if tickNumber < storeDigestFirstTick {
+			return nil
+		}
+
+		storeDigest, found, err := getDigest(newStore, v2.StoreDigest, tickNumber)
+		if err != nil {
+			return fmt.Errorf("getting store digest for tick %d: %w", tickNumber, err)
+		}
+		if !found {
+			return nil
+		}
+
+		previous, err := previousDigest(newStore, v2.StoreDigest, tickNumber, firstRange && tickNumber == tickRange.Start)
+		if err != nil {
+			return fmt.Errorf("getting previous store digest for tick %d: %w", tickNumber, err)
+		}
+
+		transactions, tickTxsStatus, err := migratedTickTransactions(newStore, tickNumber)
+		if err != nil {
+			return fmt.Errorf("getting migrated transactions for tick %d: %w", tickNumber, err)
+		}
+
+		store := chain.Store{
+			PreviousTickStoreDigest: previous,
+			ValidTxs:                transactions,
+			TickTxsStatus:           tickTxsStatus,
+		}
+		recomputed, err := store.Digest()
+		if err != nil {
+			return fmt.Errorf("computing store digest for tick %d: %w", tickNumber, err)
+		}
+
+		ev.Checked[DataTypeRecomputedStoreDigest]++
+		if !bytes.Equal(storeDigest, recomputed[:]) {
+			ev.addMismatch(DataTypeRecomputedStoreDigest, fmt.Sprint(tickNumber), MismatchDifferent, fmt.Sprintf("stored %x, recomputed %x", storeDigest, recomputed))
+		}
+		return nil
+	})
+}
+
+func getDigest(newStore EpochSink, prefix int, tickNumber uint32) ([]byte, bool, error) {
+	return newStore.GetRecord(migratorStore.AssembleKey(prefix, tickNumber))
+}
+
+func previousDigest(newStore EpochSink, prefix int, tickNumber uint32, initialTick bool) ([32]byte, error) {
+	var previous [32]byte
+	if initialTick {
+		return previous, nil
+	}
+
+	stored, found, err := getDigest(newStore, prefix, tickNumber-1)
+	if err != nil {
+		return previous, err
+	}
+	if found {
+		copy(previous[:], stored)
+	}
+	return previous, nil
+}
+
+func computeChainDigest(structure *protoV2.QuorumTickStructure, previous [32]byte) ([32]byte, error) {
+	if structure == nil {
+		return [32]byte{}, errors.New("quorum tick structure is missing")
+	}
+
+	resourceTestingDigest, err := decodeHexUint32(structure.PrevResourceTestingDigestHex)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("decoding previous resource testing digest: %w", err)
+	}
+	transactionBodyDigest, err := decodeHexUint32(structure.PrevTransactionBodyHex)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("decoding previous transaction body digest: %w", err)
+	}
+	spectrumDigest, err := decodeHexDigest(structure.PrevSpectrumDigestHex)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("decoding previous spectrum digest: %w", err)
+	}
+	universeDigest, err := decodeHexDigest(structure.PrevUniverseDigestHex)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("decoding previous universe digest: %w", err)
+	}
+	computerDigest, err := decodeHexDigest(structure.PrevComputerDigestHex)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("decoding previous computer digest: %w", err)
+	}
+	txDigest, err := decodeHexDigest(structure.TxDigestHex)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("decoding tx digest: %w", err)
+	}
+
+	// The archiver stores the vote date as a unix timestamp in milliseconds, with the year counted from 2000.
+	date := time.UnixMilli(int64(structure.Timestamp)).UTC()
+
+	c := chain.Chain{
+		Epoch:                         uint16(structure.Epoch),
+		Tick:                          structure.TickNumber,
+		Millisecond:                   uint16(date.Nanosecond() / int(time.Millisecond)),
+		Second:                        uint8(date.Second()),
+		Minute:                        uint8(date.Minute()),
+		Hour:                          uint8(date.Hour()),
+		Day:                           uint8(date.Day()),
+		Month:                         uint8(date.Month()),
+		Year:                          uint8(date.Year() - 2000),
+		PreviousResourceTestingDigest: resourceTestingDigest,
+		PreviousTransactionBodyDigest: transactionBodyDigest,
+		PreviousSpectrumDigest:        spectrumDigest,
+		PreviousUniverseDigest:        universeDigest,
+		PreviousComputerDigest:        computerDigest,
+		TxDigest:                      txDigest,
+		PreviousTickChainDigest:       previous,
+	}
+	return c.Digest()
+}
+
+// migratedTickTransactions returns the migrated transactions of the tick, in the form the v1 archiver hashed them into
+// the store digest.
+func migratedTickTransactions(newStore EpochSink, tickNumber uint32) ([]types.Transaction, *protoV1.TickTransactionsStatus, error) {
+
+	var tickData protoV2.TickData
+	_, err := getV2Record(newStore, migratorStore.AssembleKey(archiverV2Store.TickData, tickNumber), &tickData)
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting v2 tick data: %w", err)
+	}
+
+	transactions := make([]types.Transaction, 0, len(tickData.TransactionIds))
+	for _, txId := range tickData.TransactionIds {
+		var tx protoV2.Transaction
+		found, err := getV2Record(newStore, migratorStore.AssembleKey(archiverV2Store.Transaction, txId), &tx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("getting v2 transaction %s: %w", txId, err)
+		}
+		// Missing transactions are already reported by the transaction verification.
+		if !found {
+			continue
+		}
+
+		transaction, err := transactionV2ToQubic(&tx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("converting transaction %s: %w", txId, err)
+		}
+		transactions = append(transactions, transaction)
+	}
+
+	var ttsV2 protoV2.TickTransactionsStatus
+	_, err = getV2Record(newStore, migratorStore.AssembleKey(archiverV2Store.TickTransactionsStatus, uint64(tickNumber)), &ttsV2)
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting v2 tick transactions status: %w", err)
+	}
+
+	ttsV1 := protoV1.TickTransactionsStatus{}
+	for _, status := range ttsV2.Transactions {
+		ttsV1.Transactions = append(ttsV1.Transactions, &protoV1.TransactionStatus{
+			TxId:      status.TxId,
+			MoneyFlew: status.MoneyFlew,
+		})
+	}
+
+	return transactions, &ttsV1, nil
+}
+
+func transactionV2ToQubic(tx *protoV2.Transaction) (types.Transaction, error) {
+	source := types.Identity(tx.SourceId)
+	sourcePublicKey, err := source.ToPubKey(false)
+	if err != nil {
+		return types.Transaction{}, fmt.Errorf("decoding source id: %w", err)
+	}
+
+	destination := types.Identity(tx.DestId)
+	destinationPublicKey, err := destination.ToPubKey(false)
+	if err != nil {
+		return types.Transaction{}, fmt.Errorf("decoding destination id: %w", err)
+	}
+
+	input, err := hex.DecodeString(tx.InputHex)
+	if err != nil {
+		return types.Transaction{}, fmt.Errorf("decoding input: %w", err)
+	}
+
+	signature, err := hex.DecodeString(tx.SignatureHex)
+	if err != nil {
+		return types.Transaction{}, fmt.Errorf("decoding signature: %w", err)
+	}
+	if len(signature) != 64 {
+		return types.Transaction{}, fmt.Errorf("invalid signature length %d", len(signature))
+	}
+
+	transaction := types.Transaction{
+		SourcePublicKey:      sourcePublicKey,
+		DestinationPublicKey: destinationPublicKey,
+		Amount:               tx.Amount,
+		Tick:                 tx.TickNumber,
+		InputType:            uint16(tx.InputType),
+		InputSize:            uint16(tx.InputSize),
+		Input:                input,
+	}
+	copy(transaction.Signature[:], signature)
+	return transaction, nil
+}
+
+func decodeHexDigest(value string) ([32]byte, error) {
+	var digest [32]byte
+	decoded, err := hex.DecodeString(value)
+	if err != nil {
+		return digest, err
+	}
+	if len(decoded) != len(digest) {
+		return digest, fmt.Errorf("invalid digest length %d", len(decoded))
+	}
+	copy(digest[:], decoded)
+	return digest, nil
+}
+
+// decodeHexUint32 decodes the little endian hex encoding the archiver uses for the 4 byte digests.
+func decodeHexUint32(value string) (uint32, error) {
+	decoded, err := hex.DecodeString(value)
+	if err != nil {
+		return 0, err
+	}
+	if len(decoded) != 4 {
+		return 0, fmt.Errorf("invalid length %d", len(decoded))
+	}
+	return binary.LittleEndian.Uint32(decoded), nil
+}
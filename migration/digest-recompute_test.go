@@ -0,0 +1,96 @@
+package migration
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	protoV2 "github.com/qubic/go-archiver-v2/protobuf"
+	"github.com/qubic/go-archiver/validator/chain"
+)
+
+func TestComputeChainDigest(t *testing.T) {
+	digestHex := func(b byte) string {
+		return hex.EncodeToString(bytes.Repeat([]byte{b}, 32))
+	}
+	digest := func(b byte) [32]byte {
+		var d [32]byte
+		copy(d[:], bytes.Repeat([]byte{b}, 32))
+		return d
+	}
+
+	timestamp := time.Date(2024, time.December, 12, 10, 20, 30, 456*int(time.Millisecond), time.UTC)
+	structure := &protoV2.QuorumTickStructure{
+		Epoch:                        158,
+		TickNumber:                   13752100,
+		Timestamp:                    uint64(timestamp.UnixMilli()),
+		PrevResourceTestingDigestHex: "01020304",
+		PrevTransactionBodyHex:       "0a0b0c0d",
+		PrevSpectrumDigestHex:        digestHex(1),
+		PrevUniverseDigestHex:        digestHex(2),
+		PrevComputerDigestHex:        digestHex(3),
+		TxDigestHex:                  digestHex(4),
+	}
+	previous := digest(5)
+
+	c := chain.Chain{
+		Epoch:                         158,
+		Tick:                          13752100,
+		Millisecond:                   456,
+		Second:                        30,
+		Minute:                        20,
+		Hour:                          10,
+		Day:                           12,
+		Month:                         12,
+		Year:                          24,
+		PreviousResourceTestingDigest: 0x04030201,
+		PreviousTransactionBodyDigest: 0x0d0c0b0a,
+		PreviousSpectrumDigest:        digest(1),
+		PreviousUniverseDigest:        digest(2),
+		PreviousComputerDigest:        digest(3),
+		TxDigest:                      digest(4),
+		PreviousTickChainDigest:       previous,
+	}
+	expected, err := c.Digest()
+	if err != nil {
+		t.Fatalf("computing expected digest: %v", err)
+	}
+
+	computed, err := computeChainDigest(structure, previous)
+	if err != nil {
+		t.Fatalf("computing chain digest: %v", err)
+	}
+	if computed != expected {
+		t.Fatalf("got chain digest %x, expected %x", computed, expected)
+	}
+
+	_, err = computeChainDigest(nil, previous)
+	if err == nil {
+		t.Fatal("expected an error for a missing quorum tick structure")
+	}
+
+	structure.TxDigestHex = digestHex(4)[2:]
+	_, err = computeChainDigest(structure, previous)
+	if err == nil || !strings.Contains(err.Error(), "tx digest") {
+		t.Fatalf("got error %v for a short tx digest", err)
+	}
+}
+
+func TestDecodeHexUint32(t *testing.T) {
+	value, err := decodeHexUint32("78563412")
+	if err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	if value != 0x12345678 {
+		t.Fatalf("got %#x, expected the little endian value 0x12345678", value)
+	}
+
+	for _, invalid := range []string{"", "123456", "1234567890", "zz563412"} {
+		_, err := decodeHexUint32(invalid)
+		if err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
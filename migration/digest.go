@@ -0,0 +1,88 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	migratorStore "github.com/qubic/archiver-db-migrator/store"
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+	v2 "github.com/qubic/archiver-db-migrator/store/v2"
+	archiverV1Store "github.com/qubic/go-archiver/store"
+)
+
+// digestLength is the length of the K12 digests the v1 archiver stores per tick.
+const digestLength = 32
+
+// digestType describes one of the per tick digest chains of the v1 archiver.
+type digestType struct {
+	description    string
+	oldPrefix      int
+	newPrefix      int
+	checkpointType byte
+}
+
+var digestTypes = []digestType{
+	{
+		description:    "chain digest",
+		oldPrefix:      archiverV1Store.ChainDigest,
+		newPrefix:      v2.ChainDigest,
+		checkpointType: v2.CheckpointChainDigest,
+	},
+	{
+		description:    "store digest",
+		oldPrefix:      archiverV1Store.StoreDigest,
+		newPrefix:      v2.StoreDigest,
+		checkpointType: v2.CheckpointStoreDigest,
+	},
+}
+
+// MigrateDigests copies the chain and store digests of the epoch's ticks into the epoch store. The digests are raw
+// bytes, so they are copied unchanged under the migrator owned prefixes.
+func (m *Migrator) MigrateDigests(ctx context.Context, epochMetadata v1.EpochMetadata, newStore EpochSink) error {
+	for _, digest := range digestTypes {
+		for _, tickRange := range epochMetadata.ProcessedTickRanges {
+			startTick, err := resumeTick(newStore, digest.checkpointType, tickRange)
+			if err != nil {
+				return fmt.Errorf("getting %s checkpoint for tick range %v for epoch %d: %w", digest.description, tickRange, epochMetadata.Epoch, err)
+			}
+
+			if startTick > tickRange.End {
+				log.Printf("The %s for tick range %v has already been migrated, skipping.\n", digest.description, tickRange)
+				continue
+			}
+
+			err = m.migrateDigestRange(ctx, digest, tickRange, startTick, newStore)
+			if err != nil {
+				m.reporter.dataTypeFailed(epochMetadata.Epoch, digest.checkpointType, err)
+				return fmt.Errorf("migrating %s range %v for epoch %d: %w", digest.description, tickRange, epochMetadata.Epoch, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) migrateDigestRange(ctx context.Context, digest digestType, tickRange v1.TickRange, startTick uint32, newStore EpochSink) error {
+
+	pipeline := rangePipeline{
+		description:    digest.description,
+		oldPrefix:      digest.oldPrefix,
+		checkpointType: digest.checkpointType,
+		checkpointFrom: startTick,
+		convert: func(record sourceRecord) (convertedRecord, error) {
+			if len(record.value) != digestLength {
+				return convertedRecord{}, fmt.Errorf("invalid %s length %d for tick %d", digest.description, len(record.value), record.tickNumber)
+			}
+
+			return convertedRecord{
+				tickNumber: record.tickNumber,
+				data:       record.value,
+			}, nil
+		},
+		write: func(writer rangeWriter, record convertedRecord) error {
+			return writer.Set(migratorStore.AssembleKey(digest.newPrefix, record.tickNumber), record.data)
+		},
+	}
+
+	return m.runRangePipeline(ctx, tickRange, startTick, pipeline, newStore)
+}
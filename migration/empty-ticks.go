@@ -0,0 +1,52 @@
+package migration
+
+import (
+	"fmt"
+	"log"
+
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+	archiverV2Store "github.com/qubic/go-archiver-v2/db"
+)
+
+// CheckEmptyTicksCount compares the migrated empty ticks count with the number of empty tick data records in the epoch
+// store. A difference is logged and recorded as an error of the empty ticks data type in the report, it does not fail
+// the migration. The count is copied from v1 as it is, so a difference means the v1 count was already out of date.
+func (m *Migrator) CheckEmptyTicksCount(epochMetadata v1.EpochMetadata, newStore EpochSink) error {
+	storedCount, exists, err := newStore.GetEmptyTicksCount(epochMetadata.Epoch)
+	if err != nil {
+		return fmt.Errorf("getting empty ticks count: %w", err)
+	}
+	if !exists {
+		return nil
+	}
+
+	foundCount, err := countEmptyTickData(newStore)
+	if err != nil {
+		return fmt.Errorf("counting empty tick data records: %w", err)
+	}
+
+	if foundCount != storedCount {
+		log.Printf("WARNING: epoch %d has a stored empty ticks count of %d, but %d empty tick data records were found.\n", epochMetadata.Epoch, storedCount, foundCount)
+		m.reporter.checkFailed(epochMetadata.Epoch, DataTypeEmptyTicksPerEpoch, fmt.Errorf("stored empty ticks count %d does not match %d empty tick data records", storedCount, foundCount))
+		return nil
+	}
+
+	log.Printf("Empty ticks count of %d for epoch %d matches the tick data.\n", storedCount, epochMetadata.Epoch)
+	return nil
+}
+
+// countEmptyTickData counts the tick data records that hold no data. The v1 archiver stores an empty message for
+// empty ticks, which marshals to zero bytes.
+func countEmptyTickData(newStore EpochSink) (uint32, error) {
+	var count uint32
+	err := newStore.IterateRecords(archiverV2Store.TickData, func(_, value []byte) error {
+		if len(value) == 0 {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
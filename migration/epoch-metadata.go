@@ -1,44 +1,63 @@
 package migration
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"slices"
 
+	pebbleV1 "github.com/cockroachdb/pebble"
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
 	v2 "github.com/qubic/archiver-db-migrator/store/v2"
 	"github.com/qubic/go-archiver-v2/protobuf"
+	protoV1 "github.com/qubic/go-archiver/protobuff"
+	archiverV1Store "github.com/qubic/go-archiver/store"
 )
 
-func (m *Migrator) MigrateEpochMetadata(epoch uint32, newStore *v2.ArchiverEpochStoreV2) error {
+func (m *Migrator) MigrateEpochMetadata(ctx context.Context, epoch uint32, newStore EpochSink) error {
 	log.Printf("Migrating metadata for epoch %d\n", epoch)
 
 	log.Println("Migrating computor list...")
-	err := m.MigrateComputorList(epoch, newStore)
+	err := m.MigrateComputorList(ctx, epoch, newStore)
 	if err != nil {
 		return fmt.Errorf("migrating computor list for epoch %d: %w", epoch, err)
 	}
 
 	log.Println("Migrating processed tick ranges...")
-	err = m.MigrateProcessedTickRanges(epoch, newStore)
+	err = m.MigrateProcessedTickRanges(ctx, epoch, newStore)
 	if err != nil {
 		return fmt.Errorf("migrating processed tick ranges for epoch %d: %w", epoch, err)
 	}
 
 	log.Println("Migrating last processed tick...")
-	err = m.MigrateLastProcessedTick(epoch, newStore)
+	err = m.MigrateLastProcessedTick(ctx, epoch, newStore)
 	if err != nil {
 		return fmt.Errorf("migrating last processed tick for epoch %d: %w", epoch, err)
 	}
 
 	log.Println("Migrating tick range last tick quorum data...")
-	err = m.MigrateTickRangeLastTickQuorumData(epoch, newStore)
+	err = m.MigrateTickRangeLastTickQuorumData(ctx, epoch, newStore)
 	if err != nil {
 		return fmt.Errorf("migrating tick range last tick quorum data for epoch %d: %w", epoch, err)
 	}
 
+	log.Println("Migrating empty ticks count...")
+	err = m.MigrateEmptyTicksCount(ctx, epoch, newStore)
+	if err != nil {
+		return fmt.Errorf("migrating empty ticks count for epoch %d: %w", epoch, err)
+	}
+
+	log.Println("Migrating skipped ticks intervals...")
+	err = m.MigrateSkippedTicksIntervals(ctx, epoch, newStore)
+	if err != nil {
+		return fmt.Errorf("migrating skipped ticks intervals for epoch %d: %w", epoch, err)
+	}
+
 	if epoch > 158 {
 		log.Println("Migrating target tick vote signature...")
-		err = m.MigrateTargetTickVoteSignature(epoch, newStore)
+		err = m.MigrateTargetTickVoteSignature(ctx, epoch, newStore)
 		if err != nil {
 			return fmt.Errorf("migrating target tick vote signature for epoch %d: %w", epoch, err)
 		}
@@ -48,23 +67,13 @@ func (m *Migrator) MigrateEpochMetadata(epoch uint32, newStore *v2.ArchiverEpoch
 	return nil
 }
 
-func (m *Migrator) MigrateComputorList(epoch uint32, newStore *v2.ArchiverEpochStoreV2) error {
-	computors, err := m.oldStore.ArchiverStore.GetComputors(context.Background(), epoch)
+func (m *Migrator) MigrateComputorList(ctx context.Context, epoch uint32, newStore EpochSink) error {
+	computors, err := m.source.GetComputors(ctx, epoch)
 	if err != nil {
 		return fmt.Errorf("getting computors for epoch %d: %w", epoch, err)
 	}
 
-	computorsList := protobuf.ComputorsList{
-		Computors: make([]*protobuf.Computors, 0),
-	}
-
-	computorsList.Computors = append(computorsList.Computors, &protobuf.Computors{
-		Epoch:        computors.Epoch,
-		Identities:   computors.Identities,
-		SignatureHex: computors.SignatureHex,
-	})
-
-	err = newStore.ArchiverStore.SetComputors(context.Background(), epoch, &computorsList)
+	err = newStore.SetComputors(ctx, epoch, computorsV1ToV2(computors))
 	if err != nil {
 		return fmt.Errorf("storing computors for epoch %d: %w", epoch, err)
 	}
@@ -72,45 +81,27 @@ func (m *Migrator) MigrateComputorList(epoch uint32, newStore *v2.ArchiverEpochS
 	return nil
 }
 
-func (m *Migrator) MigrateProcessedTickRanges(epoch uint32, newStore *v2.ArchiverEpochStoreV2) error {
-	ranges, err := m.oldStore.ArchiverStore.GetProcessedTickIntervals(context.Background())
+func (m *Migrator) MigrateProcessedTickRanges(ctx context.Context, epoch uint32, newStore EpochSink) error {
+	ranges, err := m.source.GetProcessedTickIntervals(ctx)
 	if err != nil {
 		return fmt.Errorf("getting processed tick intervals: %w", err)
 	}
 
-	var epochRanges []*protobuf.ProcessedTickInterval
-	for _, e := range ranges {
-		if e.Epoch != epoch {
-			continue
-		}
-
-		for _, interval := range e.Intervals {
-			epochRanges = append(epochRanges, &protobuf.ProcessedTickInterval{
-				InitialProcessedTick: interval.InitialProcessedTick,
-				LastProcessedTick:    interval.LastProcessedTick,
-			})
-		}
-
-	}
-	if len(epochRanges) == 0 {
+	rangesV2 := processedTickIntervalsV1ToV2(epoch, ranges)
+	if len(rangesV2.Intervals) == 0 {
 		return fmt.Errorf("failed to find processed tick intervals for epoch %d", epoch)
 	}
 
-	rangesV2 := protobuf.ProcessedTickIntervalsPerEpoch{
-		Epoch:     epoch,
-		Intervals: epochRanges,
-	}
-
-	err = newStore.ArchiverStore.SetProcessedTickIntervalPerEpoch(context.Background(), epoch, &rangesV2)
+	err = newStore.SetProcessedTickIntervalPerEpoch(ctx, epoch, rangesV2)
 	if err != nil {
 		return fmt.Errorf("storing processed tick intervals for epoch %d: %w", epoch, err)
 	}
 	return nil
 }
 
-func (m *Migrator) MigrateLastProcessedTick(epoch uint32, newStore *v2.ArchiverEpochStoreV2) error {
-	lastProcessedTick := m.oldStore.StoreMetadata.Epochs[epoch].LastProcessedTick
-	err := newStore.ArchiverStore.SetLastProcessedTick(context.Background(), &protobuf.ProcessedTick{
+func (m *Migrator) MigrateLastProcessedTick(ctx context.Context, epoch uint32, newStore EpochSink) error {
+	lastProcessedTick := m.source.Metadata().Epochs[epoch].LastProcessedTick
+	err := newStore.SetLastProcessedTick(ctx, &protobuf.ProcessedTick{
 		TickNumber: lastProcessedTick,
 		Epoch:      epoch,
 	})
@@ -120,16 +111,158 @@ func (m *Migrator) MigrateLastProcessedTick(epoch uint32, newStore *v2.ArchiverE
 	return nil
 }
 
-func (m *Migrator) MigrateTickRangeLastTickQuorumData(epoch uint32, newStore *v2.ArchiverEpochStoreV2) error {
+func (m *Migrator) MigrateTickRangeLastTickQuorumData(ctx context.Context, epoch uint32, newStore EpochSink) error {
 
-	lastTickQuorumDataPerEpochInterval, err := m.oldStore.ArchiverStore.GetLastTickQuorumDataListPerEpochInterval(epoch)
+	lastTickQuorumDataPerEpochInterval, err := m.source.GetLastTickQuorumDataListPerEpochInterval(epoch)
 	if err != nil {
 		return fmt.Errorf("getting last tick quorum data list for epoch %d: %w", epoch, err)
 	}
 
+	err = newStore.SetLastTickQuorumDataPerEpochIntervals(epoch, lastTickQuorumDataV1ToV2(lastTickQuorumDataPerEpochInterval))
+	if err != nil {
+		return fmt.Errorf("storing last tick quorum data list for epoch %d: %w", epoch, err)
+	}
+	return nil
+}
+
+func (m *Migrator) MigrateTargetTickVoteSignature(ctx context.Context, epoch uint32, newStore EpochSink) error {
+	targetTickVoteSignature, err := m.source.GetTargetTickVoteSignature(epoch)
+	if err != nil {
+		return fmt.Errorf("getting target tick vote signature for epoch %d: %w", epoch, err)
+	}
+
+	err = newStore.SetTargetTickVoteSignature(epoch, targetTickVoteSignature)
+	if err != nil {
+		return fmt.Errorf("storing target tick vote signature for epoch %d: %w", epoch, err)
+	}
+
+	return nil
+}
+
+// MigrateEmptyTicksCount copies the empty ticks count of the epoch. The v1 archiver computes the count once an epoch is
+// over, so it may be missing for the latest epochs.
+func (m *Migrator) MigrateEmptyTicksCount(ctx context.Context, epoch uint32, newStore EpochSink) error {
+	emptyTicks, err := m.source.GetEmptyTicksForEpoch(epoch)
+	if err != nil {
+		if errors.Is(err, pebbleV1.ErrNotFound) {
+			log.Printf("No empty ticks count stored for epoch %d, skipping.\n", epoch)
+			return nil
+		}
+		return fmt.Errorf("getting empty ticks count for epoch %d: %w", epoch, err)
+	}
+
+	err = newStore.SetEmptyTicksCount(epoch, emptyTicks)
+	if err != nil {
+		return fmt.Errorf("storing empty ticks count for epoch %d: %w", epoch, err)
+	}
+	return nil
+}
+
+// MigrateSkippedTicksIntervals copies the skipped tick intervals that belong to the epoch. v1 keeps a single list for
+// the whole archive.
+func (m *Migrator) MigrateSkippedTicksIntervals(ctx context.Context, epoch uint32, newStore EpochSink) error {
+	intervals, err := epochSkippedTicksIntervals(ctx, m.source, epoch)
+	if err != nil {
+		return err
+	}
+
+	err = newStore.SetSkippedTicksIntervals(intervals)
+	if err != nil {
+		return fmt.Errorf("storing skipped ticks intervals for epoch %d: %w", epoch, err)
+	}
+	return nil
+}
+
+func epochSkippedTicksIntervals(ctx context.Context, source Source, epoch uint32) ([]v2.SkippedTicksInterval, error) {
+	skippedTicks, err := source.GetSkippedTicksInterval(ctx)
+	if err != nil {
+		if errors.Is(err, archiverV1Store.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getting skipped ticks intervals: %w", err)
+	}
+
+	return skippedTicksIntervalsForEpoch(skippedTicks, source.Metadata().Epochs[epoch].ProcessedTickRanges), nil
+}
+
+// skippedTicksIntervalsForEpoch returns the intervals that belong to the epoch with the given processed tick ranges.
+// The v1 archiver records a skipped interval when it starts a new processed range, so an interval belongs to the epoch
+// if it ends right before one of its ranges, or if it lies between the first and the last processed tick of the epoch.
+func skippedTicksIntervalsForEpoch(skippedTicks *protoV1.SkippedTicksIntervalList, tickRanges []v1.TickRange) []v2.SkippedTicksInterval {
+	if len(tickRanges) == 0 {
+		return nil
+	}
+
+	firstTick, lastTick := tickRanges[0].Start, tickRanges[0].End
+	for _, tickRange := range tickRanges {
+		firstTick = min(firstTick, tickRange.Start)
+		lastTick = max(lastTick, tickRange.End)
+	}
+
+	var intervals []v2.SkippedTicksInterval
+	for _, skipped := range skippedTicks.SkippedTicks {
+		belongs := skipped.StartTick > firstTick && skipped.EndTick < lastTick
+		for _, tickRange := range tickRanges {
+			if uint64(skipped.EndTick)+1 == uint64(tickRange.Start) {
+				belongs = true
+			}
+		}
+		if !belongs {
+			continue
+		}
+
+		intervals = append(intervals, v2.SkippedTicksInterval{
+			StartTick: skipped.StartTick,
+			EndTick:   skipped.EndTick,
+		})
+	}
+
+	slices.SortFunc(intervals, func(a, b v2.SkippedTicksInterval) int {
+		return cmp.Compare(a.StartTick, b.StartTick)
+	})
+	return intervals
+}
+
+func computorsV1ToV2(computors *protoV1.Computors) *protobuf.ComputorsList {
+	computorsList := protobuf.ComputorsList{
+		Computors: make([]*protobuf.Computors, 0),
+	}
+
+	computorsList.Computors = append(computorsList.Computors, &protobuf.Computors{
+		Epoch:        computors.Epoch,
+		Identities:   computors.Identities,
+		SignatureHex: computors.SignatureHex,
+	})
+
+	return &computorsList
+}
+
+func processedTickIntervalsV1ToV2(epoch uint32, ranges []*protoV1.ProcessedTickIntervalsPerEpoch) *protobuf.ProcessedTickIntervalsPerEpoch {
+	var epochRanges []*protobuf.ProcessedTickInterval
+	for _, e := range ranges {
+		if e.Epoch != epoch {
+			continue
+		}
+
+		for _, interval := range e.Intervals {
+			epochRanges = append(epochRanges, &protobuf.ProcessedTickInterval{
+				InitialProcessedTick: interval.InitialProcessedTick,
+				LastProcessedTick:    interval.LastProcessedTick,
+			})
+		}
+
+	}
+
+	return &protobuf.ProcessedTickIntervalsPerEpoch{
+		Epoch:     epoch,
+		Intervals: epochRanges,
+	}
+}
+
+func lastTickQuorumDataV1ToV2(lastTickQuorumDataV1 *protoV1.LastTickQuorumDataPerEpochIntervals) *protobuf.LastTickQuorumDataPerEpochIntervals {
 	var lastTickQuorumDataPerEpochIntervalV2 protobuf.LastTickQuorumDataPerEpochIntervals
 	lastTickQuorumDataPerEpochIntervalV2.QuorumDataPerInterval = make(map[int32]*protobuf.QuorumTickData)
-	for index, quorumData := range lastTickQuorumDataPerEpochInterval.QuorumDataPerInterval {
+	for index, quorumData := range lastTickQuorumDataV1.QuorumDataPerInterval {
 
 		quorumTickStructure := protobuf.QuorumTickStructure{
 			Epoch:                        quorumData.QuorumTickStructure.Epoch,
@@ -162,23 +295,5 @@ func (m *Migrator) MigrateTickRangeLastTickQuorumData(epoch uint32, newStore *v2
 		}
 	}
 
-	err = newStore.ArchiverStore.SetLastTickQuorumDataPerEpochIntervals(epoch, &lastTickQuorumDataPerEpochIntervalV2)
-	if err != nil {
-		return fmt.Errorf("storing last tick quorum data list for epoch %d: %w", epoch, err)
-	}
-	return nil
-}
-
-func (m *Migrator) MigrateTargetTickVoteSignature(epoch uint32, newStore *v2.ArchiverEpochStoreV2) error {
-	targetTickVoteSignature, err := m.oldStore.ArchiverStore.GetTargetTickVoteSignature(epoch)
-	if err != nil {
-		return fmt.Errorf("getting target tick vote signature for epoch %d: %w", epoch, err)
-	}
-
-	err = newStore.ArchiverStore.SetTargetTickVoteSignature(epoch, targetTickVoteSignature)
-	if err != nil {
-		return fmt.Errorf("storing target tick vote signature for epoch %d: %w", epoch, err)
-	}
-
-	return nil
+	return &lastTickQuorumDataPerEpochIntervalV2
 }
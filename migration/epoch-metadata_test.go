@@ -0,0 +1,50 @@
+package migration
+
+import (
+	"slices"
+	"testing"
+
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+	v2 "github.com/qubic/archiver-db-migrator/store/v2"
+	protoV1 "github.com/qubic/go-archiver/protobuff"
+)
+
+func TestSkippedTicksIntervalsForEpoch(t *testing.T) {
+	skippedTicks := &protoV1.SkippedTicksIntervalList{
+		SkippedTicks: []*protoV1.SkippedTicksInterval{
+			// Gap before the first range of the epoch, ending right before it.
+			{StartTick: 9000, EndTick: 9999},
+			// Gap between the two ranges of the epoch.
+			{StartTick: 10501, EndTick: 10999},
+			// Gap of a previous epoch.
+			{StartTick: 5000, EndTick: 5999},
+			// Gap of a later epoch.
+			{StartTick: 12000, EndTick: 12999},
+		},
+	}
+	tickRanges := []v1.TickRange{
+		{Start: 11000, End: 11500},
+		{Start: 10000, End: 10500},
+	}
+
+	intervals := skippedTicksIntervalsForEpoch(skippedTicks, tickRanges)
+
+	expected := []v2.SkippedTicksInterval{
+		{StartTick: 9000, EndTick: 9999},
+		{StartTick: 10501, EndTick: 10999},
+	}
+	if !slices.Equal(intervals, expected) {
+		t.Fatalf("got intervals %v, expected %v", intervals, expected)
+	}
+}
+
+func TestSkippedTicksIntervalsForEpochWithoutRanges(t *testing.T) {
+	skippedTicks := &protoV1.SkippedTicksIntervalList{
+		SkippedTicks: []*protoV1.SkippedTicksInterval{{StartTick: 1, EndTick: 2}},
+	}
+
+	intervals := skippedTicksIntervalsForEpoch(skippedTicks, nil)
+	if len(intervals) != 0 {
+		t.Fatalf("got intervals %v for an epoch without tick ranges", intervals)
+	}
+}
@@ -0,0 +1,156 @@
+package migration
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+)
+
+// latestEpoch is the name of the most recent epoch of the v1 store in epoch selectors.
+const latestEpoch = "latest"
+
+// ResolveEpochSelector returns the epochs of the v1 store metadata selected by the selector, in ascending order.
+//
+// A selector is a comma separated list of terms. A term is an epoch, or a range of epochs written as start-end or
+// start..end, with both bounds included. Epochs and bounds are either a number, latest for the most recent epoch of the
+// store, or latest-n for the epoch n below it. A range starting at latest or latest-n has to be written with .. as
+// separator. A term prefixed with ! excludes its epochs from the selection, for example
+// 100-120,130,!125,latest-5..latest. Ranges only select the epochs the store has, a single epoch that the store does not
+// have is an error.
+func ResolveEpochSelector(selector string, metadata v1.StoreMetadata) ([]uint32, error) {
+	known := slices.Sorted(maps.Keys(metadata.Epochs))
+	if len(known) == 0 {
+		return nil, fmt.Errorf("the store has no epochs")
+	}
+	latest := known[len(known)-1]
+
+	selected := make(map[uint32]bool)
+	excluded := make(map[uint32]bool)
+
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		exclude := strings.HasPrefix(term, "!")
+		term = strings.TrimSpace(strings.TrimPrefix(term, "!"))
+		if term == "" {
+			return nil, fmt.Errorf("empty term in epoch selector %q", selector)
+		}
+
+		start, end, isRange, err := parseEpochTerm(term, latest)
+		if err != nil {
+			return nil, fmt.Errorf("parsing epoch selector term %q: %w", term, err)
+		}
+		if start > end {
+			return nil, fmt.Errorf("epoch selector term %q starts after it ends", term)
+		}
+
+		target := selected
+		if exclude {
+			target = excluded
+		}
+
+		if !isRange {
+			if _, exists := metadata.Epochs[start]; !exists && !exclude {
+				return nil, fmt.Errorf("epoch %d of selector term %q not found in the store metadata", start, term)
+			}
+			target[start] = true
+			continue
+		}
+		for _, epoch := range known {
+			if epoch >= start && epoch <= end {
+				target[epoch] = true
+			}
+		}
+	}
+
+	var epochs []uint32
+	for _, epoch := range known {
+		if selected[epoch] && !excluded[epoch] {
+			epochs = append(epochs, epoch)
+		}
+	}
+	return epochs, nil
+}
+
+// parseEpochTerm returns the bounds of a selector term without its exclusion prefix, and whether it is a range.
+func parseEpochTerm(term string, latest uint32) (uint32, uint32, bool, error) {
+	startText, endText, isRange := strings.Cut(term, "..")
+	if !isRange && !strings.HasPrefix(term, latestEpoch) {
+		startText, endText, isRange = strings.Cut(term, "-")
+	}
+	if !isRange {
+		epoch, err := parseEpochBound(term, latest)
+		return epoch, epoch, false, err
+	}
+
+	start, err := parseEpochBound(strings.TrimSpace(startText), latest)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	end, err := parseEpochBound(strings.TrimSpace(endText), latest)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	return start, end, true, nil
+}
+
+// parseEpochBound parses an epoch number, latest or latest-n.
+func parseEpochBound(text string, latest uint32) (uint32, error) {
+	if !strings.HasPrefix(text, latestEpoch) {
+		epoch, err := strconv.ParseUint(text, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid epoch %q", text)
+		}
+		return uint32(epoch), nil
+	}
+
+	offsetText := strings.TrimPrefix(text, latestEpoch)
+	if offsetText == "" {
+		return latest, nil
+	}
+	if !strings.HasPrefix(offsetText, "-") {
+		return 0, fmt.Errorf("invalid epoch %q", text)
+	}
+	offset, err := strconv.ParseUint(offsetText[1:], 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid offset in epoch %q", text)
+	}
+	if uint64(latest) < offset {
+		return 0, fmt.Errorf("epoch %q is below epoch 0, the latest epoch is %d", text, latest)
+	}
+	return latest - uint32(offset), nil
+}
+
+// SkipEpochs removes the live epoch, the most recent epoch of the v1 store that is still being archived, and the
+// epochs whose store is already published from the epochs, as requested. The skipped epochs are logged.
+func (m *Migrator) SkipEpochs(epochs []uint32, skipLiveEpoch, skipPublished bool) ([]uint32, error) {
+	var liveEpoch uint32
+	known := slices.Sorted(maps.Keys(m.source.Metadata().Epochs))
+	if len(known) > 0 {
+		liveEpoch = known[len(known)-1]
+	}
+
+	var remaining []uint32
+	for _, epoch := range epochs {
+		if skipLiveEpoch && len(known) > 0 && epoch == liveEpoch {
+			log.Printf("Skipping epoch %d, it is the live epoch.\n", epoch)
+			continue
+		}
+		if skipPublished {
+			published, err := m.isEpochPublished(epoch)
+			if err != nil {
+				return nil, fmt.Errorf("checking whether epoch %d is published: %w", epoch, err)
+			}
+			if published {
+				log.Printf("Skipping epoch %d, its store is already published.\n", epoch)
+				continue
+			}
+		}
+		remaining = append(remaining, epoch)
+	}
+	return remaining, nil
+}
@@ -0,0 +1,121 @@
+package migration
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+)
+
+func testStoreMetadata(epochs ...uint32) v1.StoreMetadata {
+	metadata := v1.StoreMetadata{Epochs: make(map[uint32]v1.EpochMetadata)}
+	for _, epoch := range epochs {
+		metadata.Epochs[epoch] = v1.EpochMetadata{Epoch: epoch}
+	}
+	return metadata
+}
+
+func TestResolveEpochSelector(t *testing.T) {
+	metadata := testStoreMetadata(0, 100, 101, 102, 105, 110, 120, 125, 130)
+
+	tests := []struct {
+		selector string
+		expected []uint32
+	}{
+		{selector: "101", expected: []uint32{101}},
+		{selector: "0", expected: []uint32{0}},
+		{selector: "100-110", expected: []uint32{100, 101, 102, 105, 110}},
+		{selector: "100..110", expected: []uint32{100, 101, 102, 105, 110}},
+		{selector: "103-104", expected: nil},
+		{selector: "130, 100", expected: []uint32{100, 130}},
+		{selector: "100-130,!101-105,!200", expected: []uint32{100, 110, 120, 125, 130}},
+		{selector: "latest", expected: []uint32{130}},
+		{selector: "latest-10", expected: []uint32{120}},
+		{selector: "latest-10..latest", expected: []uint32{120, 125, 130}},
+		{selector: "100-120,130,!125,latest-5..latest", expected: []uint32{100, 101, 102, 105, 110, 120, 130}},
+	}
+	for _, test := range tests {
+		epochs, err := ResolveEpochSelector(test.selector, metadata)
+		if err != nil {
+			t.Errorf("selector %q: %v", test.selector, err)
+			continue
+		}
+		if !slices.Equal(epochs, test.expected) {
+			t.Errorf("selector %q: got epochs %v, expected %v", test.selector, epochs, test.expected)
+		}
+	}
+}
+
+func TestResolveEpochSelectorErrors(t *testing.T) {
+	metadata := testStoreMetadata(100, 110)
+
+	for _, selector := range []string{"", "100,", "105", "110-100", "abc", "100-x", "latest-200", "latest+1", "latest-5-latest"} {
+		_, err := ResolveEpochSelector(selector, metadata)
+		if err == nil {
+			t.Errorf("selector %q: expected an error", selector)
+		}
+	}
+
+	_, err := ResolveEpochSelector("1", testStoreMetadata())
+	if err == nil {
+		t.Error("expected an error for a store without epochs")
+	}
+}
+
+// metadataSource is a source that only has store metadata.
+type metadataSource struct {
+	Source
+	metadata v1.StoreMetadata
+}
+
+func (s metadataSource) Metadata() v1.StoreMetadata {
+	return s.metadata
+}
+
+func TestSkipEpochs(t *testing.T) {
+	newStorePath := t.TempDir()
+	m := NewMigrator(metadataSource{metadata: testStoreMetadata(100, 101, 102)}, newStorePath, Options{})
+
+	published, err := m.openSink(newStorePath, 100)
+	if err != nil {
+		t.Fatalf("opening epoch store: %v", err)
+	}
+	err = published.MarkEpochComplete()
+	if err != nil {
+		t.Fatalf("marking epoch complete: %v", err)
+	}
+	err = published.Close()
+	if err != nil {
+		t.Fatalf("closing epoch store: %v", err)
+	}
+
+	tests := []struct {
+		skipLiveEpoch bool
+		skipPublished bool
+		expected      []uint32
+	}{
+		{expected: []uint32{100, 101, 102}},
+		{skipLiveEpoch: true, expected: []uint32{100, 101}},
+		{skipPublished: true, expected: []uint32{101, 102}},
+		{skipLiveEpoch: true, skipPublished: true, expected: []uint32{101}},
+	}
+	for _, test := range tests {
+		epochs, err := m.SkipEpochs([]uint32{100, 101, 102}, test.skipLiveEpoch, test.skipPublished)
+		if err != nil {
+			t.Fatalf("skipping epochs: %v", err)
+		}
+		if !slices.Equal(epochs, test.expected) {
+			t.Errorf("skip live epoch %v, skip published %v: got epochs %v, expected %v", test.skipLiveEpoch, test.skipPublished, epochs, test.expected)
+		}
+	}
+}
+
+func TestMigrateEpochRangeResolvesStoreEpochs(t *testing.T) {
+	m := NewMigrator(metadataSource{metadata: testStoreMetadata(100)}, t.TempDir(), Options{})
+
+	err := m.MigrateEpochRange(context.Background(), 200, 210)
+	if err != nil {
+		t.Fatalf("migrating a range without store epochs: %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+
+	"github.com/golang/protobuf/proto"
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+	v2 "github.com/qubic/archiver-db-migrator/store/v2"
+	archiverV2Store "github.com/qubic/go-archiver-v2/db"
+	protoV1 "github.com/qubic/go-archiver/protobuff"
+	archiverV1Store "github.com/qubic/go-archiver/store"
+)
+
+// Policies for epochs whose store already exists in the new store path.
+const (
+	// ExistingTargetFail fails the epoch.
+	ExistingTargetFail = "fail"
+	// ExistingTargetSkip skips the epoch if its store is complete, and resumes it otherwise.
+	ExistingTargetSkip = "skip"
+	// ExistingTargetOverwrite migrates the epoch again and replaces the existing store.
+	ExistingTargetOverwrite = "overwrite"
+	// ExistingTargetVerifyAndSkip skips the epoch if the record counts and the last processed tick of its store match
+	// the v1 store, and migrates it again otherwise.
+	ExistingTargetVerifyAndSkip = "verify-and-skip"
+)
+
+// Decisions taken for the epochs, listed in the run summary.
+const (
+	EpochDecisionMigrated    = "migrated"
+	EpochDecisionResumed     = "resumed unfinished store"
+	EpochDecisionSkipped     = "skipped, store exists"
+	EpochDecisionVerified    = "skipped, store verified"
+	EpochDecisionOverwritten = "overwritten"
+	EpochDecisionRebuilt     = "rebuilt"
+	// EpochDecisionStepsMigrated is followed by the selected steps that were migrated again.
+	EpochDecisionStepsMigrated = "migrated again"
+	EpochDecisionTargetExists  = "failed, store exists"
+	EpochDecisionFailed        = "failed"
+	EpochDecisionInterrupted   = "interrupted, staged store kept"
+)
+
+// targetDecision is what applying the existing target policy decided for an epoch.
+type targetDecision struct {
+	decision string
+	skip     bool
+	// replace is set when the published store is replaced by the newly migrated one.
+	replace bool
+}
+
+// ErrTargetExists is returned for epochs whose store exists when the existing target policy is ExistingTargetFail.
+var ErrTargetExists = errors.New("epoch store already exists")
+
+// applyExistingTargetPolicy decides what to do with an epoch, depending on whether its store has been published before.
+func (m *Migrator) applyExistingTargetPolicy(ctx context.Context, epoch uint32) (targetDecision, error) {
+	publishedPath := v2.EpochStorePath(m.newStorePath, epoch)
+
+	_, err := os.Stat(publishedPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return targetDecision{decision: EpochDecisionMigrated}, nil
+		}
+		return targetDecision{}, fmt.Errorf("checking published epoch store %s: %w", publishedPath, err)
+	}
+
+	switch m.existingTarget {
+	case ExistingTargetFail:
+		return targetDecision{decision: EpochDecisionTargetExists}, fmt.Errorf("%w: %s", ErrTargetExists, publishedPath)
+
+	case ExistingTargetOverwrite:
+		err = m.removeStagedEpoch(epoch)
+		if err != nil {
+			return targetDecision{}, err
+		}
+		log.Printf("Epoch store %s exists, it is migrated again and replaced.\n", publishedPath)
+		return targetDecision{decision: EpochDecisionOverwritten, replace: true}, nil
+
+	case ExistingTargetVerifyAndSkip:
+		mismatch, err := m.checkPublishedEpoch(ctx, epoch)
+		if err != nil {
+			return targetDecision{}, fmt.Errorf("checking published epoch store %s: %w", publishedPath, err)
+		}
+		if mismatch == "" {
+			log.Printf("Epoch store %s matches the v1 store, skipping.\n", publishedPath)
+			return targetDecision{decision: EpochDecisionVerified, skip: true}, nil
+		}
+
+		err = m.removeStagedEpoch(epoch)
+		if err != nil {
+			return targetDecision{}, err
+		}
+		log.Printf("Epoch store %s does not match the v1 store (%s), it is migrated again and replaced.\n", publishedPath, mismatch)
+		return targetDecision{decision: fmt.Sprintf("%s, %s", EpochDecisionRebuilt, mismatch), replace: true}, nil
+
+	default:
+		published, err := m.isEpochPublished(epoch)
+		if err != nil {
+			return targetDecision{}, err
+		}
+		if published {
+			log.Printf("Epoch %d has already been migrated, skipping.\n", epoch)
+			return targetDecision{decision: EpochDecisionSkipped, skip: true}, nil
+		}
+		return targetDecision{decision: EpochDecisionResumed}, nil
+	}
+}
+
+// removeStagedEpoch removes the staged store of the epoch, so that it is migrated from scratch.
+func (m *Migrator) removeStagedEpoch(epoch uint32) error {
+	stagedPath := v2.EpochStorePath(m.stagingPath(), epoch)
+	err := os.RemoveAll(stagedPath)
+	if err != nil {
+		return fmt.Errorf("removing staged epoch store %s: %w", stagedPath, err)
+	}
+	return nil
+}
+
+// checkPublishedEpoch compares the published store of the epoch with the v1 store. It returns a description of the
+// first difference, or an empty string if the store is complete and its record counts and last processed tick match.
+func (m *Migrator) checkPublishedEpoch(ctx context.Context, epoch uint32) (string, error) {
+	epochMetadata, exists := m.source.Metadata().Epochs[epoch]
+	if !exists {
+		return "", fmt.Errorf("epoch %d metadata not found", epoch)
+	}
+
+	newStore, err := m.openSink(m.newStorePath, epoch)
+	if err != nil {
+		return "", fmt.Errorf("opening published epoch store: %w", err)
+	}
+	defer newStore.Close()
+
+	complete, err := publishedStoreComplete(newStore)
+	if err != nil {
+		return "", err
+	}
+	if !complete {
+		return "store is not complete", nil
+	}
+
+	lastProcessedTick, err := newStore.GetLastProcessedTick(ctx)
+	if err != nil && !errors.Is(err, archiverV2Store.ErrNotFound) {
+		return "", fmt.Errorf("getting last processed tick: %w", err)
+	}
+	if lastProcessedTick.GetTickNumber() != epochMetadata.LastProcessedTick {
+		return fmt.Sprintf("last processed tick %d, expected %d", lastProcessedTick.GetTickNumber(), epochMetadata.LastProcessedTick), nil
+	}
+
+	expected, err := m.countSourceRecords(ctx, epochMetadata)
+	if err != nil {
+		return "", fmt.Errorf("counting v1 records: %w", err)
+	}
+
+	for _, count := range []struct {
+		dataType string
+		prefix   byte
+		expected int
+	}{
+		{DataTypeTickData, archiverV2Store.TickData, expected.tickData},
+		{DataTypeQuorumData, archiverV2Store.QuorumData, expected.quorumData},
+		{DataTypeTransaction, archiverV2Store.Transaction, expected.transactions},
+		{DataTypeTransactionStatus, archiverV2Store.TransactionStatus, expected.transactions},
+	} {
+		found, err := countRecords(newStore, count.prefix)
+		if err != nil {
+			return "", fmt.Errorf("counting %s records: %w", count.dataType, err)
+		}
+		// Without the abort policy, transactions whose v1 status is missing may have been migrated without one.
+		if count.dataType == DataTypeTransactionStatus && (m.missingStatusPolicy == MissingStatusSkip || m.missingStatusPolicy == MissingStatusRebuild) && found <= count.expected {
+			continue
+		}
+		if found != count.expected {
+			return fmt.Sprintf("%d %s records, expected %d", found, count.dataType, count.expected), nil
+		}
+	}
+
+	return "", nil
+}
+
+type sourceRecordCounts struct {
+	tickData     int
+	quorumData   int
+	transactions int
+}
+
+// countSourceRecords counts the v1 records of the epoch's ticks. The transactions are counted through the transaction
+// ids of the tick data, as they are not keyed by tick.
+func (m *Migrator) countSourceRecords(ctx context.Context, epochMetadata v1.EpochMetadata) (sourceRecordCounts, error) {
+	var counts sourceRecordCounts
+
+	for _, tickRange := range epochMetadata.ProcessedTickRanges {
+		err := m.iterateSourceRange(ctx, tickRange, archiverV1Store.TickData, func(value []byte) error {
+			var tickData protoV1.TickData
+			err := proto.Unmarshal(value, &tickData)
+			if err != nil {
+				return fmt.Errorf("unmarshaling tick data: %w", err)
+			}
+			counts.tickData++
+			counts.transactions += len(tickData.TransactionIds)
+			return nil
+		})
+		if err != nil {
+			return sourceRecordCounts{}, fmt.Errorf("counting tick data for tick range %v: %w", tickRange, err)
+		}
+
+		err = m.iterateSourceRange(ctx, tickRange, archiverV1Store.QuorumData, func(_ []byte) error {
+			counts.quorumData++
+			return nil
+		})
+		if err != nil {
+			return sourceRecordCounts{}, fmt.Errorf("counting quorum data for tick range %v: %w", tickRange, err)
+		}
+	}
+	return counts, nil
+}
+
+func (m *Migrator) iterateSourceRange(ctx context.Context, tickRange v1.TickRange, prefix int, handle func(value []byte) error) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	return m.source.IterateTicks(prefix, tickRange, func(_ uint32, value []byte) error {
+		return handle(value)
+	})
+}
@@ -0,0 +1,240 @@
+package migration
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// defaultSortBufferSize is the buffer limit of a sorter created without one.
+const defaultSortBufferSize = 256 << 20
+
+type keyValue struct {
+	key   []byte
+	value []byte
+}
+
+// externalSorter sorts records by key with a bounded amount of memory. Records are buffered until the buffer limit is
+// reached, then sorted and spilled to a temporary file. Merging reads all spilled files back in key order. A limit of
+// zero or less would spill every record on its own, defaultSortBufferSize is used instead.
+type externalSorter struct {
+	dir         string
+	bufferLimit int
+	buffer      []keyValue
+	bufferSize  int
+	spills      []string
+}
+
+func newExternalSorter(tempDir string, bufferLimit int) (*externalSorter, error) {
+	dir, err := os.MkdirTemp(tempDir, ".sort-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating temporary sort directory: %w", err)
+	}
+
+	if bufferLimit <= 0 {
+		bufferLimit = defaultSortBufferSize
+	}
+
+	return &externalSorter{
+		dir:         dir,
+		bufferLimit: bufferLimit,
+	}, nil
+}
+
+func (s *externalSorter) add(key, value []byte) error {
+	s.buffer = append(s.buffer, keyValue{
+		key:   slices.Clone(key),
+		value: slices.Clone(value),
+	})
+	s.bufferSize += len(key) + len(value)
+
+	if s.bufferSize >= s.bufferLimit {
+		return s.spill()
+	}
+	return nil
+}
+
+func (s *externalSorter) sortBuffer() {
+	slices.SortStableFunc(s.buffer, func(a, b keyValue) int {
+		return bytes.Compare(a.key, b.key)
+	})
+}
+
+func (s *externalSorter) spill() error {
+	if len(s.buffer) == 0 {
+		return nil
+	}
+	s.sortBuffer()
+
+	path := filepath.Join(s.dir, fmt.Sprintf("%06d.spill", len(s.spills)))
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating spill file %s: %w", path, err)
+	}
+
+	err = writeSpillFile(file, s.buffer)
+	closeErr := file.Close()
+	if err != nil {
+		return fmt.Errorf("writing spill file %s: %w", path, err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("closing spill file %s: %w", path, closeErr)
+	}
+
+	s.spills = append(s.spills, path)
+	s.buffer = nil
+	s.bufferSize = 0
+	return nil
+}
+
+// merge calls emit for every record in ascending key order. Records with a duplicate key are only emitted once.
+func (s *externalSorter) merge(emit func(key, value []byte) error) error {
+
+	if len(s.spills) == 0 {
+		s.sortBuffer()
+		var previous []byte
+		for _, record := range s.buffer {
+			if previous != nil && bytes.Equal(previous, record.key) {
+				continue
+			}
+			err := emit(record.key, record.value)
+			if err != nil {
+				return err
+			}
+			previous = record.key
+		}
+		return nil
+	}
+
+	err := s.spill()
+	if err != nil {
+		return err
+	}
+
+	var sources spillHeap
+	for _, path := range s.spills {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("opening spill file %s: %w", path, err)
+		}
+		defer file.Close()
+
+		source := &spillSource{reader: bufio.NewReader(file)}
+		ok, err := source.next()
+		if err != nil {
+			return fmt.Errorf("reading spill file %s: %w", path, err)
+		}
+		if ok {
+			sources = append(sources, source)
+		}
+	}
+	heap.Init(&sources)
+
+	var previous []byte
+	for sources.Len() > 0 {
+		source := sources[0]
+
+		if previous == nil || !bytes.Equal(previous, source.current.key) {
+			err = emit(source.current.key, source.current.value)
+			if err != nil {
+				return err
+			}
+			previous = source.current.key
+		}
+
+		ok, err := source.next()
+		if err != nil {
+			return fmt.Errorf("reading spill file: %w", err)
+		}
+		if ok {
+			heap.Fix(&sources, 0)
+		} else {
+			heap.Pop(&sources)
+		}
+	}
+	return nil
+}
+
+func (s *externalSorter) close() error {
+	s.buffer = nil
+	return os.RemoveAll(s.dir)
+}
+
+func writeSpillFile(file *os.File, records []keyValue) error {
+	writer := bufio.NewWriter(file)
+	for _, record := range records {
+		err := writeSpillRecord(writer, record)
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+func writeSpillRecord(writer *bufio.Writer, record keyValue) error {
+	var header []byte
+	header = binary.AppendUvarint(header, uint64(len(record.key)))
+	header = binary.AppendUvarint(header, uint64(len(record.value)))
+
+	for _, part := range [][]byte{header, record.key, record.value} {
+		_, err := writer.Write(part)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type spillSource struct {
+	reader  *bufio.Reader
+	current keyValue
+}
+
+func (s *spillSource) next() (bool, error) {
+	keyLength, err := binary.ReadUvarint(s.reader)
+	if err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+	valueLength, err := binary.ReadUvarint(s.reader)
+	if err != nil {
+		return false, err
+	}
+
+	record := keyValue{
+		key:   make([]byte, keyLength),
+		value: make([]byte, valueLength),
+	}
+	_, err = io.ReadFull(s.reader, record.key)
+	if err != nil {
+		return false, err
+	}
+	_, err = io.ReadFull(s.reader, record.value)
+	if err != nil {
+		return false, err
+	}
+
+	s.current = record
+	return true, nil
+}
+
+type spillHeap []*spillSource
+
+func (h spillHeap) Len() int           { return len(h) }
+func (h spillHeap) Less(i, j int) bool { return bytes.Compare(h[i].current.key, h[j].current.key) < 0 }
+func (h spillHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *spillHeap) Push(x any)        { *h = append(*h, x.(*spillSource)) }
+func (h *spillHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
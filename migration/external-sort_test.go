@@ -0,0 +1,75 @@
+package migration
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestExternalSorterMerge(t *testing.T) {
+	cases := []struct {
+		name        string
+		bufferLimit int
+	}{
+		{name: "in memory", bufferLimit: 1 << 20},
+		{name: "spilled", bufferLimit: 16},
+		{name: "default limit", bufferLimit: 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sorter, err := newExternalSorter(t.TempDir(), c.bufferLimit)
+			if err != nil {
+				t.Fatalf("creating sorter: %v", err)
+			}
+			defer sorter.close()
+
+			keys := []string{"k05", "k01", "k09", "k03", "k01", "k07", "k05", "k02", "k09", "k00"}
+			for _, key := range keys {
+				err = sorter.add([]byte(key), []byte("v"+key))
+				if err != nil {
+					t.Fatalf("adding %s: %v", key, err)
+				}
+			}
+
+			if c.bufferLimit == 16 && len(sorter.spills) == 0 {
+				t.Fatalf("expected the sorter to spill with a buffer limit of %d", c.bufferLimit)
+			}
+			if c.bufferLimit == 0 && len(sorter.spills) != 0 {
+				t.Fatalf("expected no spill with the default buffer limit, got %d", len(sorter.spills))
+			}
+
+			var merged []string
+			err = sorter.merge(func(key, value []byte) error {
+				if string(value) != "v"+string(key) {
+					return fmt.Errorf("value %s for key %s", value, key)
+				}
+				merged = append(merged, string(key))
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("merging: %v", err)
+			}
+
+			expected := []string{"k00", "k01", "k02", "k03", "k05", "k07", "k09"}
+			if !slices.Equal(merged, expected) {
+				t.Fatalf("merged %v, expected %v", merged, expected)
+			}
+		})
+	}
+}
+
+func TestExternalSorterMergeEmpty(t *testing.T) {
+	sorter, err := newExternalSorter(t.TempDir(), 16)
+	if err != nil {
+		t.Fatalf("creating sorter: %v", err)
+	}
+	defer sorter.close()
+
+	err = sorter.merge(func(key, value []byte) error {
+		return fmt.Errorf("unexpected record %s", key)
+	})
+	if err != nil {
+		t.Fatalf("merging: %v", err)
+	}
+}
@@ -0,0 +1,216 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	archiverV2Store "github.com/qubic/go-archiver-v2/db"
+)
+
+// OpenSource opens the source for one follow cycle. The returned function closes it.
+type OpenSource func(ctx context.Context) (Source, func() error, error)
+
+// followSteps are the steps that run for the live epoch in every follow cycle. The identity transfers are written in
+// identity order and can only be migrated for the whole epoch at once, so they are left for the rollover or the
+// cutover, when the epoch store is finished.
+var followSteps = []string{StepMetadata, StepTickData, StepQuorumData, StepTransactions, StepStatuses, StepDigests}
+
+// Follow keeps the epoch stores in sync with a v1 store that is still being archived, until ctx is cancelled. Every
+// interval the source is opened again. The epochs before the live epoch, the most recent one of the source, are
+// migrated and published once, which also finishes the store of the previous live epoch when the source moves to a new
+// epoch. The ticks of the live epoch are migrated into its store in the staging directory, continuing from the
+// checkpoints of the previous cycle, and its metadata is updated. The store of the live epoch is not published, the
+// cutover migrates it once the archiver is stopped, which only has to catch up the ticks of the last cycle.
+//
+// A failed cycle is logged and retried with the next one, which includes a cycle that cannot open the source while the
+// archiver holds its lock. Follow returns nil when it is cancelled between two cycles, and the error of the cycle when
+// it is cancelled during one.
+func (m *Migrator) Follow(ctx context.Context, openSource OpenSource, interval time.Duration) error {
+	published := make(publishedEpochs)
+	for {
+		err := m.followCycle(ctx, openSource, published)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			log.Printf("Follow cycle failed, retrying in %s: %v\n", interval, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// publishedEpochs caches whether the epochs have a published store for the lifetime of Follow. Checking an epoch opens
+// its published store, so it is only done the first time the epoch shows up, later cycles only check new epochs.
+type publishedEpochs map[uint32]bool
+
+func (p publishedEpochs) check(m *Migrator, epoch uint32) (bool, error) {
+	published, known := p[epoch]
+	if known {
+		return published, nil
+	}
+	published, err := m.isEpochPublished(epoch)
+	if err != nil {
+		return false, fmt.Errorf("checking whether epoch %d is published: %w", epoch, err)
+	}
+	p[epoch] = published
+	return published, nil
+}
+
+func (m *Migrator) followCycle(ctx context.Context, openSource OpenSource, published publishedEpochs) error {
+	source, closeSource, err := openSource(ctx)
+	if err != nil {
+		return fmt.Errorf("opening source: %w", err)
+	}
+	defer func() {
+		closeErr := closeSource()
+		if closeErr != nil {
+			log.Printf("Failed to close source: %v\n", closeErr)
+		}
+	}()
+
+	cycle := *m
+	cycle.source = source
+
+	epochs := slices.Sorted(maps.Keys(source.Metadata().Epochs))
+	if len(epochs) == 0 {
+		log.Println("The source has no epochs yet.")
+		return nil
+	}
+	liveEpoch := epochs[len(epochs)-1]
+
+	var finished []uint32
+	for _, epoch := range epochs[:len(epochs)-1] {
+		isPublished, err := published.check(&cycle, epoch)
+		if err != nil {
+			return err
+		}
+		if !isPublished {
+			finished = append(finished, epoch)
+		}
+	}
+	if len(finished) > 0 {
+		err = cycle.migrateEpochs(ctx, finished)
+		for _, epoch := range finished {
+			if err != nil {
+				// Some of the epochs may have been published, they are checked again in the next cycle.
+				delete(published, epoch)
+			} else {
+				published[epoch] = true
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return cycle.followLiveEpoch(ctx, liveEpoch, published)
+}
+
+// followLiveEpoch migrates the ticks of the live epoch that are not in its staged store yet. The metadata is migrated
+// after the ticks, so the last processed tick of the store only covers ticks that have been migrated.
+func (m *Migrator) followLiveEpoch(ctx context.Context, epoch uint32, published publishedEpochs) error {
+	isPublished, err := published.check(m, epoch)
+	if err != nil {
+		return err
+	}
+	if isPublished {
+		err = m.unpublishEpoch(epoch)
+		if err != nil {
+			return fmt.Errorf("moving published store of live epoch %d to staging: %w", epoch, err)
+		}
+		published[epoch] = false
+	}
+
+	newStore, err := m.openSink(m.stagingPath(), epoch)
+	if err != nil {
+		return fmt.Errorf("opening staged epoch store for epoch %d: %w", epoch, err)
+	}
+
+	m.metrics.storeOpened(epoch, newStore)
+	err = m.syncLiveEpoch(ctx, epoch, newStore)
+	m.metrics.storeClosing(epoch)
+
+	closeErr := newStore.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return fmt.Errorf("closing staged epoch store for epoch %d: %w", epoch, closeErr)
+	}
+	return nil
+}
+
+func (m *Migrator) syncLiveEpoch(ctx context.Context, epoch uint32, newStore EpochSink) error {
+	sourceLastTick := m.source.Metadata().Epochs[epoch].LastProcessedTick
+
+	inSync, err := m.liveEpochInSync(ctx, epoch, newStore)
+	if err != nil {
+		return err
+	}
+	if inSync {
+		log.Printf("Epoch %d is in sync at tick %d.\n", epoch, sourceLastTick)
+		return nil
+	}
+
+	live := *m
+	live.steps = followSteps
+
+	err = live.MigrateEpochTicks(ctx, epoch, newStore)
+	if err != nil {
+		return fmt.Errorf("migrating epoch ticks for epoch %d: %w", epoch, err)
+	}
+
+	err = ctx.Err()
+	if err != nil {
+		return fmt.Errorf("stopped before migrating epoch metadata for epoch %d: %w", epoch, err)
+	}
+
+	err = live.MigrateEpochMetadata(ctx, epoch, newStore)
+	if err != nil {
+		return fmt.Errorf("migrating epoch metadata for epoch %d: %w", epoch, err)
+	}
+
+	log.Printf("Epoch %d synced up to tick %d.\n", epoch, sourceLastTick)
+	return nil
+}
+
+// liveEpochInSync reports whether the staged store of the live epoch has the last processed tick and the processed tick
+// intervals of the source. A new interval can start below the last processed tick of the previous one, so the last
+// processed tick alone does not show that the store is in sync.
+func (m *Migrator) liveEpochInSync(ctx context.Context, epoch uint32, newStore EpochSink) (bool, error) {
+	lastProcessedTick, err := newStore.GetLastProcessedTick(ctx)
+	if err != nil && !errors.Is(err, archiverV2Store.ErrNotFound) {
+		return false, fmt.Errorf("getting last processed tick for epoch %d: %w", epoch, err)
+	}
+	if lastProcessedTick.GetTickNumber() != m.source.Metadata().Epochs[epoch].LastProcessedTick {
+		return false, nil
+	}
+
+	sourceIntervals, err := m.source.GetProcessedTickIntervals(ctx)
+	if err != nil {
+		return false, fmt.Errorf("getting processed tick intervals: %w", err)
+	}
+	storeIntervals, err := newStore.GetProcessedTickIntervals(ctx)
+	if err != nil && !errors.Is(err, archiverV2Store.ErrNotFound) {
+		return false, fmt.Errorf("getting processed tick intervals for epoch %d: %w", epoch, err)
+	}
+
+	expected := processedTickIntervalsV1ToV2(epoch, sourceIntervals)
+	for _, intervals := range storeIntervals {
+		if intervals.Epoch == epoch {
+			return proto.Equal(intervals, expected), nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,100 @@
+package migration
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/qubic/archiver-db-migrator/generator"
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+	v2 "github.com/qubic/archiver-db-migrator/store/v2"
+)
+
+func TestFollowCycle(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	generatedPath := filepath.Join(dir, "generated")
+	newStorePath := filepath.Join(dir, "new")
+
+	_, err := generator.Generate(ctx, generatedPath, generator.Options{
+		Seed:                3,
+		FirstEpoch:          158,
+		Epochs:              2,
+		FirstTick:           13752100,
+		IntervalsPerEpoch:   2,
+		TicksPerInterval:    20,
+		IntervalGap:         10,
+		EmptyTickRatio:      0.1,
+		TransactionsPerTick: 2,
+		TransferRatio:       0.5,
+		MoneyFlewRatio:      0.5,
+		Identities:          10,
+		Computors:           10,
+		QuorumVotesPerTick:  3,
+	})
+	if err != nil {
+		t.Fatalf("generating archive: %v", err)
+	}
+
+	generatedStore, err := v1.NewArchiverStoreV1(ctx, generatedPath)
+	if err != nil {
+		t.Fatalf("opening generated archive: %v", err)
+	}
+	defer generatedStore.Close()
+	source := NewV1Source(generatedStore)
+	openSource := func(ctx context.Context) (Source, func() error, error) {
+		return source, func() error { return nil }, nil
+	}
+
+	m := NewMigrator(nil, newStorePath, Options{
+		BatchSize:        100,
+		WriteMode:        WriteModeBatch,
+		StagingLeftovers: StagingLeftoversResume,
+		ExistingTarget:   ExistingTargetFail,
+		MissingStatus:    MissingStatusAbort,
+		StatusStrategy:   StatusStrategyAuto,
+	})
+	published := make(publishedEpochs)
+
+	err = m.followCycle(ctx, openSource, published)
+	if err != nil {
+		t.Fatalf("first follow cycle: %v", err)
+	}
+	if !published[158] || published[159] {
+		t.Fatalf("got published epochs %v, expected 158 published and the live epoch 159 staged", published)
+	}
+
+	liveStore, err := m.openSink(m.stagingPath(), 159)
+	if err != nil {
+		t.Fatalf("opening staged live epoch store: %v", err)
+	}
+	m.source = source
+	inSync, err := m.liveEpochInSync(ctx, 159, liveStore)
+	closeErr := liveStore.Close()
+	if err != nil {
+		t.Fatalf("checking whether the live epoch is in sync: %v", err)
+	}
+	if closeErr != nil {
+		t.Fatalf("closing staged live epoch store: %v", closeErr)
+	}
+	if !inSync {
+		t.Fatal("live epoch is not in sync after a follow cycle")
+	}
+
+	// Epochs are only checked the first time they show up: a published store that disappears afterwards is not
+	// migrated again by a later cycle.
+	publishedPath := v2.EpochStorePath(newStorePath, 158)
+	err = os.RemoveAll(publishedPath)
+	if err != nil {
+		t.Fatalf("removing published store: %v", err)
+	}
+	err = m.followCycle(ctx, openSource, published)
+	if err != nil {
+		t.Fatalf("second follow cycle: %v", err)
+	}
+	_, err = os.Stat(publishedPath)
+	if !os.IsNotExist(err) {
+		t.Fatalf("epoch 158 was checked and migrated again: %v", err)
+	}
+}
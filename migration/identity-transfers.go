@@ -0,0 +1,114 @@
+package migration
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+	v2 "github.com/qubic/archiver-db-migrator/store/v2"
+	"github.com/schollz/progressbar/v3"
+)
+
+// MigrateIdentityTransfers copies the v1 identity transfer index entries of the epoch's ticks into the epoch store.
+// The values are copied as they are, the v1 TransferTransactionsPerTick encoding is wire compatible with the v2
+// transaction messages.
+//
+// The v1 index is keyed by identity first, so the epoch's entries are spread over the whole prefix. The source skips
+// the ticks of other epochs.
+func (m *Migrator) MigrateIdentityTransfers(ctx context.Context, epochMetadata v1.EpochMetadata, newStore EpochSink) error {
+
+	tickRanges := slices.SortedFunc(slices.Values(epochMetadata.ProcessedTickRanges), func(a, b v1.TickRange) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+	if len(tickRanges) == 0 {
+		return nil
+	}
+	lastTick := tickRanges[len(tickRanges)-1].End
+
+	// Entries are written in identity order, so the checkpoint only records whether the whole epoch is done.
+	checkpoint, exists, err := newStore.GetCheckpoint(v2.CheckpointIdentityTransfers, 0)
+	if err != nil {
+		return fmt.Errorf("getting identity transfers checkpoint: %w", err)
+	}
+	if exists && checkpoint == lastTick {
+		log.Printf("Identity transfers for epoch %d have already been migrated, skipping.\n", epochMetadata.Epoch)
+		return nil
+	}
+
+	bar := progressbar.Default(-1, "Migrating identity transfers")
+
+	epochRange := v1.TickRange{Start: tickRanges[0].Start, End: lastTick}
+	m.rangeStarted(newStore, v2.CheckpointIdentityTransfers, epochRange, epochRange.Start)
+
+	writer, err := m.newRangeWriter(newStore, true)
+	if err != nil {
+		return fmt.Errorf("creating writer for identity transfers: %w", err)
+	}
+	defer writer.close()
+
+	counter := 0
+	sourceCount := 0
+
+	err = m.source.IterateIdentityTransfers(tickRanges, func(identity []byte, tickNumber uint64, value []byte) error {
+		err := writer.Set(v2.IdentityTransferTransactionsKey(identity, tickNumber), value)
+		if err != nil {
+			return fmt.Errorf("setting identity transfers for identity %s and tick %d: %w", identity, tickNumber, err)
+		}
+		counter++
+		sourceCount++
+		m.recordsRead(newStore, v2.CheckpointIdentityTransfers, 1, len(value))
+		_ = bar.Add(1)
+
+		if counter >= m.batchSize {
+			err = writer.commit(ctx, v2.CheckpointIdentityTransfers, 0, 0, false)
+			if err != nil {
+				return fmt.Errorf("committing identity transfers: %w", err)
+			}
+			counter = 0
+
+			// Stop at the batch boundary once cancelled. The checkpoint is only set for the whole epoch, so the next run
+			// migrates the identity transfers again.
+			err = ctx.Err()
+			if err != nil {
+				return fmt.Errorf("stopped after %d identity transfer entries: %w", sourceCount, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	_ = bar.Finish()
+
+	err = writer.commit(ctx, v2.CheckpointIdentityTransfers, 0, lastTick, true)
+	if err != nil {
+		return fmt.Errorf("committing final identity transfers: %w", err)
+	}
+
+	migratedCount, err := countRecords(newStore, v2.IdentityTransferTransactions)
+	if err != nil {
+		return fmt.Errorf("counting migrated identity transfers: %w", err)
+	}
+	if migratedCount != sourceCount {
+		return fmt.Errorf("identity transfers count mismatch for epoch %d: %d in source, %d migrated", epochMetadata.Epoch, sourceCount, migratedCount)
+	}
+
+	log.Printf("Migrated %d identity transfer entries for epoch %d\n", migratedCount, epochMetadata.Epoch)
+	return nil
+}
+
+// countRecords counts the records under the key prefix of the epoch store.
+func countRecords(newStore EpochSink, prefix byte) (int, error) {
+	count := 0
+	err := newStore.IterateRecords(prefix, func(_, _ []byte) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,263 @@
+package migration
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	pebbleV1 "github.com/cockroachdb/pebble"
+	pebbleV2 "github.com/cockroachdb/pebble/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "archiver_migrator"
+
+// Metrics exposes the progress of the migration and the state of the pebble stores to Prometheus. The methods of a nil
+// Metrics do nothing, like the ones of the Reporter.
+type Metrics struct {
+	recordsRead    *prometheus.CounterVec
+	bytesRead      *prometheus.CounterVec
+	recordsWritten *prometheus.CounterVec
+	bytesWritten   *prometheus.CounterVec
+	commitLatency  *prometheus.HistogramVec
+	currentTick    *prometheus.GaugeVec
+	epochs         *prometheus.CounterVec
+
+	pebble *pebbleCollector
+}
+
+// NewMetrics creates the migration metrics and registers them, together with the pebble metrics of the v1 store, with
+// the registerer.
+func NewMetrics(registerer prometheus.Registerer, oldDB *pebbleV1.DB) (*Metrics, error) {
+	m := Metrics{
+		recordsRead: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "v1_records_read_total",
+			Help:      "Number of records read from the v1 store.",
+		}, []string{"epoch", "data_type"}),
+		bytesRead: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "v1_bytes_read_total",
+			Help:      "Number of value bytes read from the v1 store.",
+		}, []string{"epoch", "data_type"}),
+		recordsWritten: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "records_migrated_total",
+			Help:      "Number of records committed to the v2 epoch stores.",
+		}, []string{"epoch", "data_type"}),
+		bytesWritten: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "bytes_migrated_total",
+			Help:      "Number of key and value bytes committed to the v2 epoch stores.",
+		}, []string{"epoch", "data_type"}),
+		commitLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "batch_commit_duration_seconds",
+			Help:      "Duration of the batch commits and sstable ingestions into the v2 epoch stores.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16),
+		}, []string{"data_type"}),
+		currentTick: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "current_tick",
+			Help:      "Last tick committed, or the first tick of the range being migrated, per epoch and data type.",
+		}, []string{"epoch", "data_type"}),
+		epochs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "epochs_finished_total",
+			Help:      "Number of epochs finished, by status.",
+		}, []string{"status"}),
+		pebble: newPebbleCollector(oldDB),
+	}
+
+	for _, collector := range []prometheus.Collector{m.recordsRead, m.bytesRead, m.recordsWritten, m.bytesWritten, m.commitLatency, m.currentTick, m.epochs, m.pebble} {
+		err := registerer.Register(collector)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &m, nil
+}
+
+func epochLabel(epoch uint32) string {
+	return strconv.FormatUint(uint64(epoch), 10)
+}
+
+// storeOpened collects the pebble metrics of the epoch store, if it is a pebble store.
+func (m *Metrics) storeOpened(epoch uint32, newStore EpochSink) {
+	if m == nil {
+		return
+	}
+	store, ok := newStore.(interface{ pebbleDB() *pebbleV2.DB })
+	if !ok {
+		return
+	}
+	m.pebble.addStore(epoch, store.pebbleDB())
+}
+
+// storeClosing must be called before the epoch store is closed, so that its metrics are no longer collected.
+func (m *Metrics) storeClosing(epoch uint32) {
+	if m == nil {
+		return
+	}
+	m.pebble.removeStore(epoch)
+}
+
+func (m *Metrics) epochFinished(status string) {
+	if m == nil {
+		return
+	}
+	m.epochs.WithLabelValues(status).Inc()
+}
+
+func (m *Metrics) rangeStarted(epoch uint32, checkpointType byte, startTick uint32) {
+	if m == nil {
+		return
+	}
+	m.currentTick.WithLabelValues(epochLabel(epoch), checkpointDataType(checkpointType)).Set(float64(startTick))
+}
+
+func (m *Metrics) recordsReadFromSource(epoch uint32, checkpointType byte, records, bytes int) {
+	if m == nil {
+		return
+	}
+	labels := []string{epochLabel(epoch), checkpointDataType(checkpointType)}
+	m.recordsRead.WithLabelValues(labels...).Add(float64(records))
+	m.bytesRead.WithLabelValues(labels...).Add(float64(bytes))
+}
+
+func (m *Metrics) batchCommitted(epoch uint32, checkpointType byte, lastTick uint32, records, bytes uint64, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+	dataType := checkpointDataType(checkpointType)
+	labels := []string{epochLabel(epoch), dataType}
+	m.recordsWritten.WithLabelValues(labels...).Add(float64(records))
+	m.bytesWritten.WithLabelValues(labels...).Add(float64(bytes))
+	m.commitLatency.WithLabelValues(dataType).Observe(elapsed.Seconds())
+	if lastTick > 0 {
+		m.currentTick.WithLabelValues(labels...).Set(float64(lastTick))
+	}
+}
+
+var (
+	pebbleLabels = []string{"store", "epoch"}
+
+	pebbleCompactionsDesc = prometheus.NewDesc(metricsNamespace+"_pebble_compactions_total",
+		"Number of compactions.", pebbleLabels, nil)
+	pebbleCompactionsInProgressDesc = prometheus.NewDesc(metricsNamespace+"_pebble_compactions_in_progress",
+		"Number of compactions in progress.", pebbleLabels, nil)
+	pebbleCompactionDebtDesc = prometheus.NewDesc(metricsNamespace+"_pebble_compaction_debt_bytes",
+		"Estimated number of bytes that need to be compacted to reach a stable state.", pebbleLabels, nil)
+	pebbleFlushesDesc = prometheus.NewDesc(metricsNamespace+"_pebble_flushes_total",
+		"Number of memtable flushes.", pebbleLabels, nil)
+	pebbleL0FilesDesc = prometheus.NewDesc(metricsNamespace+"_pebble_l0_files",
+		"Number of sstables in L0.", pebbleLabels, nil)
+	pebbleL0SublevelsDesc = prometheus.NewDesc(metricsNamespace+"_pebble_l0_sublevels",
+		"Number of L0 sublevels. Pebble stalls writes when there are too many.", pebbleLabels, nil)
+	pebbleReadAmpDesc = prometheus.NewDesc(metricsNamespace+"_pebble_read_amplification",
+		"Number of sublevels and levels a read has to look at.", pebbleLabels, nil)
+	pebbleMemTableSizeDesc = prometheus.NewDesc(metricsNamespace+"_pebble_memtable_size_bytes",
+		"Bytes allocated by the memtables.", pebbleLabels, nil)
+	pebbleDiskUsageDesc = prometheus.NewDesc(metricsNamespace+"_pebble_disk_usage_bytes",
+		"Bytes used by the store on disk.", pebbleLabels, nil)
+)
+
+// pebbleCollector collects the pebble Metrics of the v1 store and of the open v2 epoch stores on every scrape.
+type pebbleCollector struct {
+	oldDB *pebbleV1.DB
+
+	mutex     sync.Mutex
+	newStores map[uint32]*pebbleV2.DB
+}
+
+func newPebbleCollector(oldDB *pebbleV1.DB) *pebbleCollector {
+	return &pebbleCollector{
+		oldDB:     oldDB,
+		newStores: make(map[uint32]*pebbleV2.DB),
+	}
+}
+
+func (c *pebbleCollector) addStore(epoch uint32, db *pebbleV2.DB) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.newStores[epoch] = db
+}
+
+// removeStore waits for a running collection to finish, so the store can be closed safely afterward.
+func (c *pebbleCollector) removeStore(epoch uint32) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.newStores, epoch)
+}
+
+func (c *pebbleCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- pebbleCompactionsDesc
+	descs <- pebbleCompactionsInProgressDesc
+	descs <- pebbleCompactionDebtDesc
+	descs <- pebbleFlushesDesc
+	descs <- pebbleL0FilesDesc
+	descs <- pebbleL0SublevelsDesc
+	descs <- pebbleReadAmpDesc
+	descs <- pebbleMemTableSizeDesc
+	descs <- pebbleDiskUsageDesc
+}
+
+func (c *pebbleCollector) Collect(metrics chan<- prometheus.Metric) {
+	if c.oldDB != nil {
+		m := c.oldDB.Metrics()
+		collectPebbleMetrics(metrics, []string{"v1", ""}, pebbleMetrics{
+			compactions:           m.Compact.Count,
+			compactionsInProgress: m.Compact.NumInProgress,
+			compactionDebt:        m.Compact.EstimatedDebt,
+			flushes:               m.Flush.Count,
+			l0Files:               m.Levels[0].NumFiles,
+			l0Sublevels:           m.Levels[0].Sublevels,
+			readAmp:               m.ReadAmp(),
+			memTableSize:          m.MemTable.Size,
+			diskUsage:             m.DiskSpaceUsage(),
+		})
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for epoch, db := range c.newStores {
+		m := db.Metrics()
+		collectPebbleMetrics(metrics, []string{"v2", epochLabel(epoch)}, pebbleMetrics{
+			compactions:           m.Compact.Count,
+			compactionsInProgress: m.Compact.NumInProgress,
+			compactionDebt:        m.Compact.EstimatedDebt,
+			flushes:               m.Flush.Count,
+			l0Files:               m.Levels[0].TablesCount,
+			l0Sublevels:           m.Levels[0].Sublevels,
+			readAmp:               m.ReadAmp(),
+			memTableSize:          m.MemTable.Size,
+			diskUsage:             m.DiskSpaceUsage(),
+		})
+	}
+}
+
+// pebbleMetrics holds the values exported from the pebble v1 and v2 Metrics, which are different types.
+type pebbleMetrics struct {
+	compactions           int64
+	compactionsInProgress int64
+	compactionDebt        uint64
+	flushes               int64
+	l0Files               int64
+	l0Sublevels           int32
+	readAmp               int
+	memTableSize          uint64
+	diskUsage             uint64
+}
+
+func collectPebbleMetrics(metrics chan<- prometheus.Metric, labels []string, m pebbleMetrics) {
+	metrics <- prometheus.MustNewConstMetric(pebbleCompactionsDesc, prometheus.CounterValue, float64(m.compactions), labels...)
+	metrics <- prometheus.MustNewConstMetric(pebbleCompactionsInProgressDesc, prometheus.GaugeValue, float64(m.compactionsInProgress), labels...)
+	metrics <- prometheus.MustNewConstMetric(pebbleCompactionDebtDesc, prometheus.GaugeValue, float64(m.compactionDebt), labels...)
+	metrics <- prometheus.MustNewConstMetric(pebbleFlushesDesc, prometheus.CounterValue, float64(m.flushes), labels...)
+	metrics <- prometheus.MustNewConstMetric(pebbleL0FilesDesc, prometheus.GaugeValue, float64(m.l0Files), labels...)
+	metrics <- prometheus.MustNewConstMetric(pebbleL0SublevelsDesc, prometheus.GaugeValue, float64(m.l0Sublevels), labels...)
+	metrics <- prometheus.MustNewConstMetric(pebbleReadAmpDesc, prometheus.GaugeValue, float64(m.readAmp), labels...)
+	metrics <- prometheus.MustNewConstMetric(pebbleMemTableSizeDesc, prometheus.GaugeValue, float64(m.memTableSize), labels...)
+	metrics <- prometheus.MustNewConstMetric(pebbleDiskUsageDesc, prometheus.GaugeValue, float64(m.diskUsage), labels...)
+}
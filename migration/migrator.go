@@ -1,44 +1,248 @@
 package migration
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
+	"runtime"
+	"slices"
+	"sync"
 
 	v1 "github.com/qubic/archiver-db-migrator/store/v1"
-	v2 "github.com/qubic/archiver-db-migrator/store/v2"
 )
 
 type Migrator struct {
-	oldStore            *v1.ArchiverStoreV1
+	source              Source
+	openSink            OpenEpochSink
 	newStorePath        string
 	batchSize           int
 	compactAfterMigrate bool
+	epochConcurrency    int
+	pipelineWorkers     int
+	writeMode           string
+	sortBufferSize      int
+	txIdBufferSize      int
+	reporter            *Reporter
+	metrics             *Metrics
+	stagingLeftovers    string
+	existingTarget      string
+	missingStatusPolicy string
+	statusStrategy      string
+	statusScanMin       int
+	steps               []string
 }
 
-func NewMigrator(oldStore *v1.ArchiverStoreV1, newStorePath string, batchSize int, compactAfterMigrate bool) *Migrator {
+// defaultBatchSize is the batch size used when Options.BatchSize is not positive, the default of the command line.
+const defaultBatchSize = 10000
+
+type Options struct {
+	// BatchSize is the number of records, or of ticks for the tick keyed data types, committed in one batch. Zero or
+	// less uses a default of 10000.
+	BatchSize           int
+	CompactAfterMigrate bool
+	// EpochConcurrency is the number of epochs migrated at once.
+	EpochConcurrency int
+	// PipelineWorkers is the number of readers and converters per tick range pipeline. Zero uses one per CPU core.
+	PipelineWorkers int
+	// WriteMode is either WriteModeBatch or WriteModeIngest.
+	WriteMode string
+	// SortBufferSize is the number of bytes the ingest mode sorts in memory before spilling to disk. Zero or less uses
+	// a default of 256 MB.
+	SortBufferSize int
+	// TxIdBufferSize is the number of bytes of transaction ids of a tick range held in memory while migrating the
+	// transactions and their statuses, the rest is spilled to disk. Zero keeps all of them in memory.
+	TxIdBufferSize int
+	// Reporter collects the statistics of the run and writes the progress events. It may be nil.
+	Reporter *Reporter
+	// Metrics exposes the progress of the run to Prometheus. It may be nil.
+	Metrics *Metrics
+	// StagingLeftovers is either StagingLeftoversResume or StagingLeftoversClean.
+	StagingLeftovers string
+	// ExistingTarget is the policy for epochs whose store already exists, one of the ExistingTarget constants.
+	ExistingTarget string
+	// MissingStatus is the policy for transactions without a v1 status, one of the MissingStatus policy constants.
+	MissingStatus string
+	// StatusStrategy is the strategy for reading the v1 transaction statuses, one of the StatusStrategy constants. Empty
+	// picks one automatically.
+	StatusStrategy string
+	// StatusScanMinTransactions is the number of transactions of an epoch from which the auto status strategy scans.
+	// Zero uses a default of 10000.
+	StatusScanMinTransactions int
+	// Steps are the steps of the epoch migration that run, see ParseSteps. Nil runs all of them. With only some steps
+	// selected, a copy of the published store of the epoch is migrated again for those steps and replaces it.
+	Steps []string
+	// OpenSink opens the epoch stores that are written. Nil opens v2 epoch stores.
+	OpenSink OpenEpochSink
+}
+
+func NewMigrator(source Source, newStorePath string, options Options) *Migrator {
+	batchSize := options.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	pipelineWorkers := options.PipelineWorkers
+	if pipelineWorkers <= 0 {
+		pipelineWorkers = runtime.NumCPU()
+	}
+
+	statusScanMin := options.StatusScanMinTransactions
+	if statusScanMin <= 0 {
+		statusScanMin = statusScanMinTransactions
+	}
+
+	openSink := options.OpenSink
+	if openSink == nil {
+		openSink = OpenV2EpochSink
+	}
+
 	return &Migrator{
-		oldStore:            oldStore,
+		source:              source,
+		openSink:            openSink,
 		newStorePath:        newStorePath,
 		batchSize:           batchSize,
-		compactAfterMigrate: compactAfterMigrate,
+		compactAfterMigrate: options.CompactAfterMigrate,
+		epochConcurrency:    max(options.EpochConcurrency, 1),
+		pipelineWorkers:     pipelineWorkers,
+		writeMode:           options.WriteMode,
+		sortBufferSize:      options.SortBufferSize,
+		txIdBufferSize:      options.TxIdBufferSize,
+		reporter:            options.Reporter,
+		metrics:             options.Metrics,
+		stagingLeftovers:    options.StagingLeftovers,
+		existingTarget:      options.ExistingTarget,
+		missingStatusPolicy: options.MissingStatus,
+		statusStrategy:      options.StatusStrategy,
+		statusScanMin:       statusScanMin,
+		steps:               options.Steps,
 	}
 }
 
-func (m *Migrator) MigrateEpoch(epoch uint32) error {
+// MigrateEpoch migrates the epoch, applying the existing target policy if its store has been published before.
+func (m *Migrator) MigrateEpoch(ctx context.Context, epoch uint32) error {
+	_, _, err := m.migrateEpochWithDecision(ctx, epoch)
+	return err
+}
+
+// migrateEpochWithDecision migrates the epoch and returns its status and the decision taken for it.
+func (m *Migrator) migrateEpochWithDecision(ctx context.Context, epoch uint32) (string, string, error) {
+	m.reporter.epochStarted(epoch)
+
+	target, err := m.migrateEpoch(ctx, epoch)
+
+	status := EpochStatusMigrated
+	if errors.Is(err, context.Canceled) {
+		status = EpochStatusInterrupted
+		target.decision = EpochDecisionInterrupted
+	} else if err != nil {
+		status = EpochStatusFailed
+		if target.decision != EpochDecisionTargetExists {
+			target.decision = EpochDecisionFailed
+		}
+	} else if target.skip {
+		status = EpochStatusSkipped
+	}
+	m.reporter.epochFinished(epoch, status, target.decision, err)
+	m.metrics.epochFinished(status)
 
-	newStore, err := v2.NewArchiverEpochStoreV2(m.newStorePath, epoch)
+	log.Printf("Epoch %d: %s\n", epoch, target.decision)
+	return status, target.decision, err
+}
+
+// migrateEpoch migrates the epoch and returns the decision taken for an existing store. The store is built in the
+// staging directory and only published once every step has succeeded. An interrupted store is closed and left in the
+// staging directory, to be resumed by the next run.
+func (m *Migrator) migrateEpoch(ctx context.Context, epoch uint32) (targetDecision, error) {
+
+	if m.steps != nil {
+		target, err := m.prepareStepsRerun(epoch)
+		if err != nil {
+			return target, fmt.Errorf("preparing to migrate steps again for epoch %d: %w", epoch, err)
+		}
+		return target, m.migrateStagedEpoch(ctx, epoch, target)
+	}
+
+	target, err := m.applyExistingTargetPolicy(ctx, epoch)
+	if err != nil {
+		return target, fmt.Errorf("applying existing target policy for epoch %d: %w", epoch, err)
+	}
+	if target.skip {
+		return target, nil
+	}
+	return target, m.migrateStagedEpoch(ctx, epoch, target)
+}
+
+// migrateStagedEpoch migrates the epoch into its store in the staging directory and publishes it.
+func (m *Migrator) migrateStagedEpoch(ctx context.Context, epoch uint32, target targetDecision) error {
+
+	newStore, err := m.openSink(m.stagingPath(), epoch)
 	if err != nil {
 		return fmt.Errorf("creating new epoch store v2 for epoch %d: %w", epoch, err)
 	}
-	defer newStore.Close()
 
-	err = m.MigrateEpochMetadata(epoch, newStore)
+	m.metrics.storeOpened(epoch, newStore)
+	err = m.migrateEpochStore(ctx, epoch, newStore)
+	if err == nil {
+		// The published store holds no keys of the migrator. A store whose publishing fails after this is migrated again
+		// from the start.
+		err = newStore.RemoveMigrationKeys(ctx)
+		if err != nil {
+			err = fmt.Errorf("removing migration keys for epoch %d: %w", epoch, err)
+		}
+	}
+	m.metrics.storeClosing(epoch)
+
+	closeErr := newStore.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return fmt.Errorf("closing new epoch store v2 for epoch %d: %w", epoch, closeErr)
+	}
+
+	err = m.publishEpoch(epoch, target.replace)
+	if err != nil {
+		return fmt.Errorf("publishing epoch store for epoch %d: %w", epoch, err)
+	}
+	return nil
+}
+
+func (m *Migrator) migrateEpochStore(ctx context.Context, epoch uint32, newStore EpochSink) error {
+
+	if m.steps != nil {
+		// The staged store is a copy of the published one, the selected steps start over in it.
+		err := m.clearSteps(newStore)
+		if err != nil {
+			return fmt.Errorf("clearing steps for epoch %d: %w", epoch, err)
+		}
+	} else {
+		// A staged store that is complete was finished by a run that failed to publish it.
+		complete, err := newStore.IsEpochComplete()
+		if err != nil {
+			return fmt.Errorf("checking if epoch %d is already migrated: %w", epoch, err)
+		}
+		if complete {
+			log.Printf("Epoch %d has already been migrated to the staging directory, publishing it.\n", epoch)
+			return nil
+		}
+	}
+
+	if m.runsStep(StepMetadata) {
+		err := m.MigrateEpochMetadata(ctx, epoch, newStore)
+		if err != nil {
+			return fmt.Errorf("migrating epoch metadata for epoch %d: %w", epoch, err)
+		}
+	}
+
+	err := ctx.Err()
 	if err != nil {
-		return fmt.Errorf("migrating epoch metadata for epoch %d: %w", epoch, err)
+		return fmt.Errorf("stopped before migrating epoch ticks for epoch %d: %w", epoch, err)
 	}
 
-	err = m.MigrateEpochTicks(epoch, newStore)
+	err = m.MigrateEpochTicks(ctx, epoch, newStore)
 	if err != nil {
 		return fmt.Errorf("migrating epoch ticks for epoch %d: %w", epoch, err)
 	}
@@ -46,34 +250,132 @@ func (m *Migrator) MigrateEpoch(epoch uint32) error {
 	if m.compactAfterMigrate {
 
 		log.Println("Performing compaction on migrated database...")
-		err = newStore.ArchiverStore.GetDB().Compact(context.Background(), []byte{0x00}, []byte{0xFF}, true)
+		err = newStore.Compact(ctx)
 		if err != nil {
 			return fmt.Errorf("compacting new epoch store v2 for epoch %d: %w", epoch, err)
 		}
 	}
 
+	err = newStore.MarkEpochComplete()
+	if err != nil {
+		return fmt.Errorf("marking epoch %d as migrated: %w", epoch, err)
+	}
+
 	return nil
 }
 
-func (m *Migrator) MigrateAllEpochs() error {
-	oldStoreMetadata := m.oldStore.StoreMetadata
+func (m *Migrator) MigrateAllEpochs(ctx context.Context) error {
+	return m.migrateEpochs(ctx, slices.Sorted(maps.Keys(m.source.Metadata().Epochs)))
+}
 
-	for _, epochMetadata := range oldStoreMetadata.Epochs {
-		err := m.MigrateEpoch(epochMetadata.Epoch)
-		if err != nil {
-			return fmt.Errorf("migrating epoch %d: %w", epochMetadata.Epoch, err)
-		}
+// MigrateEpochRange migrates the epochs of the v1 store from start to end, both included, the same way the epoch
+// selector start-end selects them.
+func (m *Migrator) MigrateEpochRange(ctx context.Context, start, end uint32) error {
+	epochs, err := ResolveEpochSelector(fmt.Sprintf("%d-%d", start, end), m.source.Metadata())
+	if err != nil {
+		return fmt.Errorf("resolving epoch range %d-%d: %w", start, end, err)
 	}
-	return nil
+	return m.migrateEpochs(ctx, epochs)
 }
 
-func (m *Migrator) MigrateEpochRange(start, end uint32) error {
+// MigrateEpochs migrates the epochs, for example the ones resolved from an epoch selector.
+func (m *Migrator) MigrateEpochs(ctx context.Context, epochs []uint32) error {
+	return m.migrateEpochs(ctx, slices.Clone(epochs))
+}
 
-	for epoch := start; epoch <= end; epoch++ {
-		err := m.MigrateEpoch(epoch)
-		if err != nil {
-			return fmt.Errorf("migrating epoch %d: %w", epoch, err)
+// migrateEpochs migrates up to epochConcurrency epochs at once. With more than one epoch at a time the largest ones are
+// started first, epochs of the same size keep their order, otherwise the epochs are migrated in the given order. Each
+// epoch is written into its own store, so they are independent of each other. A failed epoch does not stop the others,
+// all errors are collected and returned together. Once ctx is cancelled no further epochs are started, and the running
+// ones stop at their next batch boundary.
+func (m *Migrator) migrateEpochs(ctx context.Context, epochs []uint32) error {
+	if m.epochConcurrency > 1 {
+		slices.SortStableFunc(epochs, func(a, b uint32) int {
+			return cmp.Compare(m.epochSize(b), m.epochSize(a))
+		})
+	}
+
+	jobs := make(chan uint32)
+
+	var mutex sync.Mutex
+	var errs []error
+	decisions := make(map[uint32]string)
+	var migrated int
+	var skipped []uint32
+
+	var wg sync.WaitGroup
+	for range min(m.epochConcurrency, len(epochs)) {
+		wg.Go(func() {
+			for epoch := range jobs {
+				status, decision, err := m.migrateEpochWithDecision(ctx, epoch)
+				if err != nil {
+					log.Printf("Failed to migrate epoch %d: %v\n", epoch, err)
+				}
+
+				mutex.Lock()
+				decisions[epoch] = decision
+				switch status {
+				case EpochStatusMigrated:
+					migrated++
+				case EpochStatusSkipped:
+					skipped = append(skipped, epoch)
+				}
+				if err != nil {
+					errs = append(errs, fmt.Errorf("migrating epoch %d: %w", epoch, err))
+				}
+				mutex.Unlock()
+			}
+		})
+	}
+
+dispatch:
+	for _, epoch := range epochs {
+		select {
+		case jobs <- epoch:
+		case <-ctx.Done():
+			break dispatch
 		}
 	}
-	return nil
+	close(jobs)
+	wg.Wait()
+
+	log.Printf("Migrated %d of %d epochs.\n", migrated, len(epochs))
+	if len(skipped) > 0 {
+		slices.Sort(skipped)
+		log.Printf("Skipped %d epochs whose store exists: %v\n", len(skipped), skipped)
+	}
+	for _, epoch := range slices.Sorted(maps.Keys(decisions)) {
+		log.Printf("  - epoch %d: %s\n", epoch, decisions[epoch])
+	}
+	if len(decisions) < len(epochs) && ctx.Err() != nil {
+		errs = append(errs, fmt.Errorf("%d epochs not started: %w", len(epochs)-len(decisions), ctx.Err()))
+	}
+	return errors.Join(errs...)
+}
+
+// epochSize returns the number of ticks in the processed tick ranges of the epoch, which is used as an estimate of how
+// long the epoch takes to migrate.
+func (m *Migrator) epochSize(epoch uint32) uint64 {
+	var size uint64
+	for _, tickRange := range m.source.Metadata().Epochs[epoch].ProcessedTickRanges {
+		size += uint64(tickRange.End-tickRange.Start) + 1
+	}
+	return size
+}
+
+func (m *Migrator) rangeStarted(newStore EpochSink, checkpointType byte, tickRange v1.TickRange, startTick uint32) {
+	m.reporter.rangeStarted(newStore.Epoch(), checkpointType, tickRange, startTick)
+	m.metrics.rangeStarted(newStore.Epoch(), checkpointType, startTick)
+}
+
+// missingStatus reports a transaction whose v1 status is missing and what was done about it.
+func (m *Migrator) missingStatus(newStore EpochSink, tickNumber uint32, txId, outcome string) {
+	log.Printf("WARNING: epoch %d tick %d: status of transaction %s is missing, %s.\n", newStore.Epoch(), tickNumber, txId, outcome)
+	m.reporter.missingStatus(newStore.Epoch(), tickNumber, txId, outcome)
+}
+
+// recordsRead counts the records and value bytes read from the v1 store for the data type.
+func (m *Migrator) recordsRead(newStore EpochSink, checkpointType byte, records, bytes int) {
+	m.reporter.recordsRead(newStore.Epoch(), checkpointType, records)
+	m.metrics.recordsReadFromSource(newStore.Epoch(), checkpointType, records, bytes)
 }
@@ -0,0 +1,17 @@
+package migration
+
+import "testing"
+
+func TestNewMigratorBatchSize(t *testing.T) {
+	for _, batchSize := range []int{0, -1} {
+		m := NewMigrator(nil, "", Options{BatchSize: batchSize})
+		if m.batchSize != defaultBatchSize {
+			t.Errorf("batch size %d: got %d, expected the default %d", batchSize, m.batchSize, defaultBatchSize)
+		}
+	}
+
+	m := NewMigrator(nil, "", Options{BatchSize: 500})
+	if m.batchSize != 500 {
+		t.Errorf("got batch size %d, expected 500", m.batchSize)
+	}
+}
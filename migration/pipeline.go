@@ -0,0 +1,237 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+	"github.com/schollz/progressbar/v3"
+)
+
+type sourceRecord struct {
+	tickNumber uint32
+	value      []byte
+}
+
+type convertedRecord struct {
+	tickNumber uint32
+	data       []byte
+	txIds      []string
+}
+
+type pipelineChunk struct {
+	index     int
+	tickRange v1.TickRange
+	source    []sourceRecord
+	converted []convertedRecord
+	err       error
+}
+
+// rangePipeline describes how the records of one tick keyed v1 prefix are migrated by runRangePipeline.
+type rangePipeline struct {
+	description    string
+	oldPrefix      int
+	checkpointType byte
+	// checkpointFrom is the first tick for which the checkpoint may be advanced. Chunks before it are only read.
+	checkpointFrom uint32
+	convert        func(record sourceRecord) (convertedRecord, error)
+	write          func(writer rangeWriter, record convertedRecord) error
+}
+
+// runRangePipeline migrates the ticks from startTick to the end of the range in three stages. The range is split into
+// chunks of batchSize ticks. Readers iterate the chunks with their own v1 iterators, converters decode and re-encode the
+// records, and a single writer commits the chunks in tick order, one batch per chunk, so the checkpoint always points at
+// a tick below which everything is written. The number of chunks in flight is bounded to keep memory usage flat.
+// Cancelling ctx stops the pipeline before the next chunk is written.
+func (m *Migrator) runRangePipeline(ctx context.Context, tickRange v1.TickRange, startTick uint32, pipeline rangePipeline, newStore EpochSink) error {
+
+	start := time.Now()
+
+	bar := progressbar.Default(int64(tickRange.End-startTick)+1, fmt.Sprintf("Migrating %s ticks %d to %d", pipeline.description, startTick, tickRange.End))
+
+	m.rangeStarted(newStore, pipeline.checkpointType, tickRange, startTick)
+
+	var chunks []v1.TickRange
+	for chunkStart := uint64(startTick); chunkStart <= uint64(tickRange.End); chunkStart += uint64(m.batchSize) {
+		chunkEnd := min(chunkStart+uint64(m.batchSize)-1, uint64(tickRange.End))
+		chunks = append(chunks, v1.TickRange{Start: uint32(chunkStart), End: uint32(chunkEnd)})
+	}
+
+	workers := m.pipelineWorkers
+	inFlight := make(chan struct{}, 2*workers)
+	done := make(chan struct{})
+	defer close(done)
+
+	toRead := make(chan *pipelineChunk)
+	toConvert := make(chan *pipelineChunk, workers)
+	toWrite := make(chan *pipelineChunk, workers)
+
+	go func() {
+		defer close(toRead)
+		for index, chunk := range chunks {
+			select {
+			case inFlight <- struct{}{}:
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case toRead <- &pipelineChunk{index: index, tickRange: chunk}:
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var readers sync.WaitGroup
+	for range workers {
+		readers.Go(func() {
+			for chunk := range toRead {
+				chunk.source, chunk.err = m.readChunk(chunk.tickRange, pipeline.oldPrefix)
+				m.recordsRead(newStore, pipeline.checkpointType, len(chunk.source), sourceBytes(chunk.source))
+				toConvert <- chunk
+			}
+		})
+	}
+	go func() {
+		readers.Wait()
+		close(toConvert)
+	}()
+
+	var converters sync.WaitGroup
+	for range workers {
+		converters.Go(func() {
+			for chunk := range toConvert {
+				if chunk.err == nil {
+					chunk.converted = make([]convertedRecord, 0, len(chunk.source))
+					for _, record := range chunk.source {
+						converted, err := pipeline.convert(record)
+						if err != nil {
+							chunk.err = err
+							break
+						}
+						chunk.converted = append(chunk.converted, converted)
+					}
+					chunk.source = nil
+				}
+				toWrite <- chunk
+			}
+		})
+	}
+	go func() {
+		converters.Wait()
+		close(toWrite)
+	}()
+
+	// The writer returns on the first error. Closing done stops the dispatcher, and the remaining chunks are drained
+	// in the background so that the readers and converters can finish.
+	drain := func() {
+		go func() {
+			for range toWrite {
+			}
+		}()
+	}
+
+	writer, err := m.newRangeWriter(newStore, true)
+	if err != nil {
+		drain()
+		return fmt.Errorf("creating writer for %s range %v: %w", pipeline.description, tickRange, err)
+	}
+	defer writer.close()
+
+	pending := make(map[int]*pipelineChunk)
+	next := 0
+	records := 0
+
+	for chunk := range toWrite {
+		pending[chunk.index] = chunk
+
+		for {
+			current, exists := pending[next]
+			if !exists {
+				break
+			}
+			delete(pending, next)
+
+			// Once cancelled, the chunks committed so far are kept and the checkpoint points at the last of them.
+			err := ctx.Err()
+			if err != nil {
+				drain()
+				return fmt.Errorf("%s range %v stopped at tick %d: %w", pipeline.description, tickRange, current.tickRange.Start, err)
+			}
+
+			err = m.writeChunk(ctx, writer, tickRange, current, pipeline, next == len(chunks)-1)
+			<-inFlight
+			if err != nil {
+				drain()
+				return err
+			}
+			records += len(current.converted)
+
+			_ = bar.Add(int(current.tickRange.End - current.tickRange.Start + 1))
+			next++
+		}
+	}
+
+	if next != len(chunks) {
+		err = ctx.Err()
+		if err != nil {
+			return fmt.Errorf("%s range %v stopped after %d of %d chunks: %w", pipeline.description, tickRange, next, len(chunks), err)
+		}
+		return fmt.Errorf("%s pipeline for range %v finished after %d of %d chunks", pipeline.description, tickRange, next, len(chunks))
+	}
+
+	logThroughput(pipeline.description, tickRange, records, start)
+	return nil
+}
+
+func (m *Migrator) readChunk(chunk v1.TickRange, oldPrefix int) ([]sourceRecord, error) {
+	var records []sourceRecord
+	err := m.source.IterateTicks(oldPrefix, chunk, func(tickNumber uint32, value []byte) error {
+		records = append(records, sourceRecord{
+			tickNumber: tickNumber,
+			value:      append([]byte(nil), value...),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading chunk %v: %w", chunk, err)
+	}
+	return records, nil
+}
+
+func sourceBytes(records []sourceRecord) int {
+	var size int
+	for _, record := range records {
+		size += len(record.value)
+	}
+	return size
+}
+
+func (m *Migrator) writeChunk(ctx context.Context, writer rangeWriter, tickRange v1.TickRange, chunk *pipelineChunk, pipeline rangePipeline, final bool) error {
+	if chunk.err != nil {
+		return fmt.Errorf("processing %s chunk %v in range %v: %w", pipeline.description, chunk.tickRange, tickRange, chunk.err)
+	}
+
+	for _, record := range chunk.converted {
+		err := pipeline.write(writer, record)
+		if err != nil {
+			return fmt.Errorf("writing %s for tick %d in range %v: %w", pipeline.description, record.tickNumber, tickRange, err)
+		}
+	}
+
+	if chunk.tickRange.End < pipeline.checkpointFrom {
+		return nil
+	}
+
+	err := writer.commit(ctx, pipeline.checkpointType, tickRange.Start, chunk.tickRange.End, final)
+	if err != nil {
+		return fmt.Errorf("committing %s range %v: %w", pipeline.description, tickRange, err)
+	}
+	return nil
+}
@@ -0,0 +1,482 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	migratorStore "github.com/qubic/archiver-db-migrator/store"
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+	archiverV2Store "github.com/qubic/go-archiver-v2/db"
+	protoV2 "github.com/qubic/go-archiver-v2/protobuf"
+	protoV1 "github.com/qubic/go-archiver/protobuff"
+	archiverV1Store "github.com/qubic/go-archiver/store"
+)
+
+// plannedDataTypes are the data types the plan estimates, in the order they are printed.
+var plannedDataTypes = []string{
+	DataTypeTickData,
+	DataTypeQuorumData,
+	DataTypeTransaction,
+	DataTypeTransactionStatus,
+}
+
+// DataTypeEstimate is the estimated scope of migrating one data type of an epoch.
+type DataTypeEstimate struct {
+	Records     uint64
+	SourceBytes uint64
+	TargetBytes uint64
+	Duration    time.Duration
+}
+
+type EpochPlan struct {
+	Epoch     uint32
+	Ticks     uint64
+	Estimates map[string]DataTypeEstimate
+	// Duration is the estimated wall time of the epoch. Tick data, transactions and statuses are migrated one after
+	// the other, at the same time as the quorum data.
+	Duration time.Duration
+	// PreflightErrors lists the reasons the epoch would fail to migrate. Epochs failing the checks of the epoch metadata
+	// are not estimated, missing statuses found while sampling are listed along with the estimates.
+	PreflightErrors []string
+}
+
+// sampleResult holds what migrating a sample of ticks of one data type took.
+type sampleResult struct {
+	records     uint64
+	sourceBytes uint64
+	targetBytes uint64
+	elapsed     time.Duration
+}
+
+// PlanEpochs estimates the scope of migrating the epochs without writing anything. Sizes of tick keyed data come from
+// the pebble estimates of the v1 key ranges. Record counts, the sizes of the data keyed by transaction id and the
+// durations are extrapolated from a benchmark that migrates a sample of sampleTicks ticks of every epoch into an in
+// temporary epoch store.
+func (m *Migrator) PlanEpochs(ctx context.Context, epochs []uint32, sampleTicks uint32) ([]*EpochPlan, error) {
+	var plans []*EpochPlan
+	for _, epoch := range epochs {
+		err := ctx.Err()
+		if err != nil {
+			return nil, err
+		}
+
+		plan, err := m.planEpoch(ctx, epoch, max(sampleTicks, 1))
+		if err != nil {
+			return nil, fmt.Errorf("planning epoch %d: %w", epoch, err)
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+func (m *Migrator) planEpoch(ctx context.Context, epoch uint32, sampleTicks uint32) (*EpochPlan, error) {
+	plan := &EpochPlan{
+		Epoch:     epoch,
+		Estimates: make(map[string]DataTypeEstimate),
+	}
+
+	err := m.preflightEpoch(ctx, plan)
+	if err != nil {
+		return nil, err
+	}
+	if len(plan.PreflightErrors) > 0 {
+		return plan, nil
+	}
+
+	log.Printf("Planning epoch %d\n", epoch)
+
+	epochMetadata := m.source.Metadata().Epochs[epoch]
+	plan.Ticks = m.epochSize(epoch)
+
+	window := sampleWindow(epochMetadata.ProcessedTickRanges, sampleTicks)
+	samples, missingStatuses, err := m.sampleEpoch(ctx, epoch, window)
+	if err != nil {
+		return nil, fmt.Errorf("sampling ticks %v: %w", window, err)
+	}
+	if missingStatuses > 0 {
+		plan.PreflightErrors = append(plan.PreflightErrors, fmt.Sprintf("%d transactions in the sampled ticks %v have no status, which the %s missing status policy fails on", missingStatuses, window, MissingStatusAbort))
+	}
+	sampledTicks := uint64(window.End-window.Start) + 1
+
+	// Data keyed by transaction id can not be estimated by tick range. Its raw sample size is scaled with the ratio of
+	// the estimated disk usage of the sampled tick data to its raw size, to account for compression.
+	sampleDiskUsage, err := m.estimateDiskUsage(archiverV1Store.TickData, []v1.TickRange{window})
+	if err != nil {
+		return nil, err
+	}
+	compression := 1.0
+	if samples[DataTypeTickData].sourceBytes > 0 && sampleDiskUsage > 0 {
+		compression = float64(sampleDiskUsage) / float64(samples[DataTypeTickData].sourceBytes)
+	}
+
+	tickRanges := epochMetadata.ProcessedTickRanges
+	sourceBytes := make(map[string]uint64)
+	sourceBytes[DataTypeTickData], err = m.estimateDiskUsage(archiverV1Store.TickData, tickRanges)
+	if err != nil {
+		return nil, err
+	}
+	sourceBytes[DataTypeQuorumData], err = m.estimateDiskUsage(archiverV1Store.QuorumData, tickRanges)
+	if err != nil {
+		return nil, err
+	}
+	tickTxsStatusBytes, err := m.estimateDiskUsage(archiverV1Store.TickTransactionsStatus, tickRanges)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dataType := range plannedDataTypes {
+		sample := samples[dataType]
+
+		estimate := DataTypeEstimate{
+			Records: scale(plan.Ticks, sample.records, sampledTicks),
+		}
+
+		switch dataType {
+		case DataTypeTransaction:
+			estimate.SourceBytes = uint64(float64(scale(estimate.Records, sample.sourceBytes, sample.records)) * compression)
+		case DataTypeTransactionStatus:
+			estimate.SourceBytes = tickTxsStatusBytes + uint64(float64(scale(estimate.Records, sample.sourceBytes, sample.records))*compression)
+		default:
+			estimate.SourceBytes = sourceBytes[dataType]
+		}
+
+		estimate.TargetBytes = scale(estimate.SourceBytes, sample.targetBytes, sample.sourceBytes)
+		if sample.records > 0 {
+			estimate.Duration = time.Duration(float64(sample.elapsed) * float64(estimate.Records) / float64(sample.records))
+		}
+		plan.Estimates[dataType] = estimate
+	}
+
+	tickData := plan.Estimates[DataTypeTickData].Duration + plan.Estimates[DataTypeTransaction].Duration + plan.Estimates[DataTypeTransactionStatus].Duration
+	plan.Duration = max(tickData, plan.Estimates[DataTypeQuorumData].Duration)
+
+	return plan, nil
+}
+
+// preflightEpoch records the reasons why migrating the epoch metadata would fail.
+func (m *Migrator) preflightEpoch(ctx context.Context, plan *EpochPlan) error {
+	epoch := plan.Epoch
+
+	epochMetadata, exists := m.source.Metadata().Epochs[epoch]
+	if !exists {
+		plan.PreflightErrors = append(plan.PreflightErrors, "epoch not found in store metadata")
+		return nil
+	}
+	if len(epochMetadata.ProcessedTickRanges) == 0 {
+		plan.PreflightErrors = append(plan.PreflightErrors, "no processed tick intervals")
+	}
+
+	_, err := m.source.GetComputors(ctx, epoch)
+	if err != nil {
+		if !errors.Is(err, archiverV1Store.ErrNotFound) {
+			return fmt.Errorf("getting computors: %w", err)
+		}
+		plan.PreflightErrors = append(plan.PreflightErrors, "no computor list")
+	}
+
+	if epoch > 158 {
+		_, err = m.source.GetTargetTickVoteSignature(epoch)
+		if err != nil {
+			if !errors.Is(err, archiverV1Store.ErrNotFound) {
+				return fmt.Errorf("getting target tick vote signature: %w", err)
+			}
+			plan.PreflightErrors = append(plan.PreflightErrors, "no target tick vote signature")
+		}
+	}
+
+	return nil
+}
+
+// sampleWindow returns sampleTicks ticks from the middle of the largest tick range.
+func sampleWindow(tickRanges []v1.TickRange, sampleTicks uint32) v1.TickRange {
+	largest := tickRanges[0]
+	for _, tickRange := range tickRanges {
+		if tickRange.End-tickRange.Start > largest.End-largest.Start {
+			largest = tickRange
+		}
+	}
+
+	length := largest.End - largest.Start + 1
+	if length <= sampleTicks {
+		return largest
+	}
+
+	start := largest.Start + (length-sampleTicks)/2
+	return v1.TickRange{Start: start, End: start + sampleTicks - 1}
+}
+
+func (m *Migrator) estimateDiskUsage(prefix int, tickRanges []v1.TickRange) (uint64, error) {
+	var total uint64
+	for _, tickRange := range tickRanges {
+		usage, err := m.source.EstimateDiskUsage(prefix, tickRange)
+		if err != nil {
+			return 0, fmt.Errorf("estimating disk usage of prefix %d for tick range %v: %w", prefix, tickRange, err)
+		}
+		total += usage
+	}
+	return total, nil
+}
+
+// sampleEpoch migrates the ticks of the window into a temporary epoch store, the same way the migration does, and
+// measures every data type. The store is removed afterwards. Under the abort missing status policy, transactions without
+// a status are skipped and counted instead of failing the sample, so the plan can list them.
+func (m *Migrator) sampleEpoch(ctx context.Context, epoch uint32, window v1.TickRange) (map[string]sampleResult, int, error) {
+	dir, err := os.MkdirTemp("", ".plan-sample-*")
+	if err != nil {
+		return nil, 0, fmt.Errorf("creating temporary store directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	newStore, err := m.openSink(dir, epoch)
+	if err != nil {
+		return nil, 0, fmt.Errorf("opening temporary epoch store: %w", err)
+	}
+	defer newStore.Close()
+
+	samples := make(map[string]sampleResult)
+	txIds := newTxIdList(dir, m.txIdBufferSize)
+	defer txIds.close()
+
+	samples[DataTypeTickData], err = sampleDataType(newStore, func(batch SinkBatch, sample *sampleResult) error {
+		records, err := m.readChunk(window, archiverV1Store.TickData)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			var tickDataV1 protoV1.TickData
+			err = proto.Unmarshal(record.value, &tickDataV1)
+			if err != nil {
+				return fmt.Errorf("unmarshaling tick data for tick %d: %w", record.tickNumber, err)
+			}
+			err = txIds.add(record.tickNumber, tickDataV1.TransactionIds)
+			if err != nil {
+				return err
+			}
+
+			key := migratorStore.AssembleKey(archiverV2Store.TickData, record.tickNumber)
+			err = sample.add(batch, key, len(key)+len(record.value), tickDataV1ToV2(&tickDataV1))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("sampling tick data: %w", err)
+	}
+
+	samples[DataTypeQuorumData], err = sampleDataType(newStore, func(batch SinkBatch, sample *sampleResult) error {
+		records, err := m.readChunk(window, archiverV1Store.QuorumData)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			var quorumDataV1 protoV1.QuorumTickDataStored
+			err = proto.Unmarshal(record.value, &quorumDataV1)
+			if err != nil {
+				return fmt.Errorf("unmarshaling quorum data for tick %d: %w", record.tickNumber, err)
+			}
+
+			key := migratorStore.AssembleKey(archiverV2Store.QuorumData, record.tickNumber)
+			err = sample.add(batch, key, len(key)+len(record.value), quorumDataV1ToV2(&quorumDataV1))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("sampling quorum data: %w", err)
+	}
+
+	samples[DataTypeTransaction], err = sampleDataType(newStore, func(batch SinkBatch, sample *sampleResult) error {
+		return txIds.windows(window.Start, func(ticks []tickTxIds) error {
+			for _, tick := range ticks {
+				for _, txId := range tick.txIds {
+					txV1, err := m.source.GetTransaction(ctx, txId)
+					if err != nil {
+						return fmt.Errorf("getting transaction %s: %w", txId, err)
+					}
+
+					key := migratorStore.AssembleKey(archiverV2Store.Transaction, txId)
+					err = sample.add(batch, key, len(key)+proto.Size(txV1), transactionV1ToV2(txV1))
+					if err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("sampling transactions: %w", err)
+	}
+
+	// The tick transactions status records are estimated by tick range, so only the statuses per transaction are
+	// measured here.
+	policy := m.missingStatusPolicy
+	abort := policy != MissingStatusSkip && policy != MissingStatusRebuild
+	if abort {
+		policy = MissingStatusSkip
+	}
+	var missingStatuses int
+	samples[DataTypeTransactionStatus], err = sampleDataType(newStore, func(batch SinkBatch, sample *sampleResult) error {
+		var tickStatuses tickStatusesV1
+		return txIds.windows(window.Start, func(ticks []tickTxIds) error {
+			for _, tick := range ticks {
+				tickNumber := tick.tickNumber
+				var ttsV2 protoV2.TickTransactionsStatus
+				for _, txId := range tick.txIds {
+					txStatusV1, outcome, err := transactionStatusWithPolicy(ctx, m.source, policy, tickNumber, txId, &tickStatuses)
+					if err != nil {
+						return err
+					}
+					if abort && outcome == MissingStatusSkipped {
+						missingStatuses++
+					}
+					if txStatusV1 == nil {
+						continue
+					}
+
+					txStatusV2 := transactionStatusV1ToV2(txStatusV1)
+					ttsV2.Transactions = append(ttsV2.Transactions, txStatusV2)
+
+					key := migratorStore.AssembleKey(archiverV2Store.TransactionStatus, txId)
+					err = sample.add(batch, key, len(key)+proto.Size(txStatusV1), txStatusV2)
+					if err != nil {
+						return err
+					}
+				}
+
+				data, err := proto.Marshal(&ttsV2)
+				if err != nil {
+					return fmt.Errorf("marshaling tick transactions status for tick %d: %w", tickNumber, err)
+				}
+				err = batch.Set(migratorStore.AssembleKey(archiverV2Store.TickTransactionsStatus, uint64(tickNumber)), data)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("sampling transactions status: %w", err)
+	}
+
+	return samples, missingStatuses, nil
+}
+
+// sampleDataType times the migration of one data type into a single batch, including its commit.
+func sampleDataType(newStore EpochSink, migrate func(batch SinkBatch, sample *sampleResult) error) (sampleResult, error) {
+	var sample sampleResult
+
+	batch := newStore.NewBatch()
+	defer batch.Close()
+
+	start := time.Now()
+	err := migrate(batch, &sample)
+	if err != nil {
+		return sampleResult{}, err
+	}
+	err = batch.Commit()
+	if err != nil {
+		return sampleResult{}, fmt.Errorf("committing sample batch: %w", err)
+	}
+	sample.elapsed = time.Since(start)
+
+	return sample, nil
+}
+
+func (s *sampleResult) add(batch SinkBatch, key []byte, sourceBytes int, message proto.Message) error {
+	data, err := proto.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("marshaling record %x: %w", key, err)
+	}
+
+	err = batch.Set(key, data)
+	if err != nil {
+		return fmt.Errorf("setting record %x: %w", key, err)
+	}
+
+	s.records++
+	s.sourceBytes += uint64(sourceBytes)
+	s.targetBytes += uint64(len(key) + len(data))
+	return nil
+}
+
+// scale returns value * numerator / denominator, or zero if the denominator is zero.
+func scale(value, numerator, denominator uint64) uint64 {
+	if denominator == 0 {
+		return 0
+	}
+	return uint64(float64(value) * float64(numerator) / float64(denominator))
+}
+
+func PrintPlan(plans []*EpochPlan) {
+
+	var total EpochPlan
+	total.Estimates = make(map[string]DataTypeEstimate)
+	var failed []*EpochPlan
+
+	for _, plan := range plans {
+		if len(plan.PreflightErrors) > 0 {
+			failed = append(failed, plan)
+			continue
+		}
+
+		log.Printf("Epoch: %d\n", plan.Epoch)
+		log.Printf("  - Ticks: %d\n", plan.Ticks)
+		for _, dataType := range plannedDataTypes {
+			estimate := plan.Estimates[dataType]
+			log.Printf("  - %s: %d records, %s source, %s target, %s\n", dataType, estimate.Records, formatBytes(estimate.SourceBytes), formatBytes(estimate.TargetBytes), estimate.Duration.Round(time.Second))
+
+			totalEstimate := total.Estimates[dataType]
+			totalEstimate.Records += estimate.Records
+			totalEstimate.SourceBytes += estimate.SourceBytes
+			totalEstimate.TargetBytes += estimate.TargetBytes
+			total.Estimates[dataType] = totalEstimate
+		}
+		log.Printf("  - Estimated duration: %s\n", plan.Duration.Round(time.Second))
+
+		total.Ticks += plan.Ticks
+		total.Duration += plan.Duration
+	}
+
+	var sourceBytes, targetBytes uint64
+	for _, estimate := range total.Estimates {
+		sourceBytes += estimate.SourceBytes
+		targetBytes += estimate.TargetBytes
+	}
+	log.Printf("Total for %d epochs: %d ticks, %s source, %s target, %s with one epoch at a time\n", len(plans)-len(failed), total.Ticks, formatBytes(sourceBytes), formatBytes(targetBytes), total.Duration.Round(time.Second))
+
+	if len(failed) == 0 {
+		log.Println("All epochs pass the preflight checks.")
+		return
+	}
+
+	log.Printf("Epochs failing the preflight checks (%d):\n", len(failed))
+	for _, plan := range failed {
+		for _, reason := range plan.PreflightErrors {
+			log.Printf("  - epoch %d: %s\n", plan.Epoch, reason)
+		}
+	}
+}
+
+func formatBytes(bytes uint64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := uint64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,361 @@
+package migration
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+	v2 "github.com/qubic/archiver-db-migrator/store/v2"
+)
+
+const (
+	EpochStatusMigrated = "migrated"
+	EpochStatusSkipped  = "skipped"
+	EpochStatusFailed   = "failed"
+	// EpochStatusInterrupted is set for epochs stopped by a cancelled context. Their staged store is resumed by the
+	// next run.
+	EpochStatusInterrupted = "interrupted"
+)
+
+// Progress events written to the event stream.
+const (
+	EventEpochStarted   = "epoch_started"
+	EventRangeStarted   = "range_started"
+	EventBatchCommitted = "batch_committed"
+	EventEpochFinished  = "epoch_finished"
+	EventMissingStatus  = "missing_status"
+)
+
+type Report struct {
+	StartedAt  time.Time      `json:"startedAt"`
+	FinishedAt time.Time      `json:"finishedAt"`
+	DurationMs int64          `json:"durationMs"`
+	Epochs     []*EpochReport `json:"epochs"`
+}
+
+type EpochReport struct {
+	Epoch      uint32                     `json:"epoch"`
+	Status     string                     `json:"status"`
+	Decision   string                     `json:"decision"`
+	Error      string                     `json:"error,omitempty"`
+	StartedAt  time.Time                  `json:"startedAt"`
+	FinishedAt time.Time                  `json:"finishedAt"`
+	DurationMs int64                      `json:"durationMs"`
+	DataTypes  map[string]*DataTypeReport `json:"dataTypes"`
+	// MissingStatuses lists the transactions whose v1 status was missing, with what the missing status policy did.
+	MissingStatuses []MissingStatus `json:"missingStatuses,omitempty"`
+}
+
+type MissingStatus struct {
+	Tick    uint32 `json:"tick"`
+	TxId    string `json:"txId"`
+	Outcome string `json:"outcome"`
+}
+
+type DataTypeReport struct {
+	RecordsRead    uint64 `json:"recordsRead"`
+	RecordsWritten uint64 `json:"recordsWritten"`
+	BytesWritten   uint64 `json:"bytesWritten"`
+	// DurationMs is the time spent migrating the tick ranges of the data type, summed over the ranges.
+	DurationMs   int64    `json:"durationMs"`
+	BatchCommits int      `json:"batchCommits"`
+	Errors       []string `json:"errors,omitempty"`
+}
+
+type Event struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	Epoch      uint32    `json:"epoch"`
+	DataType   string    `json:"dataType,omitempty"`
+	RangeStart uint32    `json:"rangeStart,omitempty"`
+	RangeEnd   uint32    `json:"rangeEnd,omitempty"`
+	StartTick  uint32    `json:"startTick,omitempty"`
+	LastTick   uint32    `json:"lastTick,omitempty"`
+	Records    uint64    `json:"records,omitempty"`
+	Bytes      uint64    `json:"bytes,omitempty"`
+	Status     string    `json:"status,omitempty"`
+	Decision   string    `json:"decision,omitempty"`
+	Tick       uint32    `json:"tick,omitempty"`
+	TxId       string    `json:"txId,omitempty"`
+	Outcome    string    `json:"outcome,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Reporter collects the statistics of a migration run for the final report, and writes progress events as NDJSON if
+// an event writer is set. It is safe for concurrent use. The methods of a nil Reporter do nothing, so the migration
+// code does not have to check whether reporting is enabled.
+type Reporter struct {
+	mutex     sync.Mutex
+	events    io.Writer
+	startedAt time.Time
+	epochs    map[uint32]*EpochReport
+}
+
+// NewReporter returns a reporter that writes progress events to events, which may be nil.
+func NewReporter(events io.Writer) *Reporter {
+	return &Reporter{
+		events:    events,
+		startedAt: time.Now(),
+		epochs:    make(map[uint32]*EpochReport),
+	}
+}
+
+// checkpointDataTypes maps the checkpoint data types to the names used in the report.
+var checkpointDataTypes = map[byte]string{
+	v2.CheckpointTickData:           DataTypeTickData,
+	v2.CheckpointQuorumData:         DataTypeQuorumData,
+	v2.CheckpointTransactions:       DataTypeTransaction,
+	v2.CheckpointTransactionsStatus: DataTypeTransactionStatus,
+	v2.CheckpointIdentityTransfers:  DataTypeIdentityTransfers,
+	v2.CheckpointChainDigest:        DataTypeChainDigest,
+	v2.CheckpointStoreDigest:        DataTypeStoreDigest,
+}
+
+func checkpointDataType(checkpointType byte) string {
+	name, exists := checkpointDataTypes[checkpointType]
+	if !exists {
+		return fmt.Sprintf("Unknown(%#x)", checkpointType)
+	}
+	return name
+}
+
+func (r *Reporter) epochStarted(epoch uint32) {
+	if r == nil {
+		return
+	}
+
+	now := time.Now()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.epochs[epoch] = &EpochReport{
+		Epoch:     epoch,
+		StartedAt: now,
+		DataTypes: make(map[string]*DataTypeReport),
+	}
+	r.emit(Event{Time: now, Event: EventEpochStarted, Epoch: epoch})
+}
+
+func (r *Reporter) epochFinished(epoch uint32, status, decision string, err error) {
+	if r == nil {
+		return
+	}
+
+	now := time.Now()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	report := r.epoch(epoch)
+	report.Status = status
+	report.Decision = decision
+	report.FinishedAt = now
+	report.DurationMs = now.Sub(report.StartedAt).Milliseconds()
+
+	event := Event{Time: now, Event: EventEpochFinished, Epoch: epoch, Status: status, Decision: decision}
+	if err != nil {
+		report.Error = err.Error()
+		event.Error = err.Error()
+	}
+	r.emit(event)
+}
+
+func (r *Reporter) rangeStarted(epoch uint32, checkpointType byte, tickRange v1.TickRange, startTick uint32) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.emit(Event{
+		Time:       time.Now(),
+		Event:      EventRangeStarted,
+		Epoch:      epoch,
+		DataType:   checkpointDataType(checkpointType),
+		RangeStart: tickRange.Start,
+		RangeEnd:   tickRange.End,
+		StartTick:  startTick,
+	})
+}
+
+func (r *Reporter) recordsRead(epoch uint32, checkpointType byte, records int) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.dataType(epoch, checkpointType).RecordsRead += uint64(records)
+}
+
+func (r *Reporter) batchCommitted(epoch uint32, checkpointType byte, rangeStart, lastTick uint32, records, bytes uint64) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	report := r.dataType(epoch, checkpointType)
+	report.RecordsWritten += records
+	report.BytesWritten += bytes
+	report.BatchCommits++
+
+	r.emit(Event{
+		Time:       time.Now(),
+		Event:      EventBatchCommitted,
+		Epoch:      epoch,
+		DataType:   checkpointDataType(checkpointType),
+		RangeStart: rangeStart,
+		LastTick:   lastTick,
+		Records:    records,
+		Bytes:      bytes,
+	})
+}
+
+func (r *Reporter) rangeFinished(epoch uint32, checkpointType byte, elapsed time.Duration) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.dataType(epoch, checkpointType).DurationMs += elapsed.Milliseconds()
+}
+
+func (r *Reporter) dataTypeFailed(epoch uint32, checkpointType byte, err error) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	report := r.dataType(epoch, checkpointType)
+	report.Errors = append(report.Errors, err.Error())
+}
+
+// checkFailed records a failed consistency check of a data type without a checkpoint, such as the empty ticks count.
+func (r *Reporter) checkFailed(epoch uint32, dataType string, err error) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	report := r.namedDataType(epoch, dataType)
+	report.Errors = append(report.Errors, err.Error())
+}
+
+func (r *Reporter) missingStatus(epoch, tickNumber uint32, txId, outcome string) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	report := r.epoch(epoch)
+	report.MissingStatuses = append(report.MissingStatuses, MissingStatus{Tick: tickNumber, TxId: txId, Outcome: outcome})
+
+	r.emit(Event{
+		Time:     time.Now(),
+		Event:    EventMissingStatus,
+		Epoch:    epoch,
+		DataType: DataTypeTransactionStatus,
+		Tick:     tickNumber,
+		TxId:     txId,
+		Outcome:  outcome,
+	})
+}
+
+// epoch returns the report of the epoch, creating it if the epoch was not started through the reporter. The caller
+// must hold the mutex.
+func (r *Reporter) epoch(epoch uint32) *EpochReport {
+	report, exists := r.epochs[epoch]
+	if !exists {
+		report = &EpochReport{
+			Epoch:     epoch,
+			StartedAt: time.Now(),
+			DataTypes: make(map[string]*DataTypeReport),
+		}
+		r.epochs[epoch] = report
+	}
+	return report
+}
+
+// dataType returns the report of the data type in the epoch. The caller must hold the mutex.
+func (r *Reporter) dataType(epoch uint32, checkpointType byte) *DataTypeReport {
+	return r.namedDataType(epoch, checkpointDataType(checkpointType))
+}
+
+// namedDataType returns the report of the data type with the name in the epoch. The caller must hold the mutex.
+func (r *Reporter) namedDataType(epoch uint32, name string) *DataTypeReport {
+	epochReport := r.epoch(epoch)
+
+	report, exists := epochReport.DataTypes[name]
+	if !exists {
+		report = &DataTypeReport{}
+		epochReport.DataTypes[name] = report
+	}
+	return report
+}
+
+// emit writes the event as one JSON line. Failing to write an event does not fail the migration. The caller must hold
+// the mutex.
+func (r *Reporter) emit(event Event) {
+	if r.events == nil {
+		return
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	_, _ = r.events.Write(append(data, '\n'))
+}
+
+// Report returns the report of the run so far, with the epochs in ascending order.
+func (r *Reporter) Report() Report {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+
+	epochs := slices.SortedFunc(maps.Values(r.epochs), func(a, b *EpochReport) int {
+		return cmp.Compare(a.Epoch, b.Epoch)
+	})
+
+	return Report{
+		StartedAt:  r.startedAt,
+		FinishedAt: now,
+		DurationMs: now.Sub(r.startedAt).Milliseconds(),
+		Epochs:     epochs,
+	}
+}
+
+// WriteReport writes the report of the run as a single line of JSON, so it can follow the event stream on the same
+// writer.
+func (r *Reporter) WriteReport(w io.Writer) error {
+	data, err := json.Marshal(r.Report())
+	if err != nil {
+		return fmt.Errorf("marshaling report: %w", err)
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+	return nil
+}
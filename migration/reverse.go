@@ -0,0 +1,582 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/golang/protobuf/proto"
+	migratorStore "github.com/qubic/archiver-db-migrator/store"
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+	archiverV2Store "github.com/qubic/go-archiver-v2/db"
+	protoV2 "github.com/qubic/go-archiver-v2/protobuf"
+	protoV1 "github.com/qubic/go-archiver/protobuff"
+	archiverV1Store "github.com/qubic/go-archiver/store"
+	"github.com/qubic/go-archiver/validator/chain"
+	"github.com/qubic/go-archiver/validator/tick"
+	"github.com/schollz/progressbar/v3"
+)
+
+// Reverser rebuilds a v1 archive from v2 epoch stores, the rollback path after a cutover to archiver v2. The records of
+// the epoch stores are mapped back to the v1 messages and written through the setters of the go-archiver store, tick by
+// tick like the v1 archiver writes them.
+//
+// The v1 indexes that can be derived from the epoch stores are regenerated instead of copied: the identity transfer
+// index from the transactions of every tick, the empty tick list and count of the epoch from its tick data, and the
+// chain and store digests from the quorum data, transactions and statuses of every tick, the way the v1 archiver
+// computes them.
+type Reverser struct {
+	oldStore     *v1.ArchiverStoreV1
+	openSink     OpenEpochSink
+	newStorePath string
+}
+
+type ReverserOptions struct {
+	// OpenSink opens the epoch stores that are read. It must not create missing stores. Nil opens existing v2 epoch
+	// stores.
+	OpenSink OpenEpochSink
+}
+
+// NewReverser returns a reverser that writes the epoch stores under newStorePath into the v1 store, which is created
+// with v1.CreateArchiverStoreV1.
+func NewReverser(oldStore *v1.ArchiverStoreV1, newStorePath string, options ReverserOptions) *Reverser {
+	openSink := options.OpenSink
+	if openSink == nil {
+		openSink = OpenExistingV2EpochSink
+	}
+
+	return &Reverser{
+		oldStore:     oldStore,
+		openSink:     openSink,
+		newStorePath: newStorePath,
+	}
+}
+
+// reverseSummary counts the records written for an epoch.
+type reverseSummary struct {
+	ticks             int
+	emptyTicks        []uint32
+	transactions      int
+	statuses          int
+	identityTransfers int
+}
+
+// ReverseEpochs writes the epochs in ascending order, so the last processed tick of the archive is the one of the most
+// recent epoch.
+func (r *Reverser) ReverseEpochs(ctx context.Context, epochs []uint32) error {
+	for _, epoch := range slices.Sorted(slices.Values(epochs)) {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		err = r.ReverseEpoch(ctx, epoch)
+		if err != nil {
+			return fmt.Errorf("reversing epoch %d: %w", epoch, err)
+		}
+	}
+	return nil
+}
+
+// ReverseEpoch writes the ticks of the processed tick ranges of the epoch, and then its metadata, so the last processed
+// tick only covers ticks that have been written. The v1 store is flushed once the epoch is written.
+func (r *Reverser) ReverseEpoch(ctx context.Context, epoch uint32) error {
+	newStore, err := r.openSink(r.newStorePath, epoch)
+	if err != nil {
+		return fmt.Errorf("opening epoch store v2 for epoch %d: %w", epoch, err)
+	}
+	defer newStore.Close()
+
+	log.Printf("Reversing epoch %d\n", epoch)
+
+	intervals, err := epochProcessedTickIntervalsV2(ctx, epoch, newStore)
+	if err != nil {
+		return err
+	}
+
+	var summary reverseSummary
+	var digests reversedDigests
+	for _, interval := range intervals.Intervals {
+		tickRange := v1.TickRange{Start: interval.InitialProcessedTick, End: interval.LastProcessedTick}
+
+		err = r.reverseTickRange(ctx, tickRange, newStore, &summary, &digests)
+		if err != nil {
+			return fmt.Errorf("reversing tick range %v: %w", tickRange, err)
+		}
+	}
+
+	err = r.reverseEpochMetadata(ctx, epoch, intervals, summary.emptyTicks, newStore)
+	if err != nil {
+		return fmt.Errorf("reversing metadata: %w", err)
+	}
+
+	err = r.oldStore.Flush()
+	if err != nil {
+		return fmt.Errorf("flushing archiver store v1: %w", err)
+	}
+
+	log.Printf("Reversed epoch %d with %d ticks (%d empty), %d transactions, %d statuses and %d identity transfer entries\n",
+		epoch, summary.ticks, len(summary.emptyTicks), summary.transactions, summary.statuses, summary.identityTransfers)
+	return nil
+}
+
+func epochProcessedTickIntervalsV2(ctx context.Context, epoch uint32, newStore EpochSink) (*protoV2.ProcessedTickIntervalsPerEpoch, error) {
+	intervals, err := newStore.GetProcessedTickIntervals(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting processed tick intervals: %w", err)
+	}
+	for _, epochIntervals := range intervals {
+		if epochIntervals.Epoch == epoch && len(epochIntervals.Intervals) > 0 {
+			return epochIntervals, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to find processed tick intervals for epoch %d", epoch)
+}
+
+// reversedDigests holds the digests regenerated for the last tick that has them, the next tick is chained to them.
+type reversedDigests struct {
+	tickNumber  uint32
+	chainDigest [32]byte
+	storeDigest [32]byte
+	hasChain    bool
+	hasStore    bool
+}
+
+// previous returns the chain and store digest the tick is chained to. Like the v1 archiver, a tick whose previous tick
+// has no digest, such as the first tick of the epoch or of an interval, is chained to an empty digest.
+func (d *reversedDigests) previous(tickNumber uint32) ([32]byte, [32]byte) {
+	var chainDigest, storeDigest [32]byte
+	if d.tickNumber+1 != tickNumber {
+		return chainDigest, storeDigest
+	}
+	if d.hasChain {
+		chainDigest = d.chainDigest
+	}
+	if d.hasStore {
+		storeDigest = d.storeDigest
+	}
+	return chainDigest, storeDigest
+}
+
+// reverseTickRange writes the quorum data of the ticks in the range, and then their tick data, transactions, identity
+// transfers, statuses and digests.
+func (r *Reverser) reverseTickRange(ctx context.Context, tickRange v1.TickRange, newStore EpochSink, summary *reverseSummary, digests *reversedDigests) error {
+	archiverStore := r.oldStore.ArchiverStore
+
+	bar := progressbar.Default(int64(tickRange.End-tickRange.Start)+1, fmt.Sprintf("Reversing quorum data ticks %d to %d", tickRange.Start, tickRange.End))
+
+	err := newStore.IterateTicks(archiverV2Store.QuorumData, tickRange, func(tickNumber uint32, value []byte) error {
+		_ = bar.Set(int(tickNumber-tickRange.Start) + 1)
+
+		var quorumDataV2 protoV2.QuorumTickDataStored
+		err := proto.Unmarshal(value, &quorumDataV2)
+		if err != nil {
+			return fmt.Errorf("unmarshaling quorum data for tick %d: %w", tickNumber, err)
+		}
+
+		err = archiverStore.SetQuorumTickData(ctx, tickNumber, quorumDataV2ToV1(&quorumDataV2))
+		if err != nil {
+			return fmt.Errorf("setting quorum data for tick %d: %w", tickNumber, err)
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return err
+	}
+	_ = bar.Finish()
+
+	bar = progressbar.Default(int64(tickRange.End-tickRange.Start)+1, fmt.Sprintf("Reversing ticks %d to %d", tickRange.Start, tickRange.End))
+
+	err = newStore.IterateTicks(archiverV2Store.TickData, tickRange, func(tickNumber uint32, value []byte) error {
+		_ = bar.Set(int(tickNumber-tickRange.Start) + 1)
+
+		var tickDataV2 protoV2.TickData
+		err := proto.Unmarshal(value, &tickDataV2)
+		if err != nil {
+			return fmt.Errorf("unmarshaling tick data for tick %d: %w", tickNumber, err)
+		}
+
+		err = r.reverseTick(ctx, tickNumber, &tickDataV2, newStore, summary, digests)
+		if err != nil {
+			return err
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return err
+	}
+	_ = bar.Finish()
+
+	return nil
+}
+
+// reverseTick writes the tick data of the tick, its transactions and their statuses, adds the transfers to the
+// identity transfer index and regenerates the digests of the tick.
+func (r *Reverser) reverseTick(ctx context.Context, tickNumber uint32, tickDataV2 *protoV2.TickData, newStore EpochSink, summary *reverseSummary, digests *reversedDigests) error {
+	archiverStore := r.oldStore.ArchiverStore
+
+	tickDataV1 := tickDataV2ToV1(tickDataV2)
+	err := archiverStore.SetTickData(ctx, tickNumber, tickDataV1)
+	if err != nil {
+		return fmt.Errorf("setting tick data for tick %d: %w", tickNumber, err)
+	}
+	summary.ticks++
+	if tick.CheckIfTickIsEmptyProto(tickDataV1) {
+		summary.emptyTicks = append(summary.emptyTicks, tickNumber)
+	}
+
+	txs := make([]*protoV1.Transaction, 0, len(tickDataV2.TransactionIds))
+	for _, txId := range tickDataV2.TransactionIds {
+		var txV2 protoV2.Transaction
+		found, err := getV2Record(newStore, migratorStore.AssembleKey(archiverV2Store.Transaction, txId), &txV2)
+		if err != nil {
+			return fmt.Errorf("getting transaction %s: %w", txId, err)
+		}
+		if !found {
+			return fmt.Errorf("transaction %s of tick %d not found", txId, tickNumber)
+		}
+		txs = append(txs, transactionV2ToV1(&txV2))
+	}
+
+	if len(txs) > 0 {
+		err = archiverStore.SetTransactions(ctx, txs)
+		if err != nil {
+			return fmt.Errorf("setting transactions for tick %d: %w", tickNumber, err)
+		}
+		summary.transactions += len(txs)
+
+		entries, err := r.storeTransfers(ctx, tickNumber, txs)
+		if err != nil {
+			return err
+		}
+		summary.identityTransfers += entries
+	}
+
+	var ttsV2 protoV2.TickTransactionsStatus
+	found, err := getV2Record(newStore, migratorStore.AssembleKey(archiverV2Store.TickTransactionsStatus, uint64(tickNumber)), &ttsV2)
+	if err != nil {
+		return fmt.Errorf("getting tick transactions status for tick %d: %w", tickNumber, err)
+	}
+	if found {
+		ttsV1 := protoV1.TickTransactionsStatus{}
+		for _, txStatus := range ttsV2.Transactions {
+			ttsV1.Transactions = append(ttsV1.Transactions, transactionStatusV2ToV1(txStatus))
+		}
+
+		// The setter also writes the status of every transaction of the record.
+		err = archiverStore.SetTickTransactionsStatus(ctx, uint64(tickNumber), &ttsV1)
+		if err != nil {
+			return fmt.Errorf("setting tick transactions status for tick %d: %w", tickNumber, err)
+		}
+		summary.statuses += len(ttsV1.Transactions)
+	}
+
+	return r.regenerateDigests(ctx, tickNumber, newStore, digests)
+}
+
+// regenerateDigests computes the chain digest of the tick from its quorum data, and from storeDigestFirstTick on the
+// store digest from its transactions and statuses, and writes them. A tick without quorum data gets no digests.
+func (r *Reverser) regenerateDigests(ctx context.Context, tickNumber uint32, newStore EpochSink, digests *reversedDigests) error {
+	archiverStore := r.oldStore.ArchiverStore
+	previousChain, previousStore := digests.previous(tickNumber)
+	*digests = reversedDigests{tickNumber: tickNumber}
+
+	var quorumDataV2 protoV2.QuorumTickDataStored
+	found, err := getV2Record(newStore, migratorStore.AssembleKey(archiverV2Store.QuorumData, tickNumber), &quorumDataV2)
+	if err != nil {
+		return fmt.Errorf("getting quorum data for tick %d: %w", tickNumber, err)
+	}
+	if !found {
+		return nil
+	}
+
+	chainDigest, err := computeChainDigest(quorumDataV2.QuorumTickStructure, previousChain)
+	if err != nil {
+		return fmt.Errorf("computing chain digest for tick %d: %w", tickNumber, err)
+	}
+	err = archiverStore.PutChainDigest(ctx, tickNumber, chainDigest[:])
+	if err != nil {
+		return fmt.Errorf("setting chain digest for tick %d: %w", tickNumber, err)
+	}
+	digests.chainDigest = chainDigest
+	digests.hasChain = true
+
+	if tickNumber < storeDigestFirstTick {
+		return nil
+	}
+
+	transactions, tickTxsStatus, err := migratedTickTransactions(newStore, tickNumber)
+	if err != nil {
+		return fmt.Errorf("getting transactions for tick %d: %w", tickNumber, err)
+	}
+	store := chain.Store{
+		PreviousTickStoreDigest: previousStore,
+		ValidTxs:                transactions,
+		TickTxsStatus:           tickTxsStatus,
+	}
+	storeDigest, err := store.Digest()
+	if err != nil {
+		return fmt.Errorf("computing store digest for tick %d: %w", tickNumber, err)
+	}
+	err = archiverStore.PutStoreDigest(ctx, tickNumber, storeDigest[:])
+	if err != nil {
+		return fmt.Errorf("setting store digest for tick %d: %w", tickNumber, err)
+	}
+	digests.storeDigest = storeDigest
+	digests.hasStore = true
+	return nil
+}
+
+// storeTransfers adds the transfers of the tick to the identity transfer index of their source and destination, like
+// the v1 archiver's transaction validator does, and returns the number of index entries written.
+func (r *Reverser) storeTransfers(ctx context.Context, tickNumber uint32, txs []*protoV1.Transaction) (int, error) {
+	var identities []string
+	transfersPerIdentity := make(map[string][]*protoV1.Transaction)
+	for _, tx := range txs {
+		if tx.Amount == 0 {
+			continue
+		}
+
+		for _, identity := range []string{tx.SourceId, tx.DestId} {
+			if _, ok := transfersPerIdentity[identity]; !ok {
+				identities = append(identities, identity)
+			}
+			transfersPerIdentity[identity] = append(transfersPerIdentity[identity], tx)
+		}
+	}
+
+	for _, identity := range identities {
+		err := r.oldStore.ArchiverStore.PutTransferTransactionsPerTick(ctx, identity, tickNumber, &protoV1.TransferTransactionsPerTick{
+			TickNumber:   tickNumber,
+			Identity:     identity,
+			Transactions: transfersPerIdentity[identity],
+		})
+		if err != nil {
+			return 0, fmt.Errorf("setting transfers of identity %s for tick %d: %w", identity, tickNumber, err)
+		}
+	}
+	return len(identities), nil
+}
+
+// reverseEpochMetadata writes the metadata of the epoch. Setting the last processed tick also updates the global last
+// processed tick of the v1 store, and the end of the last processed tick interval of the epoch.
+func (r *Reverser) reverseEpochMetadata(ctx context.Context, epoch uint32, intervals *protoV2.ProcessedTickIntervalsPerEpoch, emptyTicks []uint32, newStore EpochSink) error {
+	archiverStore := r.oldStore.ArchiverStore
+
+	computors, err := newStore.GetComputors(ctx, epoch)
+	if err != nil && !errors.Is(err, archiverV2Store.ErrNotFound) {
+		return fmt.Errorf("getting computors for epoch %d: %w", epoch, err)
+	}
+	if err != nil || computors == nil || len(computors.Computors) == 0 {
+		log.Printf("No computors stored for epoch %d, skipping.\n", epoch)
+	} else {
+		// The v1 store keeps a single computor list per epoch, the most recent one.
+		err = archiverStore.SetComputors(ctx, epoch, computorsV2ToV1(computors.Computors[len(computors.Computors)-1]))
+		if err != nil {
+			return fmt.Errorf("storing computors for epoch %d: %w", epoch, err)
+		}
+	}
+
+	err = archiverStore.SetProcessedTickIntervalPerEpoch(ctx, epoch, processedTickIntervalsV2ToV1(intervals))
+	if err != nil {
+		return fmt.Errorf("storing processed tick intervals for epoch %d: %w", epoch, err)
+	}
+
+	lastProcessedTick, err := newStore.GetLastProcessedTick(ctx)
+	if err != nil {
+		return fmt.Errorf("getting last processed tick for epoch %d: %w", epoch, err)
+	}
+	err = archiverStore.SetLastProcessedTick(ctx, &protoV1.ProcessedTick{
+		TickNumber: lastProcessedTick.TickNumber,
+		Epoch:      epoch,
+	})
+	if err != nil {
+		return fmt.Errorf("storing last processed tick for epoch %d: %w", epoch, err)
+	}
+
+	lastTickQuorumData, err := newStore.GetLastTickQuorumDataListPerEpochInterval(epoch)
+	if err != nil && !errors.Is(err, archiverV2Store.ErrNotFound) {
+		return fmt.Errorf("getting last tick quorum data list for epoch %d: %w", epoch, err)
+	}
+	if err != nil {
+		log.Printf("No last tick quorum data stored for epoch %d, skipping.\n", epoch)
+	} else {
+		err = archiverStore.SetLastTickQuorumDataPerEpochIntervals(epoch, lastTickQuorumDataV2ToV1(lastTickQuorumData))
+		if err != nil {
+			return fmt.Errorf("storing last tick quorum data list for epoch %d: %w", epoch, err)
+		}
+	}
+
+	// The v1 archiver keeps the empty tick list and count of the epoch in sync while it processes ticks, so both are
+	// regenerated from the tick data.
+	err = archiverStore.SetEmptyTicksForEpoch(epoch, uint32(len(emptyTicks)))
+	if err != nil {
+		return fmt.Errorf("storing empty ticks count for epoch %d: %w", epoch, err)
+	}
+	err = archiverStore.SetEmptyTickListPerEpoch(epoch, emptyTicks)
+	if err != nil {
+		return fmt.Errorf("storing empty tick list for epoch %d: %w", epoch, err)
+	}
+
+	// v1 keeps a single list of skipped tick intervals for the whole archive, the epochs are appended in order. An
+	// interval that lies between two epochs is held by the stores of both, it is only appended once.
+	skippedTicks, err := newStore.GetSkippedTicksIntervals()
+	if err != nil {
+		return fmt.Errorf("getting skipped ticks intervals: %w", err)
+	}
+	existing, err := archiverStore.GetSkippedTicksInterval(ctx)
+	if err != nil && !errors.Is(err, archiverV1Store.ErrNotFound) {
+		return fmt.Errorf("getting stored skipped ticks intervals: %w", err)
+	}
+	for _, interval := range skippedTicks {
+		stored := slices.ContainsFunc(existing.GetSkippedTicks(), func(storedInterval *protoV1.SkippedTicksInterval) bool {
+			return storedInterval.StartTick == interval.StartTick && storedInterval.EndTick == interval.EndTick
+		})
+		if stored {
+			continue
+		}
+
+		err = archiverStore.SetSkippedTicksInterval(ctx, &protoV1.SkippedTicksInterval{
+			StartTick: interval.StartTick,
+			EndTick:   interval.EndTick,
+		})
+		if err != nil {
+			return fmt.Errorf("storing skipped ticks interval %d to %d: %w", interval.StartTick, interval.EndTick, err)
+		}
+	}
+
+	if epoch > 158 {
+		targetTickVoteSignature, err := newStore.GetTargetTickVoteSignature(epoch)
+		if err != nil && !errors.Is(err, archiverV2Store.ErrNotFound) {
+			return fmt.Errorf("getting target tick vote signature for epoch %d: %w", epoch, err)
+		}
+		if err != nil {
+			log.Printf("No target tick vote signature stored for epoch %d, skipping.\n", epoch)
+		} else {
+			err = archiverStore.SetTargetTickVoteSignature(epoch, targetTickVoteSignature)
+			if err != nil {
+				return fmt.Errorf("storing target tick vote signature for epoch %d: %w", epoch, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func tickDataV2ToV1(tickDataV2 *protoV2.TickData) *protoV1.TickData {
+	return &protoV1.TickData{
+		ComputorIndex:  tickDataV2.ComputorIndex,
+		Epoch:          tickDataV2.Epoch,
+		TickNumber:     tickDataV2.TickNumber,
+		Timestamp:      tickDataV2.Timestamp,
+		VarStruct:      tickDataV2.VarStruct,
+		TimeLock:       tickDataV2.TimeLock,
+		TransactionIds: tickDataV2.TransactionIds,
+		ContractFees:   tickDataV2.ContractFees,
+		SignatureHex:   tickDataV2.SignatureHex,
+	}
+}
+
+func quorumDataV2ToV1(quorumDataV2 *protoV2.QuorumTickDataStored) *protoV1.QuorumTickDataStored {
+	quorumDiffPerComputorV1 := make(map[uint32]*protoV1.QuorumDiffStored)
+	for index, diff := range quorumDataV2.QuorumDiffPerComputor {
+		quorumDiffPerComputorV1[index] = &protoV1.QuorumDiffStored{
+			ExpectedNextTickTxDigestHex: diff.ExpectedNextTickTxDigestHex,
+			SignatureHex:                diff.SignatureHex,
+		}
+	}
+
+	return &protoV1.QuorumTickDataStored{
+		QuorumTickStructure:   quorumTickStructureV2ToV1(quorumDataV2.QuorumTickStructure),
+		QuorumDiffPerComputor: quorumDiffPerComputorV1,
+	}
+}
+
+func quorumTickStructureV2ToV1(structure *protoV2.QuorumTickStructure) *protoV1.QuorumTickStructure {
+	if structure == nil {
+		return nil
+	}
+	return &protoV1.QuorumTickStructure{
+		Epoch:                        structure.Epoch,
+		TickNumber:                   structure.TickNumber,
+		Timestamp:                    structure.Timestamp,
+		PrevResourceTestingDigestHex: structure.PrevResourceTestingDigestHex,
+		PrevSpectrumDigestHex:        structure.PrevSpectrumDigestHex,
+		PrevUniverseDigestHex:        structure.PrevUniverseDigestHex,
+		PrevComputerDigestHex:        structure.PrevComputerDigestHex,
+		TxDigestHex:                  structure.TxDigestHex,
+		PrevTransactionBodyHex:       structure.PrevTransactionBodyHex,
+	}
+}
+
+func transactionV2ToV1(txV2 *protoV2.Transaction) *protoV1.Transaction {
+	return &protoV1.Transaction{
+		SourceId:     txV2.SourceId,
+		DestId:       txV2.DestId,
+		Amount:       txV2.Amount,
+		TickNumber:   txV2.TickNumber,
+		InputType:    txV2.InputType,
+		InputSize:    txV2.InputSize,
+		InputHex:     txV2.InputHex,
+		SignatureHex: txV2.SignatureHex,
+		TxId:         txV2.TxId,
+	}
+}
+
+func transactionStatusV2ToV1(txStatusV2 *protoV2.TransactionStatus) *protoV1.TransactionStatus {
+	return &protoV1.TransactionStatus{
+		TxId:      txStatusV2.TxId,
+		MoneyFlew: txStatusV2.MoneyFlew,
+	}
+}
+
+func computorsV2ToV1(computors *protoV2.Computors) *protoV1.Computors {
+	return &protoV1.Computors{
+		Epoch:        computors.Epoch,
+		Identities:   computors.Identities,
+		SignatureHex: computors.SignatureHex,
+	}
+}
+
+func processedTickIntervalsV2ToV1(intervals *protoV2.ProcessedTickIntervalsPerEpoch) *protoV1.ProcessedTickIntervalsPerEpoch {
+	intervalsV1 := protoV1.ProcessedTickIntervalsPerEpoch{
+		Epoch:     intervals.Epoch,
+		Intervals: make([]*protoV1.ProcessedTickInterval, 0, len(intervals.Intervals)),
+	}
+	for _, interval := range intervals.Intervals {
+		intervalsV1.Intervals = append(intervalsV1.Intervals, &protoV1.ProcessedTickInterval{
+			InitialProcessedTick: interval.InitialProcessedTick,
+			LastProcessedTick:    interval.LastProcessedTick,
+		})
+	}
+	return &intervalsV1
+}
+
+func lastTickQuorumDataV2ToV1(lastTickQuorumDataV2 *protoV2.LastTickQuorumDataPerEpochIntervals) *protoV1.LastTickQuorumDataPerEpochIntervals {
+	var lastTickQuorumDataV1 protoV1.LastTickQuorumDataPerEpochIntervals
+	lastTickQuorumDataV1.QuorumDataPerInterval = make(map[int32]*protoV1.QuorumTickData)
+	for index, quorumData := range lastTickQuorumDataV2.QuorumDataPerInterval {
+
+		quorumDiffPerComputor := make(map[uint32]*protoV1.QuorumDiff)
+		for index2, diff := range quorumData.QuorumDiffPerComputor {
+			quorumDiffPerComputor[index2] = &protoV1.QuorumDiff{
+				SaltedResourceTestingDigestHex: diff.SaltedResourceTestingDigestHex,
+				SaltedSpectrumDigestHex:        diff.SaltedSpectrumDigestHex,
+				SaltedUniverseDigestHex:        diff.SaltedUniverseDigestHex,
+				SaltedComputerDigestHex:        diff.SaltedComputerDigestHex,
+				ExpectedNextTickTxDigestHex:    diff.ExpectedNextTickTxDigestHex,
+				SignatureHex:                   diff.SignatureHex,
+				SaltedTransactionBodyHex:       diff.SaltedTransactionBodyHex,
+			}
+		}
+
+		lastTickQuorumDataV1.QuorumDataPerInterval[index] = &protoV1.QuorumTickData{
+			QuorumTickStructure:   quorumTickStructureV2ToV1(quorumData.QuorumTickStructure),
+			QuorumDiffPerComputor: quorumDiffPerComputor,
+		}
+	}
+
+	return &lastTickQuorumDataV1
+}
@@ -0,0 +1,137 @@
+package migration
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	protoV1 "github.com/qubic/go-archiver/protobuff"
+)
+
+// The records below set every field, so a field that a mapper drops makes the round trip through v2 fail.
+
+func testQuorumTickStructureV1() *protoV1.QuorumTickStructure {
+	return &protoV1.QuorumTickStructure{
+		Epoch:                        158,
+		TickNumber:                   13752100,
+		Timestamp:                    1734000000000,
+		PrevResourceTestingDigestHex: "01020304",
+		PrevSpectrumDigestHex:        "spectrum",
+		PrevUniverseDigestHex:        "universe",
+		PrevComputerDigestHex:        "computer",
+		TxDigestHex:                  "tx",
+		PrevTransactionBodyHex:       "05060708",
+	}
+}
+
+func TestTickDataRoundTrip(t *testing.T) {
+	tickData := &protoV1.TickData{
+		ComputorIndex:  7,
+		Epoch:          158,
+		TickNumber:     13752100,
+		Timestamp:      1734000000000,
+		VarStruct:      []byte{1, 2},
+		TimeLock:       []byte{3, 4},
+		TransactionIds: []string{"a", "b"},
+		ContractFees:   []int64{5, 6},
+		SignatureHex:   "signature",
+	}
+
+	reversed := tickDataV2ToV1(tickDataV1ToV2(tickData))
+	if !proto.Equal(reversed, tickData) {
+		t.Fatalf("got tick data %v, expected %v", reversed, tickData)
+	}
+}
+
+func TestQuorumDataRoundTrip(t *testing.T) {
+	quorumData := &protoV1.QuorumTickDataStored{
+		QuorumTickStructure: testQuorumTickStructureV1(),
+		QuorumDiffPerComputor: map[uint32]*protoV1.QuorumDiffStored{
+			0:   {ExpectedNextTickTxDigestHex: "next0", SignatureHex: "signature0"},
+			675: {ExpectedNextTickTxDigestHex: "next675", SignatureHex: "signature675"},
+		},
+	}
+
+	reversed := quorumDataV2ToV1(quorumDataV1ToV2(quorumData))
+	if !proto.Equal(reversed, quorumData) {
+		t.Fatalf("got quorum data %v, expected %v", reversed, quorumData)
+	}
+
+	if quorumTickStructureV2ToV1(nil) != nil {
+		t.Fatal("expected no quorum tick structure for a missing one")
+	}
+}
+
+func TestTransactionRoundTrip(t *testing.T) {
+	tx := &protoV1.Transaction{
+		SourceId:     "source",
+		DestId:       "destination",
+		Amount:       100,
+		TickNumber:   13752100,
+		InputType:    1,
+		InputSize:    2,
+		InputHex:     "0102",
+		SignatureHex: "signature",
+		TxId:         "tx",
+	}
+	reversed := transactionV2ToV1(transactionV1ToV2(tx))
+	if !proto.Equal(reversed, tx) {
+		t.Fatalf("got transaction %v, expected %v", reversed, tx)
+	}
+
+	status := &protoV1.TransactionStatus{TxId: "tx", MoneyFlew: true}
+	reversedStatus := transactionStatusV2ToV1(transactionStatusV1ToV2(status))
+	if !proto.Equal(reversedStatus, status) {
+		t.Fatalf("got transaction status %v, expected %v", reversedStatus, status)
+	}
+}
+
+func TestEpochMetadataRoundTrip(t *testing.T) {
+	computors := &protoV1.Computors{Epoch: 158, Identities: []string{"a", "b"}, SignatureHex: "signature"}
+	computorsList := computorsV1ToV2(computors)
+	if len(computorsList.Computors) != 1 {
+		t.Fatalf("got %d computor lists, expected 1", len(computorsList.Computors))
+	}
+	reversedComputors := computorsV2ToV1(computorsList.Computors[0])
+	if !proto.Equal(reversedComputors, computors) {
+		t.Fatalf("got computors %v, expected %v", reversedComputors, computors)
+	}
+
+	intervals := &protoV1.ProcessedTickIntervalsPerEpoch{
+		Epoch: 158,
+		Intervals: []*protoV1.ProcessedTickInterval{
+			{InitialProcessedTick: 10, LastProcessedTick: 19},
+			{InitialProcessedTick: 30, LastProcessedTick: 39},
+		},
+	}
+	otherEpoch := &protoV1.ProcessedTickIntervalsPerEpoch{
+		Epoch:     157,
+		Intervals: []*protoV1.ProcessedTickInterval{{InitialProcessedTick: 1, LastProcessedTick: 9}},
+	}
+	reversedIntervals := processedTickIntervalsV2ToV1(processedTickIntervalsV1ToV2(158, []*protoV1.ProcessedTickIntervalsPerEpoch{otherEpoch, intervals}))
+	if !proto.Equal(reversedIntervals, intervals) {
+		t.Fatalf("got processed tick intervals %v, expected %v", reversedIntervals, intervals)
+	}
+
+	lastTickQuorumData := &protoV1.LastTickQuorumDataPerEpochIntervals{
+		QuorumDataPerInterval: map[int32]*protoV1.QuorumTickData{
+			0: {
+				QuorumTickStructure: testQuorumTickStructureV1(),
+				QuorumDiffPerComputor: map[uint32]*protoV1.QuorumDiff{
+					3: {
+						SaltedResourceTestingDigestHex: "resource",
+						SaltedSpectrumDigestHex:        "spectrum",
+						SaltedUniverseDigestHex:        "universe",
+						SaltedComputerDigestHex:        "computer",
+						ExpectedNextTickTxDigestHex:    "next",
+						SignatureHex:                   "signature",
+						SaltedTransactionBodyHex:       "body",
+					},
+				},
+			},
+		},
+	}
+	reversedLastTickQuorumData := lastTickQuorumDataV2ToV1(lastTickQuorumDataV1ToV2(lastTickQuorumData))
+	if !proto.Equal(reversedLastTickQuorumData, lastTickQuorumData) {
+		t.Fatalf("got last tick quorum data %v, expected %v", reversedLastTickQuorumData, lastTickQuorumData)
+	}
+}
@@ -0,0 +1,128 @@
+package migration_test
+
+import (
+	"context"
+	"maps"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/qubic/archiver-db-migrator/generator"
+	"github.com/qubic/archiver-db-migrator/migration"
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+)
+
+// TestRoundTrip generates a v1 archive, migrates it, verifies the epoch stores against it including the recomputed
+// digests, reverses the epoch stores into a new v1 archive and verifies the epoch stores against that one as well.
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	generatedPath := filepath.Join(dir, "generated")
+	newStorePath := filepath.Join(dir, "new")
+	reversedPath := filepath.Join(dir, "reversed")
+
+	summary, err := generator.Generate(ctx, generatedPath, generator.Options{
+		Seed:                7,
+		FirstEpoch:          158,
+		Epochs:              2,
+		FirstTick:           13752100,
+		IntervalsPerEpoch:   2,
+		TicksPerInterval:    40,
+		IntervalGap:         10,
+		EmptyTickRatio:      0.1,
+		TransactionsPerTick: 3,
+		TransferRatio:       0.5,
+		MoneyFlewRatio:      0.5,
+		Identities:          20,
+		Computors:           10,
+		QuorumVotesPerTick:  3,
+	})
+	if err != nil {
+		t.Fatalf("generating archive: %v", err)
+	}
+	if summary.Epochs != 2 || summary.Transactions == 0 {
+		t.Fatalf("unexpected generator summary %+v", summary)
+	}
+
+	generatedStore, err := v1.NewArchiverStoreV1(ctx, generatedPath)
+	if err != nil {
+		t.Fatalf("opening generated archive: %v", err)
+	}
+	defer generatedStore.Close()
+
+	epochs := []uint32{158, 159}
+	migrator := migration.NewMigrator(migration.NewV1Source(generatedStore), newStorePath, migration.Options{
+		BatchSize:        100,
+		WriteMode:        migration.WriteModeBatch,
+		StagingLeftovers: migration.StagingLeftoversResume,
+		ExistingTarget:   migration.ExistingTargetFail,
+		MissingStatus:    migration.MissingStatusAbort,
+		StatusStrategy:   migration.StatusStrategyAuto,
+	})
+	err = migrator.MigrateEpochs(ctx, epochs)
+	if err != nil {
+		t.Fatalf("migrating epochs: %v", err)
+	}
+
+	verifyAgainst(t, ctx, generatedStore, newStorePath, epochs)
+
+	reversedStore, err := v1.CreateArchiverStoreV1(reversedPath)
+	if err != nil {
+		t.Fatalf("creating reversed archive: %v", err)
+	}
+	// Reversing the epochs again leaves the archive as it is, the skipped tick intervals are not appended twice.
+	for range 2 {
+		err = migration.NewReverser(reversedStore, newStorePath, migration.ReverserOptions{}).ReverseEpochs(ctx, epochs)
+		if err != nil {
+			t.Fatalf("reversing epochs: %v", err)
+		}
+	}
+	err = reversedStore.Close()
+	if err != nil {
+		t.Fatalf("closing reversed archive: %v", err)
+	}
+
+	reversedStore, err = v1.NewArchiverStoreV1(ctx, reversedPath)
+	if err != nil {
+		t.Fatalf("opening reversed archive: %v", err)
+	}
+	defer reversedStore.Close()
+
+	reversedEpochs := slices.Sorted(maps.Keys(reversedStore.StoreMetadata.Epochs))
+	if !slices.Equal(reversedEpochs, epochs) {
+		t.Fatalf("reversed archive has epochs %v, expected %v", reversedEpochs, epochs)
+	}
+
+	verifyAgainst(t, ctx, reversedStore, newStorePath, epochs)
+
+	generatedSkipped, err := generatedStore.ArchiverStore.GetSkippedTicksInterval(ctx)
+	if err != nil {
+		t.Fatalf("getting generated skipped ticks intervals: %v", err)
+	}
+	reversedSkipped, err := reversedStore.ArchiverStore.GetSkippedTicksInterval(ctx)
+	if err != nil {
+		t.Fatalf("getting reversed skipped ticks intervals: %v", err)
+	}
+	if !proto.Equal(reversedSkipped, generatedSkipped) {
+		t.Fatalf("reversed skipped ticks intervals %v, expected %v", reversedSkipped, generatedSkipped)
+	}
+}
+
+func verifyAgainst(t *testing.T, ctx context.Context, oldStore *v1.ArchiverStoreV1, newStorePath string, epochs []uint32) {
+	t.Helper()
+
+	verifier := migration.NewVerifier(migration.NewV1Source(oldStore), newStorePath, migration.VerifierOptions{
+		RecomputeDigests: true,
+		MissingStatus:    migration.MissingStatusAbort,
+	})
+	results, err := verifier.VerifyEpochs(ctx, epochs)
+	if err != nil {
+		t.Fatalf("verifying epochs: %v", err)
+	}
+	for _, result := range results {
+		for _, mismatch := range result.Mismatches {
+			t.Errorf("epoch %d: %+v", result.Epoch, mismatch)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	pebbleV2 "github.com/cockroachdb/pebble/v2"
+	migratorStore "github.com/qubic/archiver-db-migrator/store"
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+	v2 "github.com/qubic/archiver-db-migrator/store/v2"
+	archiverV2Store "github.com/qubic/go-archiver-v2/db"
+	protoV2 "github.com/qubic/go-archiver-v2/protobuf"
+)
+
+// EpochSink is the store one epoch is migrated into. The setters follow the v2 archiver store, the records of the
+// ranges are written through batches or table writers together with the migration checkpoints. The getters are used by
+// the modes that read migrated epochs, like the verification and the reverse migration.
+type EpochSink interface {
+	Epoch() uint32
+
+	SetComputors(ctx context.Context, epoch uint32, computors *protoV2.ComputorsList) error
+	SetProcessedTickIntervalPerEpoch(ctx context.Context, epoch uint32, intervals *protoV2.ProcessedTickIntervalsPerEpoch) error
+	SetLastProcessedTick(ctx context.Context, lastProcessedTick *protoV2.ProcessedTick) error
+	GetLastProcessedTick(ctx context.Context) (*protoV2.ProcessedTick, error)
+	SetLastTickQuorumDataPerEpochIntervals(epoch uint32, quorumData *protoV2.LastTickQuorumDataPerEpochIntervals) error
+	SetTargetTickVoteSignature(epoch, value uint32) error
+	SetTransactions(ctx context.Context, txs []*protoV2.Transaction) error
+	SetTickTransactionsStatus(ctx context.Context, tickNumber uint64, tts *protoV2.TickTransactionsStatus) error
+	SetEmptyTicksCount(epoch, count uint32) error
+	GetEmptyTicksCount(epoch uint32) (uint32, bool, error)
+	SetSkippedTicksIntervals(intervals []v2.SkippedTicksInterval) error
+
+	GetComputors(ctx context.Context, epoch uint32) (*protoV2.ComputorsList, error)
+	GetProcessedTickIntervals(ctx context.Context) ([]*protoV2.ProcessedTickIntervalsPerEpoch, error)
+	GetLastTickQuorumDataListPerEpochInterval(epoch uint32) (*protoV2.LastTickQuorumDataPerEpochIntervals, error)
+	GetTargetTickVoteSignature(epoch uint32) (uint32, error)
+	GetSkippedTicksIntervals() ([]v2.SkippedTicksInterval, error)
+
+	// NewBatch returns a batch whose records are written atomically on commit.
+	NewBatch() SinkBatch
+	// NewTableWriter returns a writer of sorted records that are ingested at once. Temporary files go to tempDir.
+	NewTableWriter(tempDir string) (SinkTableWriter, error)
+	// IterateRecords calls handle for every record under the key prefix, in key order. The key and value are only
+	// valid until handle returns.
+	IterateRecords(prefix byte, handle func(key, value []byte) error) error
+	// IterateTicks calls handle for every record of the tick keyed prefix in the tick range, in ascending tick order.
+	// The value is only valid until handle returns.
+	IterateTicks(prefix int, tickRange v1.TickRange, handle func(tickNumber uint32, value []byte) error) error
+	// GetRecord returns the value stored under the key, and false if there is none.
+	GetRecord(key []byte) ([]byte, bool, error)
+
+	// GetCheckpoint returns the last tick committed for the data type and tick range, and false if there is none.
+	GetCheckpoint(dataType byte, rangeStart uint32) (uint32, bool, error)
+	SetCheckpoint(dataType byte, rangeStart, lastTick uint32) error
+	// DeleteCheckpoints removes the checkpoints of all tick ranges of the data type.
+	DeleteCheckpoints(dataType byte) error
+	// DeleteRecords removes the records with keys from start up to end, end excluded.
+	DeleteRecords(start, end []byte) error
+	IsEpochComplete() (bool, error)
+	MarkEpochComplete() error
+	MarkEpochIncomplete() error
+	// HasCheckpoints reports whether any data type has a checkpoint.
+	HasCheckpoints() (bool, error)
+	// RemoveMigrationKeys removes the checkpoints and the epoch complete marker before the store is published.
+	RemoveMigrationKeys(ctx context.Context) error
+
+	Compact(ctx context.Context) error
+	Close() error
+}
+
+// SinkBatch collects records that are written atomically together with a checkpoint.
+type SinkBatch interface {
+	Set(key, value []byte) error
+	SetCheckpoint(dataType byte, rangeStart, lastTick uint32) error
+	// Commit makes the records durable and empties the batch for reuse.
+	Commit() error
+	Close() error
+}
+
+// SinkTableWriter writes records in ascending key order, they become visible when they are ingested.
+type SinkTableWriter interface {
+	Set(key, value []byte) error
+	Ingest(ctx context.Context) error
+	Close() error
+}
+
+// OpenEpochSink opens the sink of the epoch under the directory, creating it if it does not exist yet.
+type OpenEpochSink func(directory string, epoch uint32) (EpochSink, error)
+
+// v2EpochSink writes into a v2 epoch store. The setters are the ones of the go-archiver-v2 store.
+type v2EpochSink struct {
+	*archiverV2Store.PebbleStore
+	store *v2.ArchiverEpochStoreV2
+}
+
+// OpenV2EpochSink opens the v2 epoch store of the epoch under the directory.
+func OpenV2EpochSink(directory string, epoch uint32) (EpochSink, error) {
+	store, err := v2.NewArchiverEpochStoreV2(directory, epoch)
+	if err != nil {
+		return nil, err
+	}
+	return NewV2EpochSink(store), nil
+}
+
+// OpenExistingV2EpochSink opens the v2 epoch store of the epoch under the directory if it exists. It fails with
+// v2.ErrEpochStoreNotFound instead of creating the store, for the modes that only read the epoch stores.
+func OpenExistingV2EpochSink(directory string, epoch uint32) (EpochSink, error) {
+	store, err := v2.OpenArchiverEpochStoreV2(directory, epoch)
+	if err != nil {
+		return nil, err
+	}
+	return NewV2EpochSink(store), nil
+}
+
+// NewV2EpochSink returns a sink writing into the v2 epoch store.
+func NewV2EpochSink(store *v2.ArchiverEpochStoreV2) EpochSink {
+	return &v2EpochSink{
+		PebbleStore: store.ArchiverStore,
+		store:       store,
+	}
+}
+
+func (s *v2EpochSink) Epoch() uint32 {
+	return s.store.Epoch()
+}
+
+func (s *v2EpochSink) SetEmptyTicksCount(epoch, count uint32) error {
+	return s.store.SetEmptyTicksCount(epoch, count)
+}
+
+func (s *v2EpochSink) GetEmptyTicksCount(epoch uint32) (uint32, bool, error) {
+	return s.store.GetEmptyTicksCount(epoch)
+}
+
+func (s *v2EpochSink) SetSkippedTicksIntervals(intervals []v2.SkippedTicksInterval) error {
+	return s.store.SetSkippedTicksIntervals(intervals)
+}
+
+func (s *v2EpochSink) GetSkippedTicksIntervals() ([]v2.SkippedTicksInterval, error) {
+	return s.store.GetSkippedTicksIntervals()
+}
+
+func (s *v2EpochSink) NewBatch() SinkBatch {
+	return &v2Batch{batch: s.GetDB().NewBatch()}
+}
+
+func (s *v2EpochSink) NewTableWriter(tempDir string) (SinkTableWriter, error) {
+	writer, err := s.store.NewSSTableWriter(tempDir)
+	if err != nil {
+		return nil, err
+	}
+	return &v2TableWriter{SSTableWriter: writer}, nil
+}
+
+func (s *v2EpochSink) IterateRecords(prefix byte, handle func(key, value []byte) error) error {
+	iter, err := s.GetDB().NewIter(
+		&pebbleV2.IterOptions{
+			LowerBound: []byte{prefix},
+			UpperBound: []byte{prefix + 1},
+		})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		value, err := iter.ValueAndErr()
+		if err != nil {
+			return fmt.Errorf("getting value for key %x: %w", iter.Key(), err)
+		}
+		err = handle(iter.Key(), value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *v2EpochSink) IterateTicks(prefix int, tickRange v1.TickRange, handle func(tickNumber uint32, value []byte) error) error {
+	iter, err := s.GetDB().NewIter(
+		&pebbleV2.IterOptions{
+			LowerBound: migratorStore.AssembleKey(prefix, tickRange.Start),
+			UpperBound: migratorStore.AssembleKey(prefix, tickRange.End+1),
+		})
+	if err != nil {
+		return fmt.Errorf("creating iterator: %w", err)
+	}
+	defer iter.Close()
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		tickNumber := tickFromKey(iter.Key())
+
+		value, err := iter.ValueAndErr()
+		if err != nil {
+			return fmt.Errorf("getting value for tick %d: %w", tickNumber, err)
+		}
+		err = handle(tickNumber, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *v2EpochSink) GetRecord(key []byte) ([]byte, bool, error) {
+	value, closer, err := s.GetDB().Get(key)
+	if err != nil {
+		if errors.Is(err, pebbleV2.ErrNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	defer closer.Close()
+	return bytes.Clone(value), true, nil
+}
+
+func (s *v2EpochSink) GetCheckpoint(dataType byte, rangeStart uint32) (uint32, bool, error) {
+	return s.store.GetCheckpoint(dataType, rangeStart)
+}
+
+func (s *v2EpochSink) SetCheckpoint(dataType byte, rangeStart, lastTick uint32) error {
+	return v2.SetCheckpoint(s.GetDB(), dataType, rangeStart, lastTick)
+}
+
+func (s *v2EpochSink) DeleteCheckpoints(dataType byte) error {
+	return s.store.DeleteCheckpoints(dataType)
+}
+
+func (s *v2EpochSink) DeleteRecords(start, end []byte) error {
+	return s.GetDB().DeleteRange(start, end, pebbleV2.Sync)
+}
+
+func (s *v2EpochSink) IsEpochComplete() (bool, error) {
+	return s.store.IsEpochComplete()
+}
+
+func (s *v2EpochSink) MarkEpochComplete() error {
+	return s.store.MarkEpochComplete()
+}
+
+func (s *v2EpochSink) MarkEpochIncomplete() error {
+	return s.store.MarkEpochIncomplete()
+}
+
+func (s *v2EpochSink) HasCheckpoints() (bool, error) {
+	return s.store.HasCheckpoints()
+}
+
+func (s *v2EpochSink) RemoveMigrationKeys(ctx context.Context) error {
+	return s.store.RemoveMigrationKeys(ctx)
+}
+
+func (s *v2EpochSink) Compact(ctx context.Context) error {
+	return s.GetDB().Compact(ctx, []byte{0x00}, []byte{0xFF}, true)
+}
+
+func (s *v2EpochSink) Close() error {
+	return s.store.Close()
+}
+
+// pebbleDB returns the pebble database of the store, whose metrics are exported while it is open.
+func (s *v2EpochSink) pebbleDB() *pebbleV2.DB {
+	return s.GetDB()
+}
+
+type v2Batch struct {
+	batch *pebbleV2.Batch
+}
+
+func (b *v2Batch) Set(key, value []byte) error {
+	return b.batch.Set(key, value, nil)
+}
+
+func (b *v2Batch) SetCheckpoint(dataType byte, rangeStart, lastTick uint32) error {
+	return v2.SetCheckpoint(b.batch, dataType, rangeStart, lastTick)
+}
+
+func (b *v2Batch) Commit() error {
+	err := b.batch.Commit(pebbleV2.Sync)
+	if err != nil {
+		return err
+	}
+	b.batch.Reset()
+	return nil
+}
+
+func (b *v2Batch) Close() error {
+	return b.batch.Close()
+}
+
+type v2TableWriter struct {
+	*v2.SSTableWriter
+}
+
+func (w *v2TableWriter) Set(key, value []byte) error {
+	return w.SSTableWriter.Set(key, value, nil)
+}
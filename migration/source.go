@@ -0,0 +1,161 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	pebbleV1 "github.com/cockroachdb/pebble"
+	migratorStore "github.com/qubic/archiver-db-migrator/store"
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+	protoV1 "github.com/qubic/go-archiver/protobuff"
+	archiverV1Store "github.com/qubic/go-archiver/store"
+)
+
+// Source is the archive the epochs are migrated from. The getters follow the v1 archiver store, tick keyed records are
+// read by v1 key prefix.
+type Source interface {
+	// Metadata returns the epochs of the source with their processed tick ranges and last processed ticks.
+	Metadata() v1.StoreMetadata
+
+	GetComputors(ctx context.Context, epoch uint32) (*protoV1.Computors, error)
+	GetProcessedTickIntervals(ctx context.Context) ([]*protoV1.ProcessedTickIntervalsPerEpoch, error)
+	GetLastTickQuorumDataListPerEpochInterval(epoch uint32) (*protoV1.LastTickQuorumDataPerEpochIntervals, error)
+	GetTargetTickVoteSignature(epoch uint32) (uint32, error)
+	GetEmptyTicksForEpoch(epoch uint32) (uint32, error)
+	GetSkippedTicksInterval(ctx context.Context) (*protoV1.SkippedTicksIntervalList, error)
+
+	GetTransaction(ctx context.Context, txId string) (*protoV1.Transaction, error)
+	GetTransactionStatus(ctx context.Context, txId string) (*protoV1.TransactionStatus, error)
+	GetTickTransactions(ctx context.Context, tickNumber uint32) ([]*protoV1.Transaction, error)
+	GetTickTransactionsStatus(ctx context.Context, tickNumber uint64) (*protoV1.TickTransactionsStatus, error)
+
+	// IterateTicks calls handle for every record of the tick keyed prefix in the tick range, in ascending tick order.
+	// The value is only valid until handle returns.
+	IterateTicks(prefix int, tickRange v1.TickRange, handle func(tickNumber uint32, value []byte) error) error
+	// IterateIdentityTransfers calls handle for every identity transfer index entry whose tick lies in one of the tick
+	// ranges, which must be sorted. The entries come in identity order. The value is only valid until handle returns.
+	IterateIdentityTransfers(tickRanges []v1.TickRange, handle func(identity []byte, tickNumber uint64, value []byte) error) error
+	// EstimateDiskUsage returns the estimated number of bytes the records of the tick keyed prefix in the tick range
+	// take on disk.
+	EstimateDiskUsage(prefix int, tickRange v1.TickRange) (uint64, error)
+}
+
+// v1Source reads from a v1 archiver store. The getters are the ones of the go-archiver store.
+type v1Source struct {
+	*archiverV1Store.PebbleStore
+	store *v1.ArchiverStoreV1
+}
+
+// NewV1Source returns a source reading from the v1 archiver store.
+func NewV1Source(store *v1.ArchiverStoreV1) Source {
+	return &v1Source{
+		PebbleStore: store.ArchiverStore,
+		store:       store,
+	}
+}
+
+func (s *v1Source) Metadata() v1.StoreMetadata {
+	return s.store.StoreMetadata
+}
+
+func (s *v1Source) IterateTicks(prefix int, tickRange v1.TickRange, handle func(tickNumber uint32, value []byte) error) error {
+	iter, err := s.store.NewIter(
+		&pebbleV1.IterOptions{
+			LowerBound: migratorStore.AssembleKey(prefix, tickRange.Start),
+			UpperBound: migratorStore.AssembleKey(prefix, tickRange.End+1),
+		})
+	if err != nil {
+		return fmt.Errorf("creating iterator: %w", err)
+	}
+	defer iter.Close()
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		tickNumber := tickFromKey(iter.Key())
+
+		value, err := iter.ValueAndErr()
+		if err != nil {
+			return fmt.Errorf("getting value for tick %d: %w", tickNumber, err)
+		}
+		err = handle(tickNumber, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IterateIdentityTransfers walks the v1 identity transfer index. It is keyed by identity first, so the entries of the
+// ranges are spread over the whole prefix. For every identity the iterator seeks straight to the next tick range instead
+// of reading the ticks outside of them.
+func (s *v1Source) IterateIdentityTransfers(tickRanges []v1.TickRange, handle func(identity []byte, tickNumber uint64, value []byte) error) error {
+	iter, err := s.store.NewIter(
+		&pebbleV1.IterOptions{
+			LowerBound: []byte{archiverV1Store.IdentityTransferTransactions},
+			UpperBound: []byte{archiverV1Store.IdentityTransferTransactions + 1},
+		})
+	if err != nil {
+		return fmt.Errorf("creating iterator for identity transfers: %w", err)
+	}
+	defer iter.Close()
+
+	iter.First()
+	for iter.Valid() {
+		key := iter.Key()
+		if len(key) <= 9 {
+			return fmt.Errorf("invalid identity transfers key %x", key)
+		}
+		identity := bytes.Clone(key[1 : len(key)-8])
+		tickNumber := binary.BigEndian.Uint64(key[len(key)-8:])
+
+		nextTick, found := nextTickInRanges(tickNumber, tickRanges)
+		if !found {
+			// Past the last range, continue with the next identity.
+			seekKey := append([]byte{archiverV1Store.IdentityTransferTransactions}, identity...)
+			iter.SeekGE(append(seekKey, bytes.Repeat([]byte{0xFF}, 9)...))
+			continue
+		}
+		if nextTick != tickNumber {
+			iter.SeekGE(identityTransfersKeyV1(identity, nextTick))
+			continue
+		}
+
+		value, err := iter.ValueAndErr()
+		if err != nil {
+			return fmt.Errorf("getting identity transfers for identity %s and tick %d: %w", identity, tickNumber, err)
+		}
+		err = handle(identity, tickNumber, value)
+		if err != nil {
+			return err
+		}
+
+		iter.Next()
+	}
+	return nil
+}
+
+func (s *v1Source) EstimateDiskUsage(prefix int, tickRange v1.TickRange) (uint64, error) {
+	return s.store.GetDB().EstimateDiskUsage(migratorStore.AssembleKey(prefix, tickRange.Start), migratorStore.AssembleKey(prefix, tickRange.End+1))
+}
+
+func identityTransfersKeyV1(identity []byte, tickNumber uint64) []byte {
+	key := []byte{archiverV1Store.IdentityTransferTransactions}
+	key = append(key, identity...)
+	key = binary.BigEndian.AppendUint64(key, tickNumber)
+	return key
+}
+
+// nextTickInRanges returns the tick itself if it lies in one of the ranges, otherwise the start of the next range above
+// it. The ranges must be sorted. The boolean is false if there is no range at or above the tick.
+func nextTickInRanges(tickNumber uint64, tickRanges []v1.TickRange) (uint64, bool) {
+	for _, tickRange := range tickRanges {
+		if tickNumber < uint64(tickRange.Start) {
+			return uint64(tickRange.Start), true
+		}
+		if tickNumber <= uint64(tickRange.End) {
+			return tickNumber, true
+		}
+	}
+	return 0, false
+}
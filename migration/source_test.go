@@ -0,0 +1,34 @@
+package migration
+
+import (
+	"testing"
+
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+)
+
+func TestNextTickInRanges(t *testing.T) {
+	tickRanges := []v1.TickRange{{Start: 10, End: 19}, {Start: 30, End: 39}}
+	tests := []struct {
+		tickNumber uint64
+		expected   uint64
+		found      bool
+	}{
+		{tickNumber: 0, expected: 10, found: true},
+		{tickNumber: 10, expected: 10, found: true},
+		{tickNumber: 19, expected: 19, found: true},
+		{tickNumber: 20, expected: 30, found: true},
+		{tickNumber: 35, expected: 35, found: true},
+		{tickNumber: 40, found: false},
+	}
+	for _, test := range tests {
+		nextTick, found := nextTickInRanges(test.tickNumber, tickRanges)
+		if found != test.found || nextTick != test.expected {
+			t.Errorf("tick %d: got %d, %v, expected %d, %v", test.tickNumber, nextTick, found, test.expected, test.found)
+		}
+	}
+
+	_, found := nextTickInRanges(0, nil)
+	if found {
+		t.Error("expected no tick without ranges")
+	}
+}
@@ -0,0 +1,224 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	v2 "github.com/qubic/archiver-db-migrator/store/v2"
+)
+
+// stagingDirectory is the directory under the new store path in which epoch stores are built. It is on the same file
+// system as the published stores, so a finished store is published with a single rename.
+const stagingDirectory = ".staging"
+
+// replacedSuffix marks a published store that is being replaced. It is only moved aside once its replacement is
+// complete, so it is always safe to remove.
+const replacedSuffix = ".replaced"
+
+const (
+	// StagingLeftoversResume keeps leftover staged epoch stores, they are continued from their checkpoints.
+	StagingLeftoversResume = "resume"
+	// StagingLeftoversClean removes leftover staged epoch stores, their epochs are migrated from scratch.
+	StagingLeftoversClean = "clean"
+)
+
+func (m *Migrator) stagingPath() string {
+	return filepath.Join(m.newStorePath, stagingDirectory)
+}
+
+// HandleStagingLeftovers looks for epoch stores that an interrupted or failed run left in the staging directory, and
+// keeps or removes them depending on the staging leftovers policy.
+func (m *Migrator) HandleStagingLeftovers() error {
+	entries, err := os.ReadDir(m.stagingPath())
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("reading staging directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(m.stagingPath(), entry.Name())
+
+		if m.stagingLeftovers == StagingLeftoversClean || strings.HasSuffix(entry.Name(), replacedSuffix) {
+			log.Printf("Removing leftover staged epoch store %s\n", path)
+			err = os.RemoveAll(path)
+			if err != nil {
+				return fmt.Errorf("removing leftover staged epoch store %s: %w", path, err)
+			}
+			continue
+		}
+
+		log.Printf("Found leftover staged epoch store %s, it is resumed when its epoch is migrated.\n", path)
+	}
+	return nil
+}
+
+// isEpochPublished reports whether the store of the epoch has been published. An unfinished store in the published
+// location was written before epoch stores were staged, it is moved to the staging directory to be resumed there.
+func (m *Migrator) isEpochPublished(epoch uint32) (bool, error) {
+	publishedPath := v2.EpochStorePath(m.newStorePath, epoch)
+
+	_, err := os.Stat(publishedPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("checking published epoch store %s: %w", publishedPath, err)
+	}
+
+	store, err := m.openSink(m.newStorePath, epoch)
+	if err != nil {
+		return false, fmt.Errorf("opening published epoch store: %w", err)
+	}
+	complete, err := publishedStoreComplete(store)
+	closeErr := store.Close()
+	if err != nil {
+		return false, err
+	}
+	if closeErr != nil {
+		return false, fmt.Errorf("closing published epoch store: %w", closeErr)
+	}
+	if complete {
+		return true, nil
+	}
+
+	stagedPath := v2.EpochStorePath(m.stagingPath(), epoch)
+	_, err = os.Stat(stagedPath)
+	if err == nil {
+		return false, fmt.Errorf("both %s and %s hold an unfinished store of the epoch, remove one of them", publishedPath, stagedPath)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return false, fmt.Errorf("checking staged epoch store %s: %w", stagedPath, err)
+	}
+
+	log.Printf("Moving unfinished epoch store %s to %s to resume it.\n", publishedPath, stagedPath)
+
+	err = os.MkdirAll(m.stagingPath(), 0755)
+	if err != nil {
+		return false, fmt.Errorf("creating staging directory: %w", err)
+	}
+	err = os.Rename(publishedPath, stagedPath)
+	if err != nil {
+		return false, fmt.Errorf("moving unfinished epoch store to staging: %w", err)
+	}
+	return false, nil
+}
+
+// publishedStoreComplete reports whether a store in the published location is complete. Stores are published without
+// their checkpoints and complete marker. A store with checkpoints but without the marker was written to the published
+// location before epoch stores were staged and is unfinished, one with the marker was published before the keys of the
+// migrator were removed.
+func publishedStoreComplete(store EpochSink) (bool, error) {
+	complete, err := store.IsEpochComplete()
+	if err != nil || complete {
+		return complete, err
+	}
+	hasCheckpoints, err := store.HasCheckpoints()
+	if err != nil {
+		return false, err
+	}
+	return !hasCheckpoints, nil
+}
+
+// publishEpoch moves the closed store of the epoch from the staging directory to its published location. With replace
+// set, the published store is first moved out of the way into the staging directory and removed afterward.
+func (m *Migrator) publishEpoch(epoch uint32, replace bool) error {
+	stagedPath := v2.EpochStorePath(m.stagingPath(), epoch)
+	publishedPath := v2.EpochStorePath(m.newStorePath, epoch)
+	replacedPath := stagedPath + replacedSuffix
+
+	if replace {
+		err := os.RemoveAll(replacedPath)
+		if err != nil {
+			return fmt.Errorf("removing %s: %w", replacedPath, err)
+		}
+		err = os.Rename(publishedPath, replacedPath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("renaming %s to %s: %w", publishedPath, replacedPath, err)
+		}
+	}
+
+	err := os.Rename(stagedPath, publishedPath)
+	if err != nil {
+		return fmt.Errorf("renaming %s to %s: %w", stagedPath, publishedPath, err)
+	}
+
+	// The rename is only durable once the parent directory is synced.
+	err = syncDirectory(m.newStorePath)
+	if err != nil {
+		return fmt.Errorf("syncing %s: %w", m.newStorePath, err)
+	}
+
+	if replace {
+		err = os.RemoveAll(replacedPath)
+		if err != nil {
+			return fmt.Errorf("removing replaced epoch store %s: %w", replacedPath, err)
+		}
+	}
+
+	log.Printf("Published epoch store %s\n", publishedPath)
+	return nil
+}
+
+// unpublishEpoch moves the published store of the epoch back to the staging directory and removes its complete marker,
+// so that it is migrated further and published again once it is complete. The published store has no checkpoints, so
+// every data type is migrated again from the start of its tick ranges.
+func (m *Migrator) unpublishEpoch(epoch uint32) error {
+	stagedPath := v2.EpochStorePath(m.stagingPath(), epoch)
+	publishedPath := v2.EpochStorePath(m.newStorePath, epoch)
+
+	_, err := os.Stat(stagedPath)
+	if err == nil {
+		return fmt.Errorf("both %s and %s hold a store of the epoch, remove one of them", publishedPath, stagedPath)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("checking staged epoch store %s: %w", stagedPath, err)
+	}
+
+	log.Printf("Moving published epoch store %s to %s to migrate it further.\n", publishedPath, stagedPath)
+
+	err = os.MkdirAll(m.stagingPath(), 0755)
+	if err != nil {
+		return fmt.Errorf("creating staging directory: %w", err)
+	}
+	err = os.Rename(publishedPath, stagedPath)
+	if err != nil {
+		return fmt.Errorf("moving published epoch store to staging: %w", err)
+	}
+	err = syncDirectory(m.newStorePath)
+	if err != nil {
+		return fmt.Errorf("syncing %s: %w", m.newStorePath, err)
+	}
+
+	store, err := m.openSink(m.stagingPath(), epoch)
+	if err != nil {
+		return fmt.Errorf("opening staged epoch store: %w", err)
+	}
+	err = store.MarkEpochIncomplete()
+	closeErr := store.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return fmt.Errorf("closing staged epoch store: %w", closeErr)
+	}
+	return nil
+}
+
+func syncDirectory(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
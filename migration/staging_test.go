@@ -0,0 +1,113 @@
+package migration
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pebbleV2 "github.com/cockroachdb/pebble/v2"
+	"github.com/qubic/archiver-db-migrator/generator"
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+	v2 "github.com/qubic/archiver-db-migrator/store/v2"
+)
+
+// TestPublishRemovesMigrationKeys migrates an epoch and checks that its published store holds none of the keys the
+// migrator only uses while it migrates, and that it is still taken as published.
+func TestPublishRemovesMigrationKeys(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	generatedPath := filepath.Join(dir, "generated")
+
+	_, err := generator.Generate(ctx, generatedPath, generator.Options{
+		Seed:                11,
+		FirstEpoch:          158,
+		Epochs:              1,
+		FirstTick:           13752100,
+		IntervalsPerEpoch:   2,
+		TicksPerInterval:    20,
+		IntervalGap:         10,
+		TransactionsPerTick: 2,
+		TransferRatio:       0.5,
+		Identities:          10,
+		Computors:           10,
+		QuorumVotesPerTick:  3,
+	})
+	if err != nil {
+		t.Fatalf("generating archive: %v", err)
+	}
+	generatedStore, err := v1.NewArchiverStoreV1(ctx, generatedPath)
+	if err != nil {
+		t.Fatalf("opening generated archive: %v", err)
+	}
+	defer generatedStore.Close()
+
+	m := NewMigrator(NewV1Source(generatedStore), filepath.Join(dir, "new"), Options{
+		BatchSize:      10,
+		WriteMode:      WriteModeBatch,
+		ExistingTarget: ExistingTargetSkip,
+		MissingStatus:  MissingStatusAbort,
+		StatusStrategy: StatusStrategyAuto,
+	})
+	err = m.MigrateEpoch(ctx, 158)
+	if err != nil {
+		t.Fatalf("migrating epoch: %v", err)
+	}
+
+	published, err := m.openSink(m.newStorePath, 158)
+	if err != nil {
+		t.Fatalf("opening published epoch store: %v", err)
+	}
+	iter, err := published.(*v2EpochSink).GetDB().NewIter(&pebbleV2.IterOptions{
+		LowerBound: []byte{v2.MigrationCheckpoint},
+		UpperBound: []byte{v2.MigrationEpochComplete + 1},
+	})
+	if err != nil {
+		t.Fatalf("creating iterator: %v", err)
+	}
+	for iter.First(); iter.Valid(); iter.Next() {
+		t.Errorf("published store holds migration key %x", iter.Key())
+	}
+	_ = iter.Close()
+	err = published.Close()
+	if err != nil {
+		t.Fatalf("closing published epoch store: %v", err)
+	}
+
+	isPublished, err := m.isEpochPublished(158)
+	if err != nil || !isPublished {
+		t.Fatalf("got published %v for the migrated epoch: %v", isPublished, err)
+	}
+	mismatch, err := m.checkPublishedEpoch(ctx, 158)
+	if err != nil || mismatch != "" {
+		t.Fatalf("got mismatch %q for the published store: %v", mismatch, err)
+	}
+}
+
+// TestUnfinishedStoreInPublishedLocation checks that a store with checkpoints but without the complete marker in the
+// published location, written before epoch stores were staged, is moved to the staging directory to be resumed.
+func TestUnfinishedStoreInPublishedLocation(t *testing.T) {
+	m := NewMigrator(metadataSource{metadata: testStoreMetadata()}, t.TempDir(), Options{})
+
+	store, err := m.openSink(m.newStorePath, 100)
+	if err != nil {
+		t.Fatalf("opening epoch store: %v", err)
+	}
+	err = store.SetCheckpoint(v2.CheckpointTickData, 10, 15)
+	if err != nil {
+		t.Fatalf("setting checkpoint: %v", err)
+	}
+	err = store.Close()
+	if err != nil {
+		t.Fatalf("closing epoch store: %v", err)
+	}
+
+	published, err := m.isEpochPublished(100)
+	if err != nil || published {
+		t.Fatalf("got published %v for an unfinished store: %v", published, err)
+	}
+	_, err = os.Stat(v2.EpochStorePath(m.stagingPath(), 100))
+	if err != nil {
+		t.Fatalf("unfinished store was not moved to the staging directory: %v", err)
+	}
+}
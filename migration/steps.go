@@ -0,0 +1,156 @@
+package migration
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	migratorStore "github.com/qubic/archiver-db-migrator/store"
+	v2 "github.com/qubic/archiver-db-migrator/store/v2"
+	archiverV2Store "github.com/qubic/go-archiver-v2/db"
+)
+
+// Steps of an epoch migration. Selecting some of them migrates only that data of an epoch again, for example after
+// fixing a problem with it.
+const (
+	// StepAll selects every step. It is the default.
+	StepAll = "all"
+	// StepMetadata migrates the computor list, the processed tick intervals, the last processed tick, the last tick
+	// quorum data of the intervals, the empty ticks count, the skipped tick intervals and the target tick vote signature.
+	StepMetadata          = "metadata"
+	StepTickData          = "tick-data"
+	StepQuorumData        = "quorum-data"
+	StepTransactions      = "transactions"
+	StepStatuses          = "statuses"
+	StepDigests           = "digests"
+	StepIdentityTransfers = "identity-transfers"
+)
+
+// Steps lists the steps in the order they run.
+var Steps = []string{StepMetadata, StepTickData, StepQuorumData, StepTransactions, StepStatuses, StepDigests, StepIdentityTransfers}
+
+// stepCheckpoints are the checkpoint data types of the steps, they are cleared when a step is selected to run again.
+var stepCheckpoints = map[string][]byte{
+	StepTickData:          {v2.CheckpointTickData},
+	StepQuorumData:        {v2.CheckpointQuorumData},
+	StepTransactions:      {v2.CheckpointTransactions},
+	StepStatuses:          {v2.CheckpointTransactionsStatus},
+	StepDigests:           {v2.CheckpointChainDigest, v2.CheckpointStoreDigest},
+	StepIdentityTransfers: {v2.CheckpointIdentityTransfers},
+}
+
+// stepPrefix is a key prefix the records of a step are written under. The records of a tick keyed prefix are removed
+// within the tick ranges of the epoch, the others, keyed by transaction id or identity, as a whole, since an epoch store
+// only holds the records of its epoch.
+type stepPrefix struct {
+	prefix    int
+	tickKeyed bool
+}
+
+// stepPrefixes are the key prefixes of the records of the steps, they are removed when a step is selected to run
+// again, so that no record of the earlier run is left behind. The metadata records are overwritten by the metadata
+// step.
+var stepPrefixes = map[string][]stepPrefix{
+	StepTickData:          {{prefix: archiverV2Store.TickData, tickKeyed: true}},
+	StepQuorumData:        {{prefix: archiverV2Store.QuorumData, tickKeyed: true}},
+	StepTransactions:      {{prefix: archiverV2Store.Transaction}},
+	StepStatuses:          {{prefix: archiverV2Store.TickTransactionsStatus, tickKeyed: true}, {prefix: archiverV2Store.TransactionStatus}},
+	StepDigests:           {{prefix: v2.ChainDigest, tickKeyed: true}, {prefix: v2.StoreDigest, tickKeyed: true}},
+	StepIdentityTransfers: {{prefix: v2.IdentityTransferTransactions}},
+}
+
+// ParseSteps parses a comma separated list of steps. It returns nil if every step is selected.
+func ParseSteps(list string) ([]string, error) {
+	var steps []string
+	for _, step := range strings.Split(list, ",") {
+		step = strings.TrimSpace(step)
+		if step == StepAll {
+			return nil, nil
+		}
+		if !slices.Contains(Steps, step) {
+			return nil, fmt.Errorf("unknown step %q, expected %s or %s", step, StepAll, strings.Join(Steps, ", "))
+		}
+		if !slices.Contains(steps, step) {
+			steps = append(steps, step)
+		}
+	}
+	if len(steps) == len(Steps) {
+		return nil, nil
+	}
+	return steps, nil
+}
+
+// runsStep reports whether the step is selected.
+func (m *Migrator) runsStep(step string) bool {
+	return m.steps == nil || slices.Contains(m.steps, step)
+}
+
+// prepareStepsRerun copies the published store of the epoch into the staging directory, so that the selected steps
+// are migrated again into the copy, which then replaces the published store. The copy is a checkpoint of the published
+// store, which hard links its table files instead of copying them. A copy left by an earlier run is replaced, the
+// selected steps always run from the start.
+func (m *Migrator) prepareStepsRerun(epoch uint32) (targetDecision, error) {
+	published, err := m.isEpochPublished(epoch)
+	if err != nil {
+		return targetDecision{}, err
+	}
+	if !published {
+		return targetDecision{decision: EpochDecisionFailed}, fmt.Errorf("selecting steps %s requires a complete published store of the epoch, migrate it with all steps first", strings.Join(m.steps, ","))
+	}
+
+	err = m.removeStagedEpoch(epoch)
+	if err != nil {
+		return targetDecision{}, err
+	}
+
+	publishedPath := v2.EpochStorePath(m.newStorePath, epoch)
+	stagedPath := v2.EpochStorePath(m.stagingPath(), epoch)
+	log.Printf("Checkpointing epoch store %s to %s to migrate %s again.\n", publishedPath, stagedPath, strings.Join(m.steps, ", "))
+
+	err = os.MkdirAll(m.stagingPath(), 0755)
+	if err != nil {
+		return targetDecision{}, fmt.Errorf("creating staging directory: %w", err)
+	}
+	err = v2.CheckpointEpochStore(m.newStorePath, epoch, m.stagingPath())
+	if err != nil {
+		return targetDecision{}, fmt.Errorf("copying epoch store %s to %s: %w", publishedPath, stagedPath, err)
+	}
+
+	return targetDecision{decision: fmt.Sprintf("%s (%s)", EpochDecisionStepsMigrated, strings.Join(m.steps, ",")), replace: true}, nil
+}
+
+// clearSteps removes the checkpoints and the records of the selected steps from the store, so that they start over
+// and leave no record of the earlier run behind.
+func (m *Migrator) clearSteps(newStore EpochSink) error {
+	tickRanges := m.source.Metadata().Epochs[newStore.Epoch()].ProcessedTickRanges
+
+	for _, step := range m.steps {
+		for _, dataType := range stepCheckpoints[step] {
+			err := newStore.DeleteCheckpoints(dataType)
+			if err != nil {
+				return fmt.Errorf("clearing checkpoints of step %s: %w", step, err)
+			}
+		}
+
+		for _, stepPrefix := range stepPrefixes[step] {
+			if !stepPrefix.tickKeyed {
+				err := newStore.DeleteRecords([]byte{byte(stepPrefix.prefix)}, []byte{byte(stepPrefix.prefix) + 1})
+				if err != nil {
+					return fmt.Errorf("clearing records of step %s: %w", step, err)
+				}
+				continue
+			}
+			for _, tickRange := range tickRanges {
+				start := migratorStore.AssembleKey(stepPrefix.prefix, tickRange.Start)
+				end := migratorStore.AssembleKey(stepPrefix.prefix, tickRange.End+1)
+				err := newStore.DeleteRecords(start, end)
+				if err != nil {
+					return fmt.Errorf("clearing records of step %s in tick range %v: %w", step, tickRange, err)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package migration
+
+import (
+	"slices"
+	"testing"
+
+	migratorStore "github.com/qubic/archiver-db-migrator/store"
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+	v2 "github.com/qubic/archiver-db-migrator/store/v2"
+	archiverV2Store "github.com/qubic/go-archiver-v2/db"
+)
+
+func TestParseSteps(t *testing.T) {
+	tests := []struct {
+		list     string
+		expected []string
+	}{
+		{list: "all", expected: nil},
+		{list: "tick-data,all", expected: nil},
+		{list: "quorum-data", expected: []string{StepQuorumData}},
+		{list: " statuses , transactions,statuses", expected: []string{StepStatuses, StepTransactions}},
+		{list: "metadata,tick-data,quorum-data,transactions,statuses,digests,identity-transfers", expected: nil},
+	}
+	for _, test := range tests {
+		steps, err := ParseSteps(test.list)
+		if err != nil {
+			t.Errorf("list %q: %v", test.list, err)
+			continue
+		}
+		if !slices.Equal(steps, test.expected) {
+			t.Errorf("list %q: got steps %v, expected %v", test.list, steps, test.expected)
+		}
+	}
+
+	for _, list := range []string{"", "tick-data,", "ticks"} {
+		_, err := ParseSteps(list)
+		if err == nil {
+			t.Errorf("list %q: expected an error", list)
+		}
+	}
+}
+
+func TestClearSteps(t *testing.T) {
+	metadata := testStoreMetadata()
+	metadata.Epochs[100] = v1.EpochMetadata{Epoch: 100, ProcessedTickRanges: []v1.TickRange{{Start: 10, End: 19}, {Start: 30, End: 39}}}
+	m := NewMigrator(metadataSource{metadata: metadata}, t.TempDir(), Options{Steps: []string{StepQuorumData, StepTransactions}})
+
+	newStore, err := m.openSink(m.newStorePath, 100)
+	if err != nil {
+		t.Fatalf("opening epoch store: %v", err)
+	}
+	defer newStore.Close()
+
+	keys := [][]byte{
+		migratorStore.AssembleKey(archiverV2Store.TickData, uint32(15)),
+		migratorStore.AssembleKey(archiverV2Store.QuorumData, uint32(15)),
+		migratorStore.AssembleKey(archiverV2Store.QuorumData, uint32(39)),
+		migratorStore.AssembleKey(archiverV2Store.QuorumData, uint32(40)),
+		migratorStore.AssembleKey(archiverV2Store.Transaction, "tx"),
+		migratorStore.AssembleKey(archiverV2Store.TransactionStatus, "tx"),
+	}
+	batch := newStore.NewBatch()
+	for _, key := range keys {
+		err = batch.Set(key, []byte{1})
+		if err != nil {
+			t.Fatalf("setting key %x: %v", key, err)
+		}
+	}
+	err = batch.SetCheckpoint(v2.CheckpointQuorumData, 10, 19)
+	if err != nil {
+		t.Fatalf("setting checkpoint: %v", err)
+	}
+	err = batch.SetCheckpoint(v2.CheckpointTickData, 10, 19)
+	if err != nil {
+		t.Fatalf("setting checkpoint: %v", err)
+	}
+	err = batch.Commit()
+	if err != nil {
+		t.Fatalf("committing batch: %v", err)
+	}
+	_ = batch.Close()
+
+	err = m.clearSteps(newStore)
+	if err != nil {
+		t.Fatalf("clearing steps: %v", err)
+	}
+
+	// The quorum data outside the tick ranges, the tick data and the statuses belong to steps that are not selected or
+	// to no tick range, they are kept.
+	expected := []bool{true, false, false, true, false, true}
+	for i, key := range keys {
+		_, exists, err := newStore.GetRecord(key)
+		if err != nil {
+			t.Fatalf("getting key %x: %v", key, err)
+		}
+		if exists != expected[i] {
+			t.Errorf("key %x exists %v, expected %v", key, exists, expected[i])
+		}
+	}
+
+	_, exists, err := newStore.GetCheckpoint(v2.CheckpointQuorumData, 10)
+	if err != nil || exists {
+		t.Errorf("quorum data checkpoint exists %v after clearing it: %v", exists, err)
+	}
+	_, exists, err = newStore.GetCheckpoint(v2.CheckpointTickData, 10)
+	if err != nil || !exists {
+		t.Errorf("tick data checkpoint exists %v after clearing other steps: %v", exists, err)
+	}
+}
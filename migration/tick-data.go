@@ -1,12 +1,9 @@
 package migration
 
 import (
-	"encoding/binary"
+	"context"
 	"fmt"
-	"runtime"
 
-	pebbleV1 "github.com/cockroachdb/pebble"
-	pebbleV2 "github.com/cockroachdb/pebble/v2"
 	"github.com/golang/protobuf/proto"
 	migratorStore "github.com/qubic/archiver-db-migrator/store"
 	v1 "github.com/qubic/archiver-db-migrator/store/v1"
@@ -18,96 +15,109 @@ import (
 	"github.com/schollz/progressbar/v3"
 )
 
-func (m *Migrator) migrateTickDataRange(tickRange v1.TickRange, newStore *v2.ArchiverEpochStoreV2) (map[uint32][]string, int, error) {
+// migrateTickDataRange writes the tick data of the ticks starting at writeStart and collects the transaction ids of the
+// ticks starting at collectStart. The two differ when a previous run was interrupted at different points for tick data
+// and transactions. The ids are spilled to disk beyond the transaction id buffer size, the caller closes the list.
+func (m *Migrator) migrateTickDataRange(ctx context.Context, tickRange v1.TickRange, writeStart, collectStart uint32, newStore EpochSink) (*txIdList, int, error) {
 
-	bar := progressbar.Default(int64(tickRange.End-tickRange.Start), fmt.Sprintf("Migrating tick data ticks %d to %d", tickRange.Start, tickRange.End))
-
-	txsPerTick := make(map[uint32][]string)
+	txIds := newTxIdList(m.newStorePath, m.txIdBufferSize)
 	txCounter := 0
 
-	iter, err := m.oldStore.GetDB().NewIter(
-		&pebbleV1.IterOptions{
-			LowerBound: migratorStore.AssembleKey(archiverV1Store.TickData, tickRange.Start),
-			UpperBound: migratorStore.AssembleKey(archiverV1Store.TickData, tickRange.End+1),
-		})
-	if err != nil {
-		return nil, 0, fmt.Errorf("creating iterator for tick data range %v: %w", tickRange, err)
-	}
-	defer iter.Close()
-
-	counter := 0
-
-	batch := newStore.ArchiverStore.GetDB().NewBatch()
-	defer batch.Close()
+	pipeline := rangePipeline{
+		description:    "tick data",
+		oldPrefix:      archiverV1Store.TickData,
+		checkpointType: v2.CheckpointTickData,
+		checkpointFrom: writeStart,
+		convert: func(record sourceRecord) (convertedRecord, error) {
+			var tickDataV1 protoV1.TickData
+			err := proto.Unmarshal(record.value, &tickDataV1)
+			if err != nil {
+				return convertedRecord{}, fmt.Errorf("unmarshaling tick data for tick %d: %w", record.tickNumber, err)
+			}
 
-	for iter.First(); iter.Valid(); iter.Next() {
+			if tickDataV1.TickNumber != 0 && record.tickNumber != tickDataV1.TickNumber {
+				return convertedRecord{}, fmt.Errorf("tick data tick number %d does not match key tick number %d", tickDataV1.TickNumber, record.tickNumber)
+			}
 
-		_ = bar.Add(1)
+			data, err := proto.Marshal(tickDataV1ToV2(&tickDataV1))
+			if err != nil {
+				return convertedRecord{}, fmt.Errorf("marshaling tick data v2 for tick %d: %w", record.tickNumber, err)
+			}
 
-		key := iter.Key()
-		tickNumber := binary.BigEndian.Uint32(key[len(key)-4:])
+			return convertedRecord{
+				tickNumber: record.tickNumber,
+				data:       data,
+				txIds:      tickDataV1.TransactionIds,
+			}, nil
+		},
+		write: func(writer rangeWriter, record convertedRecord) error {
+			if record.tickNumber >= collectStart {
+				err := txIds.add(record.tickNumber, record.txIds)
+				if err != nil {
+					return fmt.Errorf("collecting transaction ids of tick %d: %w", record.tickNumber, err)
+				}
+				txCounter += len(record.txIds)
+			}
 
-		value, err := iter.ValueAndErr()
-		if err != nil {
-			return nil, 0, fmt.Errorf("getting value for tick data tick %d in range %v: %w", tickNumber, tickRange, err)
-		}
+			if record.tickNumber < writeStart {
+				return nil
+			}
+			return writer.Set(migratorStore.AssembleKey(archiverV2Store.TickData, record.tickNumber), record.data)
+		},
+	}
 
-		var tickDataV1 protoV1.TickData
-		err = proto.Unmarshal(value, &tickDataV1)
-		if err != nil {
-			return nil, 0, fmt.Errorf("unmarshaling tick data for tick %d in range %v: %w", tickNumber, tickRange, err)
-		}
+	err := m.runRangePipeline(ctx, tickRange, min(writeStart, collectStart), pipeline, newStore)
+	if err != nil {
+		_ = txIds.close()
+		return nil, 0, err
+	}
+	return txIds, txCounter, nil
+}
 
-		if tickDataV1.TickNumber != 0 && tickNumber != tickDataV1.TickNumber {
-			return nil, 0, fmt.Errorf("tick data tick number %d does not match key tick number %d", tickDataV1.TickNumber, tickNumber)
-		}
+// collectTransactionIds reads the transaction ids of the ticks starting at collectStart from the v1 tick data, for
+// migrating the transactions and statuses without migrating the tick data. The caller closes the list.
+func (m *Migrator) collectTransactionIds(ctx context.Context, tickRange v1.TickRange, collectStart uint32) (*txIdList, int, error) {
 
-		_, exists := txsPerTick[tickDataV1.TickNumber]
-		if !exists {
-			txsPerTick[tickDataV1.TickNumber] = []string{}
-		}
+	txIds := newTxIdList(m.newStorePath, m.txIdBufferSize)
+	txCounter := 0
 
-		txsPerTick[tickDataV1.TickNumber] = append(txsPerTick[tickDataV1.TickNumber], tickDataV1.TransactionIds...)
-		txCounter += len(tickDataV1.TransactionIds)
+	bar := progressbar.Default(int64(tickRange.End-collectStart)+1, fmt.Sprintf("Collecting transaction ids ticks %d to %d", collectStart, tickRange.End))
 
-		tickDataV2 := protoV2.TickData{
-			ComputorIndex:  tickDataV1.ComputorIndex,
-			Epoch:          tickDataV1.Epoch,
-			TickNumber:     tickDataV1.TickNumber,
-			Timestamp:      tickDataV1.Timestamp,
-			VarStruct:      tickDataV1.VarStruct,
-			TimeLock:       tickDataV1.TimeLock,
-			TransactionIds: tickDataV1.TransactionIds,
-			ContractFees:   tickDataV1.ContractFees,
-			SignatureHex:   tickDataV1.SignatureHex,
-		}
+	err := m.source.IterateTicks(archiverV1Store.TickData, v1.TickRange{Start: collectStart, End: tickRange.End}, func(tickNumber uint32, value []byte) error {
+		_ = bar.Set(int(tickNumber-collectStart) + 1)
 
-		data, err := proto.Marshal(&tickDataV2)
+		var tickDataV1 protoV1.TickData
+		err := proto.Unmarshal(value, &tickDataV1)
 		if err != nil {
-			return nil, 0, fmt.Errorf("marshaling tick data v2 for tick %d in range %v: %w", tickNumber, tickRange, err)
+			return fmt.Errorf("unmarshaling tick data for tick %d: %w", tickNumber, err)
 		}
 
-		err = batch.Set(migratorStore.AssembleKey(archiverV2Store.TickData, tickNumber), data, nil)
+		err = txIds.add(tickNumber, tickDataV1.TransactionIds)
 		if err != nil {
-			return nil, 0, fmt.Errorf("setting tick data v2 for tick %d in range %v: %w", tickNumber, tickRange, err)
+			return fmt.Errorf("collecting transaction ids of tick %d: %w", tickNumber, err)
 		}
-		counter++
-
-		if counter >= m.batchSize {
-			err = batch.Commit(pebbleV2.Sync)
-			if err != nil {
-				return nil, 0, fmt.Errorf("committing batch for tick data range %v: %w", tickRange, err)
-			}
+		txCounter += len(tickDataV1.TransactionIds)
 
-			batch.Reset()
-			runtime.GC()
-			counter = 0
-		}
+		// Collecting is only a read, it stops right away once cancelled.
+		return ctx.Err()
+	})
+	if err != nil {
+		_ = txIds.close()
+		return nil, 0, err
 	}
+	return txIds, txCounter, nil
+}
 
-	err = batch.Commit(pebbleV2.Sync)
-	if err != nil {
-		return nil, 0, fmt.Errorf("committing batch for tick data range %v: %w", tickRange, err)
+func tickDataV1ToV2(tickDataV1 *protoV1.TickData) *protoV2.TickData {
+	return &protoV2.TickData{
+		ComputorIndex:  tickDataV1.ComputorIndex,
+		Epoch:          tickDataV1.Epoch,
+		TickNumber:     tickDataV1.TickNumber,
+		Timestamp:      tickDataV1.Timestamp,
+		VarStruct:      tickDataV1.VarStruct,
+		TimeLock:       tickDataV1.TimeLock,
+		TransactionIds: tickDataV1.TransactionIds,
+		ContractFees:   tickDataV1.ContractFees,
+		SignatureHex:   tickDataV1.SignatureHex,
 	}
-	return txsPerTick, txCounter, nil
 }
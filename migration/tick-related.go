@@ -1,31 +1,74 @@
 package migration
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"sync"
 
 	v1 "github.com/qubic/archiver-db-migrator/store/v1"
 	v2 "github.com/qubic/archiver-db-migrator/store/v2"
 )
 
-func (m *Migrator) MigrateEpochTicks(epoch uint32, newStore *v2.ArchiverEpochStoreV2) error {
-	epochMetadata, exists := m.oldStore.StoreMetadata.Epochs[epoch]
+func (m *Migrator) MigrateEpochTicks(ctx context.Context, epoch uint32, newStore EpochSink) error {
+	epochMetadata, exists := m.source.Metadata().Epochs[epoch]
 	if !exists {
 		return fmt.Errorf("epoch %d metadata not found", epoch)
 	}
 
 	log.Printf("Migrating tick related data for epoch %d\n", epoch)
 
-	log.Println("Migrating tick data...")
-	err := m.MigrateTickData(epochMetadata, newStore)
-	if err != nil {
-		return fmt.Errorf("migrating tick data for epoch %d: %w", epoch, err)
+	// Tick data, quorum data and the digests are independent of each other, so they are migrated at the same time.
+	var tickDataErr, quorumDataErr, digestsErr error
+	var wg sync.WaitGroup
+
+	if m.runsStep(StepTickData) || m.runsStep(StepTransactions) || m.runsStep(StepStatuses) {
+		wg.Go(func() {
+			log.Println("Migrating tick data...")
+			tickDataErr = m.MigrateTickData(ctx, epochMetadata, newStore)
+		})
+	}
+	if m.runsStep(StepQuorumData) {
+		wg.Go(func() {
+			log.Println("Migrating quorum data...")
+			quorumDataErr = m.MigrateQuorumData(ctx, epochMetadata, newStore)
+		})
+	}
+	if m.runsStep(StepDigests) {
+		wg.Go(func() {
+			log.Println("Migrating chain and store digests...")
+			digestsErr = m.MigrateDigests(ctx, epochMetadata, newStore)
+		})
 	}
+	wg.Wait()
 
-	log.Println("Migrating quorum data...")
-	err = m.MigrateQuorumData(epochMetadata, newStore)
-	if err != nil {
-		return fmt.Errorf("migrating quorum data for epoch %d: %w", epoch, err)
+	if tickDataErr != nil {
+		m.reporter.dataTypeFailed(epoch, v2.CheckpointTickData, tickDataErr)
+		return fmt.Errorf("migrating tick data for epoch %d: %w", epoch, tickDataErr)
+	}
+	if quorumDataErr != nil {
+		m.reporter.dataTypeFailed(epoch, v2.CheckpointQuorumData, quorumDataErr)
+		return fmt.Errorf("migrating quorum data for epoch %d: %w", epoch, quorumDataErr)
+	}
+	if digestsErr != nil {
+		return fmt.Errorf("migrating digests for epoch %d: %w", epoch, digestsErr)
+	}
+
+	if m.runsStep(StepIdentityTransfers) {
+		log.Println("Migrating identity transfer transactions...")
+		err := m.MigrateIdentityTransfers(ctx, epochMetadata, newStore)
+		if err != nil {
+			m.reporter.dataTypeFailed(epoch, v2.CheckpointIdentityTransfers, err)
+			return fmt.Errorf("migrating identity transfers for epoch %d: %w", epoch, err)
+		}
+	}
+
+	if m.runsStep(StepTickData) || m.runsStep(StepMetadata) {
+		log.Println("Checking empty ticks count...")
+		err := m.CheckEmptyTicksCount(epochMetadata, newStore)
+		if err != nil {
+			return fmt.Errorf("checking empty ticks count for epoch %d: %w", epoch, err)
+		}
 	}
 
 	/*log.Println("Migrating transactions...")
@@ -44,54 +87,147 @@ func (m *Migrator) MigrateEpochTicks(epoch uint32, newStore *v2.ArchiverEpochSto
 	return nil
 }
 
-func (m *Migrator) MigrateTickData(epochMetadata v1.EpochMetadata, newStore *v2.ArchiverEpochStoreV2) error {
+// MigrateTickData migrates the tick data, the transactions and the transaction statuses of the epoch, as far as their
+// steps are selected. The transaction ids the transactions and statuses are migrated for are collected while the tick
+// data is migrated, or read from the v1 tick data on their own if the tick data step does not run. The statuses are
+// migrated once the transactions of every tick range are, with the status strategy picked for the transactions of the
+// whole epoch.
+func (m *Migrator) MigrateTickData(ctx context.Context, epochMetadata v1.EpochMetadata, newStore EpochSink) error {
+
+	var pending []pendingStatuses
+	defer func() {
+		for _, statuses := range pending {
+			_ = statuses.txIds.close()
+		}
+	}()
+	epochTxCount := 0
 
 	for _, tickRange := range epochMetadata.ProcessedTickRanges {
-		txIds, txCount, err := m.migrateTickDataRange(tickRange, newStore)
+		tickDataStart, err := m.stepResumeTick(newStore, StepTickData, v2.CheckpointTickData, tickRange)
 		if err != nil {
-			return fmt.Errorf("migrating tick data range %v for epoch %d: %w", tickRange, epochMetadata.Epoch, err)
+			return fmt.Errorf("getting tick data checkpoint for tick range %v for epoch %d: %w", tickRange, epochMetadata.Epoch, err)
 		}
-
-		err = m.migrateTransactionsList(txIds, txCount, newStore)
+		txStart, err := m.stepResumeTick(newStore, StepTransactions, v2.CheckpointTransactions, tickRange)
 		if err != nil {
-			return fmt.Errorf("migrating transactions list for tick range %v for epoch %d: %w", tickRange, epochMetadata.Epoch, err)
+			return fmt.Errorf("getting transactions checkpoint for tick range %v for epoch %d: %w", tickRange, epochMetadata.Epoch, err)
+		}
+		txStatusStart, err := m.stepResumeTick(newStore, StepStatuses, v2.CheckpointTransactionsStatus, tickRange)
+		if err != nil {
+			return fmt.Errorf("getting transactions status checkpoint for tick range %v for epoch %d: %w", tickRange, epochMetadata.Epoch, err)
+		}
+
+		if tickDataStart > tickRange.End && txStart > tickRange.End && txStatusStart > tickRange.End {
+			log.Printf("Tick data for tick range %v has already been migrated, skipping.\n", tickRange)
+			continue
 		}
 
-		err = m.migrateTransactionsStatusList(txIds, newStore)
+		var txIds *txIdList
+		var txCount int
+		if tickDataStart <= tickRange.End {
+			txIds, txCount, err = m.migrateTickDataRange(ctx, tickRange, tickDataStart, min(txStart, txStatusStart), newStore)
+			if err != nil {
+				return fmt.Errorf("migrating tick data range %v for epoch %d: %w", tickRange, epochMetadata.Epoch, err)
+			}
+		} else {
+			txIds, txCount, err = m.collectTransactionIds(ctx, tickRange, min(txStart, txStatusStart))
+			if err != nil {
+				return fmt.Errorf("collecting transaction ids of tick range %v for epoch %d: %w", tickRange, epochMetadata.Epoch, err)
+			}
+		}
+		epochTxCount += txCount
+
+		if txStart <= tickRange.End {
+			err = m.migrateTransactionsList(ctx, tickRange, txStart, txIds, txCount, newStore)
+			if err != nil {
+				_ = txIds.close()
+				return fmt.Errorf("migrating transactions list for tick range %v for epoch %d: %w", tickRange, epochMetadata.Epoch, err)
+			}
+		}
+
+		if txStatusStart > tickRange.End {
+			err = txIds.close()
+			if err != nil {
+				return fmt.Errorf("removing transaction ids spill file for tick range %v for epoch %d: %w", tickRange, epochMetadata.Epoch, err)
+			}
+			continue
+		}
+
+		// The ids are kept on disk until the statuses of the range are migrated.
+		pending = append(pending, pendingStatuses{tickRange: tickRange, startTick: txStatusStart, txIds: txIds})
+		err = txIds.release()
 		if err != nil {
-			return fmt.Errorf("migrating transactions status list for tick range %v for epoch %d: %w", tickRange, epochMetadata.Epoch, err)
+			return fmt.Errorf("spilling transaction ids of tick range %v for epoch %d: %w", tickRange, epochMetadata.Epoch, err)
 		}
+	}
 
+	if len(pending) == 0 {
+		return nil
 	}
-	return nil
-}
 
-func (m *Migrator) MigrateQuorumData(epochMetadata v1.EpochMetadata, newStore *v2.ArchiverEpochStoreV2) error {
-	for _, tickRange := range epochMetadata.ProcessedTickRanges {
-		err := m.migrateQuorumDataRange(tickRange, newStore)
+	strategy := m.statusStrategyFor(epochTxCount)
+	log.Printf("Migrating the statuses of %d transactions with status strategy %s.\n", epochTxCount, strategy)
+
+	for len(pending) > 0 {
+		statuses := pending[0]
+		err := m.migrateTransactionsStatus(ctx, statuses.tickRange, statuses.startTick, strategy, statuses.txIds, newStore)
 		if err != nil {
-			return fmt.Errorf("migrating quorum data range %v for epoch %d: %w", tickRange, epochMetadata.Epoch, err)
+			return fmt.Errorf("migrating transactions status list for tick range %v for epoch %d: %w", statuses.tickRange, epochMetadata.Epoch, err)
+		}
+
+		pending = pending[1:]
+		err = statuses.txIds.close()
+		if err != nil {
+			return fmt.Errorf("removing transaction ids spill file for tick range %v for epoch %d: %w", statuses.tickRange, epochMetadata.Epoch, err)
 		}
 	}
 	return nil
 }
 
-/*func (m *Migrator) MigrateTransactions(epochMetadata v1.EpochMetadata, newStore *v2.ArchiverEpochStoreV2) error {
+// pendingStatuses is a tick range whose statuses still have to be migrated, with the transaction ids of its ticks from
+// startTick on.
+type pendingStatuses struct {
+	tickRange v1.TickRange
+	startTick uint32
+	txIds     *txIdList
+}
+
+func (m *Migrator) MigrateQuorumData(ctx context.Context, epochMetadata v1.EpochMetadata, newStore EpochSink) error {
 	for _, tickRange := range epochMetadata.ProcessedTickRanges {
-		err := m.migrateTransactionsRange(tickRange, newStore)
+		startTick, err := resumeTick(newStore, v2.CheckpointQuorumData, tickRange)
 		if err != nil {
-			return fmt.Errorf("migrating transactions range %v for epoch %d: %w", tickRange, epochMetadata.Epoch, err)
+			return fmt.Errorf("getting quorum data checkpoint for tick range %v for epoch %d: %w", tickRange, epochMetadata.Epoch, err)
 		}
-	}
-	return nil
-}*/
 
-/*func (m *Migrator) MigrateTransactionsStatus(epochMetadata v1.EpochMetadata, newStore *v2.ArchiverEpochStoreV2) error {
-	for _, tickRange := range epochMetadata.ProcessedTickRanges {
-		err := m.migrateTransactionsStatusRange(tickRange, newStore)
+		if startTick > tickRange.End {
+			log.Printf("Quorum data for tick range %v has already been migrated, skipping.\n", tickRange)
+			continue
+		}
+
+		err = m.migrateQuorumDataRange(ctx, tickRange, startTick, newStore)
 		if err != nil {
-			return fmt.Errorf("migrating transactions status range %v for epoch %d: %w", tickRange, epochMetadata.Epoch, err)
+			return fmt.Errorf("migrating quorum data range %v for epoch %d: %w", tickRange, epochMetadata.Epoch, err)
 		}
 	}
 	return nil
-}*/
+}
+
+// stepResumeTick returns the first tick of the range that still has to be migrated for the data type of the step, or
+// the tick after the range if the step is not selected.
+func (m *Migrator) stepResumeTick(newStore EpochSink, step string, dataType byte, tickRange v1.TickRange) (uint32, error) {
+	if !m.runsStep(step) {
+		return tickRange.End + 1, nil
+	}
+	return resumeTick(newStore, dataType, tickRange)
+}
+
+// resumeTick returns the first tick of the range that still has to be migrated for the given data type.
+func resumeTick(newStore EpochSink, dataType byte, tickRange v1.TickRange) (uint32, error) {
+	lastTick, exists, err := newStore.GetCheckpoint(dataType, tickRange.Start)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return tickRange.Start, nil
+	}
+	return lastTick + 1, nil
+}
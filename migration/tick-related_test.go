@@ -0,0 +1,88 @@
+package migration
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/qubic/archiver-db-migrator/generator"
+	migratorStore "github.com/qubic/archiver-db-migrator/store"
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+	v2 "github.com/qubic/archiver-db-migrator/store/v2"
+	archiverV2Store "github.com/qubic/go-archiver-v2/db"
+)
+
+// TestMigrateQuorumDataResumesFromCheckpoint migrates the quorum data of an epoch whose first tick range was interrupted
+// halfway and whose second tick range is complete. Only the ticks after the checkpoint of the first range are migrated.
+func TestMigrateQuorumDataResumesFromCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	generatedPath := filepath.Join(dir, "generated")
+
+	_, err := generator.Generate(ctx, generatedPath, generator.Options{
+		Seed:               5,
+		FirstEpoch:         158,
+		Epochs:             1,
+		FirstTick:          13752100,
+		IntervalsPerEpoch:  2,
+		TicksPerInterval:   20,
+		IntervalGap:        10,
+		Identities:         10,
+		Computors:          10,
+		QuorumVotesPerTick: 3,
+	})
+	if err != nil {
+		t.Fatalf("generating archive: %v", err)
+	}
+	generatedStore, err := v1.NewArchiverStoreV1(ctx, generatedPath)
+	if err != nil {
+		t.Fatalf("opening generated archive: %v", err)
+	}
+	defer generatedStore.Close()
+
+	epochMetadata := generatedStore.StoreMetadata.Epochs[158]
+	if len(epochMetadata.ProcessedTickRanges) != 2 {
+		t.Fatalf("got %d tick ranges, expected 2", len(epochMetadata.ProcessedTickRanges))
+	}
+	first, second := epochMetadata.ProcessedTickRanges[0], epochMetadata.ProcessedTickRanges[1]
+	interruptedAt := first.Start + 9
+
+	m := NewMigrator(NewV1Source(generatedStore), filepath.Join(dir, "new"), Options{BatchSize: 4, WriteMode: WriteModeBatch})
+	newStore, err := m.openSink(m.newStorePath, 158)
+	if err != nil {
+		t.Fatalf("opening epoch store: %v", err)
+	}
+	defer newStore.Close()
+
+	err = newStore.SetCheckpoint(v2.CheckpointQuorumData, first.Start, interruptedAt)
+	if err != nil {
+		t.Fatalf("setting checkpoint: %v", err)
+	}
+	err = newStore.SetCheckpoint(v2.CheckpointQuorumData, second.Start, second.End)
+	if err != nil {
+		t.Fatalf("setting checkpoint: %v", err)
+	}
+
+	err = m.MigrateQuorumData(ctx, epochMetadata, newStore)
+	if err != nil {
+		t.Fatalf("migrating quorum data: %v", err)
+	}
+
+	for _, tickRange := range epochMetadata.ProcessedTickRanges {
+		for tickNumber := tickRange.Start; tickNumber <= tickRange.End; tickNumber++ {
+			_, exists, err := newStore.GetRecord(migratorStore.AssembleKey(archiverV2Store.QuorumData, tickNumber))
+			if err != nil {
+				t.Fatalf("getting quorum data for tick %d: %v", tickNumber, err)
+			}
+			expected := tickNumber > interruptedAt && tickNumber <= first.End
+			if exists != expected {
+				t.Errorf("quorum data for tick %d exists %v, expected %v", tickNumber, exists, expected)
+			}
+		}
+
+		lastTick, exists, err := newStore.GetCheckpoint(v2.CheckpointQuorumData, tickRange.Start)
+		if err != nil || !exists || lastTick != tickRange.End {
+			t.Errorf("got checkpoint %d, %v for tick range %v, expected %d: %v", lastTick, exists, tickRange, tickRange.End, err)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package migration
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// txIdOverhead approximates the memory a transaction id takes besides its characters, for the string header and the
+// slice it is held in.
+const txIdOverhead = 24
+
+// tickTxIds holds the transaction ids of a tick, in the order of its tick data.
+type tickTxIds struct {
+	tickNumber uint32
+	txIds      []string
+}
+
+// txIdList holds the transaction ids of a tick range in tick order with a bounded amount of memory. Ticks are kept in
+// memory until half of the buffer limit is reached, then appended to a temporary file. Reading the list back yields
+// windows of ticks, each of them at most half of the buffer limit, so together with the ticks still in memory the
+// list never holds more than the limit. A limit of zero or less keeps all ids in memory.
+type txIdList struct {
+	tempDir     string
+	bufferLimit int
+	buffer      []tickTxIds
+	bufferSize  int
+	file        *os.File
+	writer      *bufio.Writer
+}
+
+func newTxIdList(tempDir string, bufferLimit int) *txIdList {
+	return &txIdList{
+		tempDir:     tempDir,
+		bufferLimit: bufferLimit,
+	}
+}
+
+// add appends the transaction ids of a tick. Ticks have to be added in ascending order.
+func (l *txIdList) add(tickNumber uint32, txIds []string) error {
+	l.buffer = append(l.buffer, tickTxIds{tickNumber: tickNumber, txIds: txIds})
+	l.bufferSize += tickTxIdsSize(txIds)
+
+	if l.bufferLimit > 0 && l.bufferSize >= l.bufferLimit/2 {
+		return l.spill()
+	}
+	return nil
+}
+
+func (l *txIdList) spill() error {
+	if l.file == nil {
+		file, err := os.CreateTemp(l.tempDir, ".tx-ids-*.spill")
+		if err != nil {
+			return fmt.Errorf("creating transaction ids spill file: %w", err)
+		}
+		l.file = file
+		l.writer = bufio.NewWriter(file)
+	}
+
+	for _, tick := range l.buffer {
+		err := writeTickTxIds(l.writer, tick)
+		if err != nil {
+			return fmt.Errorf("writing transaction ids spill file %s: %w", l.file.Name(), err)
+		}
+	}
+
+	l.buffer = nil
+	l.bufferSize = 0
+	return nil
+}
+
+// release spills the ticks held in memory, so a list that is kept for later does not hold on to memory. A list without
+// a buffer limit keeps them.
+func (l *txIdList) release() error {
+	if l.bufferLimit <= 0 || len(l.buffer) == 0 {
+		return nil
+	}
+	return l.spill()
+}
+
+// windows calls handle with consecutive windows of the ticks that are not lower than startTick, in ascending order.
+// The window is only valid until handle returns.
+func (l *txIdList) windows(startTick uint32, handle func(window []tickTxIds) error) error {
+	if l.file != nil {
+		err := l.readSpilled(startTick, handle)
+		if err != nil {
+			return err
+		}
+	}
+
+	var window []tickTxIds
+	for _, tick := range l.buffer {
+		if tick.tickNumber >= startTick {
+			window = append(window, tick)
+		}
+	}
+	if len(window) == 0 {
+		return nil
+	}
+	return handle(window)
+}
+
+func (l *txIdList) readSpilled(startTick uint32, handle func(window []tickTxIds) error) error {
+	err := l.writer.Flush()
+	if err != nil {
+		return fmt.Errorf("flushing transaction ids spill file %s: %w", l.file.Name(), err)
+	}
+
+	file, err := os.Open(l.file.Name())
+	if err != nil {
+		return fmt.Errorf("opening transaction ids spill file %s: %w", l.file.Name(), err)
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+
+	var window []tickTxIds
+	windowSize := 0
+	for {
+		tick, ok, err := readTickTxIds(reader)
+		if err != nil {
+			return fmt.Errorf("reading transaction ids spill file %s: %w", l.file.Name(), err)
+		}
+		if !ok {
+			break
+		}
+		if tick.tickNumber < startTick {
+			continue
+		}
+
+		window = append(window, tick)
+		windowSize += tickTxIdsSize(tick.txIds)
+		if windowSize >= l.bufferLimit/2 {
+			err = handle(window)
+			if err != nil {
+				return err
+			}
+			window = nil
+			windowSize = 0
+		}
+	}
+
+	if len(window) == 0 {
+		return nil
+	}
+	return handle(window)
+}
+
+func (l *txIdList) close() error {
+	l.buffer = nil
+	if l.file == nil {
+		return nil
+	}
+	_ = l.file.Close()
+	return os.Remove(l.file.Name())
+}
+
+func tickTxIdsSize(txIds []string) int {
+	size := txIdOverhead
+	for _, txId := range txIds {
+		size += len(txId) + txIdOverhead
+	}
+	return size
+}
+
+func writeTickTxIds(writer *bufio.Writer, tick tickTxIds) error {
+	var header []byte
+	header = binary.AppendUvarint(header, uint64(tick.tickNumber))
+	header = binary.AppendUvarint(header, uint64(len(tick.txIds)))
+	_, err := writer.Write(header)
+	if err != nil {
+		return err
+	}
+
+	for _, txId := range tick.txIds {
+		_, err = writer.Write(binary.AppendUvarint(nil, uint64(len(txId))))
+		if err != nil {
+			return err
+		}
+		_, err = writer.WriteString(txId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readTickTxIds(reader *bufio.Reader) (tickTxIds, bool, error) {
+	tickNumber, err := binary.ReadUvarint(reader)
+	if err != nil {
+		if err == io.EOF {
+			return tickTxIds{}, false, nil
+		}
+		return tickTxIds{}, false, err
+	}
+	count, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return tickTxIds{}, false, err
+	}
+
+	tick := tickTxIds{
+		tickNumber: uint32(tickNumber),
+		txIds:      make([]string, count),
+	}
+	for i := range tick.txIds {
+		length, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return tickTxIds{}, false, err
+		}
+		txId := make([]byte, length)
+		_, err = io.ReadFull(reader, txId)
+		if err != nil {
+			return tickTxIds{}, false, err
+		}
+		tick.txIds[i] = string(txId)
+	}
+	return tick, true, nil
+}
@@ -0,0 +1,114 @@
+package migration
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestTxIdListSpillsAndIterates(t *testing.T) {
+	dir := t.TempDir()
+
+	var ticks []tickTxIds
+	for tickNumber := uint32(100); tickNumber < 140; tickNumber++ {
+		var txIds []string
+		for i := range tickNumber % 4 {
+			txIds = append(txIds, fmt.Sprintf("tx-%d-%d", tickNumber, i))
+		}
+		ticks = append(ticks, tickTxIds{tickNumber: tickNumber, txIds: txIds})
+	}
+
+	// A limit of a few ticks makes the list spill several times and read the spilled ticks in several windows.
+	bufferLimit := 4 * tickTxIdsSize([]string{"tx-100-0", "tx-100-1", "tx-100-2"})
+	list := newTxIdList(dir, bufferLimit)
+	for _, tick := range ticks {
+		err := list.add(tick.tickNumber, tick.txIds)
+		if err != nil {
+			t.Fatalf("adding tick %d: %v", tick.tickNumber, err)
+		}
+	}
+	if list.file == nil {
+		t.Fatalf("list did not spill with a buffer limit of %d", bufferLimit)
+	}
+
+	for _, startTick := range []uint32{0, 100, 117, 139, 140} {
+		var got []tickTxIds
+		windows := 0
+		err := list.windows(startTick, func(window []tickTxIds) error {
+			windows++
+			size := 0
+			for _, tick := range window {
+				size += tickTxIdsSize(tick.txIds)
+			}
+			if len(window) > 1 && size-tickTxIdsSize(window[len(window)-1].txIds) >= bufferLimit/2 {
+				t.Errorf("start tick %d: window of size %d exceeds half of the buffer limit", startTick, size)
+			}
+			got = append(got, window...)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("start tick %d: iterating windows: %v", startTick, err)
+		}
+
+		var expected []tickTxIds
+		for _, tick := range ticks {
+			if tick.tickNumber >= startTick {
+				expected = append(expected, tick)
+			}
+		}
+		if !slices.EqualFunc(got, expected, func(a, b tickTxIds) bool {
+			return a.tickNumber == b.tickNumber && slices.Equal(a.txIds, b.txIds)
+		}) {
+			t.Errorf("start tick %d: got ticks %v, expected %v", startTick, got, expected)
+		}
+		if startTick == 0 && windows < 2 {
+			t.Errorf("spilled ticks were read in %d window", windows)
+		}
+	}
+
+	spillFile := list.file.Name()
+	err := list.close()
+	if err != nil {
+		t.Fatalf("closing list: %v", err)
+	}
+	_, err = os.Stat(spillFile)
+	if !os.IsNotExist(err) {
+		t.Fatalf("spill file %s was not removed: %v", spillFile, err)
+	}
+}
+
+func TestTxIdListReleaseKeepsTicks(t *testing.T) {
+	list := newTxIdList(t.TempDir(), 1<<20)
+	defer list.close()
+
+	err := list.add(1, []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("adding tick: %v", err)
+	}
+	err = list.release()
+	if err != nil {
+		t.Fatalf("releasing list: %v", err)
+	}
+	if len(list.buffer) != 0 || list.file == nil {
+		t.Fatalf("release kept %d ticks in memory", len(list.buffer))
+	}
+	err = list.add(2, []string{"c"})
+	if err != nil {
+		t.Fatalf("adding tick: %v", err)
+	}
+
+	var got []uint32
+	err = list.windows(0, func(window []tickTxIds) error {
+		for _, tick := range window {
+			got = append(got, tick.tickNumber)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("iterating windows: %v", err)
+	}
+	if !slices.Equal(got, []uint32{1, 2}) {
+		t.Fatalf("got ticks %v, expected [1 2]", got)
+	}
+}
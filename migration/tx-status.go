@@ -2,115 +2,460 @@ package migration
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"runtime"
+	"log"
+	"math"
+	"os"
+	"time"
 
-	pebbleV2 "github.com/cockroachdb/pebble/v2"
 	"github.com/golang/protobuf/proto"
 	migratorStore "github.com/qubic/archiver-db-migrator/store"
 	v1 "github.com/qubic/archiver-db-migrator/store/v1"
 	v2 "github.com/qubic/archiver-db-migrator/store/v2"
 	archiverV2Store "github.com/qubic/go-archiver-v2/db"
 	protoV2 "github.com/qubic/go-archiver-v2/protobuf"
+	protoV1 "github.com/qubic/go-archiver/protobuff"
+	archiverV1Store "github.com/qubic/go-archiver/store"
 	"github.com/schollz/progressbar/v3"
 )
 
-func (m *Migrator) migrateTransactionsStatusList(txIdsPerTick map[uint32][]string, newStore *v2.ArchiverEpochStoreV2) error {
+// Policies for transactions whose status is missing in the v1 store.
+const (
+	// MissingStatusAbort fails the epoch. It is the default.
+	MissingStatusAbort = "abort"
+	// MissingStatusSkip migrates the epoch without the status.
+	MissingStatusSkip = "skip"
+	// MissingStatusRebuild takes the status from the v1 tick transactions status record of the tick, and skips it if
+	// that record does not list the transaction either.
+	MissingStatusRebuild = "rebuild"
+)
 
-	bar := progressbar.Default(int64(len(txIdsPerTick)), "Migrating transactions status")
+// Outcomes for missing transaction statuses, listed in the report.
+const (
+	MissingStatusSkipped = "skipped"
+	MissingStatusRebuilt = "rebuilt"
+)
 
-	batch := newStore.ArchiverStore.GetDB().NewBatch()
-	defer batch.Close()
+// Strategies for reading the v1 transaction statuses of an epoch.
+const (
+	// StatusStrategyAuto picks the scan strategy for epochs with at least the status scan threshold of transactions, and
+	// the lookup strategy for smaller ones.
+	StatusStrategyAuto = "auto"
+	// StatusStrategyLookup looks up the status of every transaction id of the tick data.
+	StatusStrategyLookup = "lookup"
+	// StatusStrategyScan reads the v1 tick transactions status records in tick order, and writes the statuses they list.
+	// The transactions of the tick data a record does not list are looked up and handled by the missing status policy.
+	StatusStrategyScan = "scan"
+)
 
-	counter := 0
+// statusScanMinTransactions is the default number of transactions of an epoch from which the auto strategy scans. The
+// threshold that fits an archive is logged by BenchmarkStatusStrategies.
+const statusScanMinTransactions = 10000
 
-	for tickNumber, txs := range txIdsPerTick {
+// statusStrategyFor returns the strategy used for an epoch with txCount transactions to migrate the statuses of.
+func (m *Migrator) statusStrategyFor(txCount int) string {
+	switch m.statusStrategy {
+	case StatusStrategyLookup, StatusStrategyScan:
+		return m.statusStrategy
+	}
+	if txCount >= m.statusScanMin {
+		return StatusStrategyScan
+	}
+	return StatusStrategyLookup
+}
 
-		var ttsV2 protoV2.TickTransactionsStatus
+// migrateTransactionsStatus writes the statuses of the transactions of the ticks from startTick on, and their tick
+// transactions status records. The lookup strategy reads the ticks one window of the transaction id list at a time and
+// writes the statuses in the order of the tick data. The scan strategy copies the v1 tick transactions status records
+// and writes the statuses they list in their order, followed by the ones of the transactions of the list they miss.
+func (m *Migrator) migrateTransactionsStatus(ctx context.Context, tickRange v1.TickRange, startTick uint32, strategy string, txIds *txIdList, newStore EpochSink) error {
 
-		_ = bar.Add(1)
+	bar := progressbar.Default(int64(tickRange.End-startTick)+1, fmt.Sprintf("Migrating transactions status (%s)", strategy))
 
-		for _, txId := range txs {
+	m.rangeStarted(newStore, v2.CheckpointTransactionsStatus, tickRange, startTick)
 
-			txStatusV1, err := m.oldStore.ArchiverStore.GetTransactionStatus(context.Background(), txId)
-			if err != nil {
-				return fmt.Errorf("getting transaction status for tx %s: %w", txId, err)
-			}
+	start := time.Now()
 
-			txStatusV2 := protoV2.TransactionStatus{
-				TxId:      txStatusV1.TxId,
-				MoneyFlew: txStatusV1.MoneyFlew,
-			}
+	writer, err := m.newRangeWriter(newStore, false)
+	if err != nil {
+		return fmt.Errorf("creating writer while migrating transactions status list: %w", err)
+	}
+	defer writer.close()
+
+	statusWriter := tickStatusWriter{
+		m:         m,
+		newStore:  newStore,
+		writer:    writer,
+		tickRange: tickRange,
+		startTick: startTick,
+		bar:       bar,
+	}
 
-			ttsV2.Transactions = append(ttsV2.Transactions, &txStatusV2)
+	if strategy == StatusStrategyScan {
+		err = statusWriter.scan(ctx, txIds)
+	} else {
+		err = txIds.windows(startTick, func(window []tickTxIds) error {
+			return statusWriter.lookup(ctx, window)
+		})
+	}
+	if err != nil {
+		return err
+	}
 
-			data, err := proto.Marshal(&txStatusV2)
+	err = writer.commit(ctx, v2.CheckpointTransactionsStatus, tickRange.Start, tickRange.End, true)
+	if err != nil {
+		return fmt.Errorf("committing final records while migrating transactions status list: %w", err)
+	}
+
+	logThroughput("transaction status", tickRange, statusWriter.records, start)
+	return nil
+}
+
+// tickStatusWriter writes the statuses of a tick range one tick at a time, and commits at tick boundaries.
+type tickStatusWriter struct {
+	m         *Migrator
+	newStore  EpochSink
+	writer    rangeWriter
+	tickRange v1.TickRange
+	startTick uint32
+	bar       *progressbar.ProgressBar
+
+	counter      int
+	records      int
+	tickStatuses tickStatusesV1
+}
+
+// lookup writes the ticks with one status lookup per transaction. Statuses that do not exist are handled by the
+// missing status policy.
+func (w *tickStatusWriter) lookup(ctx context.Context, ticks []tickTxIds) error {
+	for _, tick := range ticks {
+		statuses := make([]*protoV1.TransactionStatus, 0, len(tick.txIds))
+		for _, txId := range tick.txIds {
+			txStatusV1, outcome, err := w.m.getTransactionStatus(ctx, tick.tickNumber, txId, &w.tickStatuses)
 			if err != nil {
-				return fmt.Errorf("marshaling transaction status v2 for tx %s: %w", txId, err)
+				return err
+			}
+			if outcome != "" {
+				w.m.missingStatus(w.newStore, tick.tickNumber, txId, outcome)
 			}
+			if txStatusV1 != nil {
+				statuses = append(statuses, txStatusV1)
+			}
+		}
+
+		err := w.writeTick(ctx, tick.tickNumber, statuses)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scan writes the ticks from the v1 tick transactions status records of the range, the records the v1 archiver also
+// wrote the per transaction statuses from. The records are read alongside the windows of the transaction id list, so
+// the transactions of the tick data a record does not list, or all of them if the tick has no record, are handled by
+// the missing status policy like with lookup.
+func (w *tickStatusWriter) scan(ctx context.Context, txIds *txIdList) error {
+	next := w.startTick
+	err := txIds.windows(w.startTick, func(window []tickTxIds) error {
+		last := window[len(window)-1].tickNumber
+		err := w.scanTicks(ctx, v1.TickRange{Start: next, End: last}, window)
+		if err != nil {
+			return err
+		}
+		next = last + 1
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if next > w.tickRange.End {
+		return nil
+	}
+	return w.scanTicks(ctx, v1.TickRange{Start: next, End: w.tickRange.End}, nil)
+}
 
-			err = batch.Set(migratorStore.AssembleKey(archiverV2Store.TransactionStatus, txId), data, nil)
+// scanTicks writes the ticks of the span from their v1 tick transactions status records, and the ticks of the
+// transaction id list in the span in tick order with them.
+func (w *tickStatusWriter) scanTicks(ctx context.Context, span v1.TickRange, ticks []tickTxIds) error {
+	err := w.m.source.IterateTicks(archiverV1Store.TickTransactionsStatus, span, func(tickNumber uint32, value []byte) error {
+		for len(ticks) > 0 && ticks[0].tickNumber < tickNumber {
+			err := w.writeScannedTick(ctx, ticks[0].tickNumber, nil, ticks[0].txIds)
 			if err != nil {
-				return fmt.Errorf("setting transaction status for tx %s in batch: %w", txId, err)
+				return err
 			}
-			counter++
+			ticks = ticks[1:]
+		}
 
-			if counter >= m.batchSize {
-				err := batch.Commit(pebbleV2.Sync)
-				if err != nil {
-					return fmt.Errorf("committing batch while migrating transactions status list: %w", err)
-				}
+		var ttsV1 protoV1.TickTransactionsStatus
+		err := proto.Unmarshal(value, &ttsV1)
+		if err != nil {
+			return fmt.Errorf("unmarshaling tick transactions status for tick %d: %w", tickNumber, err)
+		}
 
-				batch.Reset()
-				runtime.GC()
-				counter = 0
-			}
+		var txIds []string
+		if len(ticks) > 0 && ticks[0].tickNumber == tickNumber {
+			txIds = ticks[0].txIds
+			ticks = ticks[1:]
 		}
+		return w.writeScannedTick(ctx, tickNumber, ttsV1.Transactions, txIds)
+	})
+	if err != nil {
+		return err
+	}
 
-		data, err := proto.Marshal(&ttsV2)
+	for _, tick := range ticks {
+		err = w.writeScannedTick(ctx, tick.tickNumber, nil, tick.txIds)
 		if err != nil {
-			return fmt.Errorf("marshaling tick transactions status v2 for tick %d: %w", tickNumber, err)
+			return err
 		}
-		err = batch.Set(migratorStore.AssembleKey(archiverV2Store.TickTransactionsStatus, uint64(tickNumber)), data, nil) // uint64 is not a mistake, the db code in archiver v1 and v2 used an uint64 key for some reason
+	}
+	return nil
+}
+
+// writeScannedTick writes the statuses listed by the tick transactions status record of the tick, followed by the ones
+// of the transactions of the tick data that the record does not list, as the missing status policy decides.
+func (w *tickStatusWriter) writeScannedTick(ctx context.Context, tickNumber uint32, listed []*protoV1.TransactionStatus, txIds []string) error {
+	listedIds := make(map[string]struct{}, len(listed))
+	for _, txStatus := range listed {
+		listedIds[txStatus.TxId] = struct{}{}
+	}
+
+	statuses := listed
+	for _, txId := range txIds {
+		if _, ok := listedIds[txId]; ok {
+			continue
+		}
+		txStatusV1, outcome, err := w.m.getTransactionStatus(ctx, tickNumber, txId, &w.tickStatuses)
 		if err != nil {
-			return fmt.Errorf("setting tick transactions status for tick %d in batch: %w", tickNumber, err)
+			return err
+		}
+		if outcome != "" {
+			w.m.missingStatus(w.newStore, tickNumber, txId, outcome)
+		}
+		if txStatusV1 != nil {
+			statuses = append(statuses, txStatusV1)
 		}
 	}
+	return w.writeTick(ctx, tickNumber, statuses)
+}
+
+// writeTick writes the statuses of the tick and its tick transactions status record listing them in the same order.
+// Once enough statuses are set the batch is committed.
+func (w *tickStatusWriter) writeTick(ctx context.Context, tickNumber uint32, statuses []*protoV1.TransactionStatus) error {
+
+	var ttsV2 protoV2.TickTransactionsStatus
+
+	_ = w.bar.Set(int(tickNumber-w.startTick) + 1)
+
+	for _, txStatusV1 := range statuses {
+		w.m.recordsRead(w.newStore, v2.CheckpointTransactionsStatus, 1, proto.Size(txStatusV1))
+
+		txStatusV2 := transactionStatusV1ToV2(txStatusV1)
 
-	err := batch.Commit(pebbleV2.Sync)
+		ttsV2.Transactions = append(ttsV2.Transactions, txStatusV2)
+
+		data, err := proto.Marshal(txStatusV2)
+		if err != nil {
+			return fmt.Errorf("marshaling transaction status v2 for tx %s: %w", txStatusV1.TxId, err)
+		}
+
+		err = w.writer.Set(migratorStore.AssembleKey(archiverV2Store.TransactionStatus, txStatusV1.TxId), data)
+		if err != nil {
+			return fmt.Errorf("setting transaction status for tx %s: %w", txStatusV1.TxId, err)
+		}
+		w.counter++
+		w.records++
+	}
+
+	data, err := proto.Marshal(&ttsV2)
 	if err != nil {
-		return fmt.Errorf("committing final batch while migrating transactions status list: %w", err)
+		return fmt.Errorf("marshaling tick transactions status v2 for tick %d: %w", tickNumber, err)
 	}
+	err = w.writer.Set(migratorStore.AssembleKey(archiverV2Store.TickTransactionsStatus, uint64(tickNumber)), data) // uint64 is not a mistake, the db code in archiver v1 and v2 used an uint64 key for some reason
+	if err != nil {
+		return fmt.Errorf("setting tick transactions status for tick %d: %w", tickNumber, err)
+	}
+	w.records++
+
+	if w.counter >= w.m.batchSize {
+		err = w.writer.commit(ctx, v2.CheckpointTransactionsStatus, w.tickRange.Start, tickNumber, false)
+		if err != nil {
+			return fmt.Errorf("committing while migrating transactions status list: %w", err)
+		}
+		w.counter = 0
 
+		// Stop at the batch boundary once cancelled, the next run resumes from the checkpoint.
+		err = ctx.Err()
+		if err != nil {
+			return fmt.Errorf("stopped after tick %d: %w", tickNumber, err)
+		}
+	}
 	return nil
 }
 
-func (m *Migrator) migrateTransactionsStatusRange(tickRange v1.TickRange, newStore *v2.ArchiverEpochStoreV2) error {
-
-	bar := progressbar.Default(int64(tickRange.End-tickRange.Start), fmt.Sprintf("Migrating transactions status ticks %d to %d", tickRange.Start, tickRange.End))
+// getTransactionStatus returns the v1 status of the transaction in the tick. If the status is missing, the missing
+// status policy decides: with MissingStatusAbort, the default, an error is returned, otherwise the outcome is returned
+// together with the rebuilt status, or with nil if the status is skipped.
+func (m *Migrator) getTransactionStatus(ctx context.Context, tickNumber uint32, txId string, tickStatuses *tickStatusesV1) (*protoV1.TransactionStatus, string, error) {
+	return transactionStatusWithPolicy(ctx, m.source, m.missingStatusPolicy, tickNumber, txId, tickStatuses)
+}
 
-	for tickNumber := tickRange.Start; tickNumber <= tickRange.End; tickNumber++ {
+// transactionStatusWithPolicy reads the status of the transaction from the source and applies the missing status
+// policy like getTransactionStatus, so the verification expects what the migration wrote.
+func transactionStatusWithPolicy(ctx context.Context, source Source, missingStatusPolicy string, tickNumber uint32, txId string, tickStatuses *tickStatusesV1) (*protoV1.TransactionStatus, string, error) {
+	txStatusV1, err := source.GetTransactionStatus(ctx, txId)
+	if err == nil {
+		return txStatusV1, "", nil
+	}
+	if !errors.Is(err, archiverV1Store.ErrNotFound) {
+		return nil, "", fmt.Errorf("getting transaction status for tx %s in tick %d: %w", txId, tickNumber, err)
+	}
 
-		tickTransactionsStatusV1, err := m.oldStore.ArchiverStore.GetTickTransactionsStatus(context.Background(), uint64(tickNumber))
+	switch missingStatusPolicy {
+	case MissingStatusSkip:
+		return nil, MissingStatusSkipped, nil
+	case MissingStatusRebuild:
+		txStatusV1, err = tickStatuses.find(ctx, source, tickNumber, txId)
 		if err != nil {
-			return fmt.Errorf("getting transactions status for tick %d in range %v: %w", tickNumber, tickRange, err)
+			return nil, "", fmt.Errorf("rebuilding transaction status for tx %s in tick %d: %w", txId, tickNumber, err)
 		}
+		if txStatusV1 == nil {
+			return nil, MissingStatusSkipped, nil
+		}
+		return txStatusV1, MissingStatusRebuilt, nil
+	default:
+		return nil, "", fmt.Errorf("getting transaction status for tx %s in tick %d: %w", txId, tickNumber, err)
+	}
+}
 
-		tickTransactionsStatusV2 := protoV2.TickTransactionsStatus{}
+// tickStatusesV1 holds the statuses of the v1 tick transactions status record of the last tick a status was looked up
+// for, so the record is read once per tick.
+type tickStatusesV1 struct {
+	tickNumber uint32
+	statuses   map[string]*protoV1.TransactionStatus
+}
 
-		for _, transactionStatus := range tickTransactionsStatusV1.Transactions {
-			tickTransactionsStatusV2.Transactions = append(tickTransactionsStatusV2.Transactions, &protoV2.TransactionStatus{
-				TxId:      transactionStatus.TxId,
-				MoneyFlew: transactionStatus.MoneyFlew,
-			})
+// find returns the status of the transaction from the tick transactions status record of the tick, or nil if the
+// record is missing or does not list the transaction.
+func (t *tickStatusesV1) find(ctx context.Context, source Source, tickNumber uint32, txId string) (*protoV1.TransactionStatus, error) {
+	if t.statuses == nil || t.tickNumber != tickNumber {
+		tts, err := source.GetTickTransactionsStatus(ctx, uint64(tickNumber))
+		if err != nil && !errors.Is(err, archiverV1Store.ErrNotFound) {
+			return nil, fmt.Errorf("getting tick transactions status for tick %d: %w", tickNumber, err)
 		}
 
-		err = newStore.ArchiverStore.SetTickTransactionsStatus(context.Background(), uint64(tickNumber), &tickTransactionsStatusV2)
+		t.tickNumber = tickNumber
+		t.statuses = make(map[string]*protoV1.TransactionStatus, len(tts.GetTransactions()))
+		for _, txStatus := range tts.GetTransactions() {
+			t.statuses[txStatus.TxId] = txStatus
+		}
+	}
+	return t.statuses[txId], nil
+}
+
+// BenchmarkStatusStrategies migrates the epoch with both status strategies into temporary stores and compares the time
+// spent on the transaction statuses, then derives the status scan threshold from the timings. The scan runs first, so
+// the v1 blocks it leaves in the cache can only make the lookups faster.
+func (m *Migrator) BenchmarkStatusStrategies(ctx context.Context, epoch uint32) error {
+	err := os.MkdirAll(m.newStorePath, 0755)
+	if err != nil {
+		return fmt.Errorf("creating store directory: %w", err)
+	}
+
+	strategies := []string{StatusStrategyScan, StatusStrategyLookup}
+	durations := make(map[string]time.Duration)
+	var statuses uint64
+
+	for _, strategy := range strategies {
+		dir, err := os.MkdirTemp(m.newStorePath, ".benchmark-"+strategy+"-*")
+		if err != nil {
+			return fmt.Errorf("creating temporary store directory for status strategy %s: %w", strategy, err)
+		}
+
+		benchmarkMigrator := *m
+		benchmarkMigrator.newStorePath = dir
+		benchmarkMigrator.statusStrategy = strategy
+		benchmarkMigrator.reporter = NewReporter(nil)
+		benchmarkMigrator.metrics = nil
+
+		log.Printf("Migrating epoch %d with status strategy %s\n", epoch, strategy)
+
+		err = benchmarkMigrator.MigrateEpoch(ctx, epoch)
+
+		removeErr := os.RemoveAll(dir)
 		if err != nil {
-			return fmt.Errorf("setting transactions status for tick %d in range %v: %w", tickNumber, tickRange, err)
+			return fmt.Errorf("migrating epoch %d with status strategy %s: %w", epoch, strategy, err)
+		}
+		if removeErr != nil {
+			return fmt.Errorf("removing temporary store directory %s: %w", dir, removeErr)
 		}
 
-		_ = bar.Add(1)
+		for _, epochReport := range benchmarkMigrator.reporter.Report().Epochs {
+			if report, exists := epochReport.DataTypes[DataTypeTransactionStatus]; exists {
+				durations[strategy] = time.Duration(report.DurationMs) * time.Millisecond
+				statuses = report.RecordsRead
+			}
+		}
+	}
+
+	log.Printf("Status strategy benchmark for epoch %d with %d statuses:\n", epoch, statuses)
+	for _, strategy := range strategies {
+		log.Printf("  - %s: %s\n", strategy, durations[strategy])
+	}
+	if durations[StatusStrategyScan] > 0 {
+		log.Printf("  - scan speedup: %.2fx\n", durations[StatusStrategyLookup].Seconds()/durations[StatusStrategyScan].Seconds())
+	}
+
+	scanOverhead, err := m.statusScanOverhead(ctx, epoch)
+	if err != nil {
+		return err
+	}
+	log.Printf("  - iterating the tick transactions status records: %s\n", scanOverhead)
+
+	threshold, ok := statusScanThreshold(durations[StatusStrategyScan], durations[StatusStrategyLookup], scanOverhead, statuses)
+	if !ok {
+		log.Println("  - the lookups are faster per status, use --status-strategy lookup for epochs of this length")
+		return nil
 	}
+	log.Printf("  - the scan pays off from about %d transactions per epoch of this length, use --status-scan-min-transactions %d\n", threshold, threshold)
 	return nil
 }
+
+// statusScanOverhead returns the time it takes to iterate the v1 tick transactions status records of the epoch without
+// migrating them, the part of the scan that does not depend on the number of transactions.
+func (m *Migrator) statusScanOverhead(ctx context.Context, epoch uint32) (time.Duration, error) {
+	start := time.Now()
+	for _, tickRange := range m.source.Metadata().Epochs[epoch].ProcessedTickRanges {
+		err := m.source.IterateTicks(archiverV1Store.TickTransactionsStatus, tickRange, func(uint32, []byte) error {
+			return ctx.Err()
+		})
+		if err != nil {
+			return 0, fmt.Errorf("iterating tick transactions status records of tick range %v: %w", tickRange, err)
+		}
+	}
+	return time.Since(start), nil
+}
+
+// statusScanThreshold derives the number of transactions from which the scan is faster than the lookups, for epochs with
+// as many ticks as the benchmarked one. The scan costs the iteration of the records plus a share per status, the lookups
+// only a share per status. It returns false if the scan costs more per status than a lookup.
+func statusScanThreshold(scan, lookup, scanOverhead time.Duration, statuses uint64) (int, bool) {
+	if statuses == 0 {
+		return 0, false
+	}
+	scanPerStatus := float64(max(scan-scanOverhead, 0)) / float64(statuses)
+	lookupPerStatus := float64(lookup) / float64(statuses)
+	if lookupPerStatus <= scanPerStatus {
+		return 0, false
+	}
+	return int(math.Ceil(float64(scanOverhead) / (lookupPerStatus - scanPerStatus))), true
+}
+
+func transactionStatusV1ToV2(txStatusV1 *protoV1.TransactionStatus) *protoV2.TransactionStatus {
+	return &protoV2.TransactionStatus{
+		TxId:      txStatusV1.TxId,
+		MoneyFlew: txStatusV1.MoneyFlew,
+	}
+}
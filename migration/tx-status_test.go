@@ -0,0 +1,118 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/qubic/archiver-db-migrator/generator"
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+	archiverV1Store "github.com/qubic/go-archiver/store"
+)
+
+func TestStatusScanThreshold(t *testing.T) {
+	// 1000 statuses: the lookups take 2ms each, the scan 1s for the records plus 1ms per status, so it pays off from
+	// 1000 transactions on.
+	threshold, ok := statusScanThreshold(2*time.Second, 2*time.Second, time.Second, 1000)
+	if !ok || threshold != 1000 {
+		t.Fatalf("got threshold %d, %v, expected 1000", threshold, ok)
+	}
+
+	_, ok = statusScanThreshold(3*time.Second, time.Second, time.Second, 1000)
+	if ok {
+		t.Fatal("expected no threshold when the scan costs more per status than a lookup")
+	}
+
+	_, ok = statusScanThreshold(time.Second, time.Second, 0, 0)
+	if ok {
+		t.Fatal("expected no threshold without statuses")
+	}
+}
+
+func TestStatusStrategyFor(t *testing.T) {
+	m := NewMigrator(nil, "", Options{StatusStrategy: StatusStrategyAuto, StatusScanMinTransactions: 500})
+	if strategy := m.statusStrategyFor(499); strategy != StatusStrategyLookup {
+		t.Fatalf("got strategy %s below the threshold", strategy)
+	}
+	if strategy := m.statusStrategyFor(500); strategy != StatusStrategyScan {
+		t.Fatalf("got strategy %s at the threshold", strategy)
+	}
+
+	m = NewMigrator(nil, "", Options{StatusStrategy: StatusStrategyLookup, StatusScanMinTransactions: 500})
+	if strategy := m.statusStrategyFor(1000); strategy != StatusStrategyLookup {
+		t.Fatalf("got strategy %s with the lookup strategy selected", strategy)
+	}
+}
+
+// TestScanStatusStrategyMissingStatus migrates the statuses of an archive whose tick transactions status records miss
+// some transactions of the tick data with the scan strategy. The missing statuses go through the missing status policy.
+func TestScanStatusStrategyMissingStatus(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	generatedPath := filepath.Join(dir, "generated")
+
+	summary, err := generator.Generate(ctx, generatedPath, generator.Options{
+		Seed:                7,
+		FirstEpoch:          158,
+		Epochs:              1,
+		FirstTick:           13752100,
+		IntervalsPerEpoch:   1,
+		TicksPerInterval:    30,
+		TransactionsPerTick: 3,
+		MissingStatusRatio:  0.3,
+		Identities:          10,
+		Computors:           10,
+	})
+	if err != nil {
+		t.Fatalf("generating archive: %v", err)
+	}
+	missing := summary.Transactions - summary.Statuses
+	if missing == 0 {
+		t.Fatal("generated archive has no missing statuses")
+	}
+
+	generatedStore, err := v1.NewArchiverStoreV1(ctx, generatedPath)
+	if err != nil {
+		t.Fatalf("opening generated archive: %v", err)
+	}
+	defer generatedStore.Close()
+	epochMetadata := generatedStore.StoreMetadata.Epochs[158]
+
+	migrate := func(name, missingStatus string, reporter *Reporter) error {
+		m := NewMigrator(NewV1Source(generatedStore), filepath.Join(dir, name), Options{
+			BatchSize:      10,
+			WriteMode:      WriteModeBatch,
+			MissingStatus:  missingStatus,
+			StatusStrategy: StatusStrategyScan,
+			Reporter:       reporter,
+		})
+		newStore, err := m.openSink(m.newStorePath, 158)
+		if err != nil {
+			t.Fatalf("opening epoch store: %v", err)
+		}
+		defer newStore.Close()
+		return m.MigrateTickData(ctx, epochMetadata, newStore)
+	}
+
+	err = migrate("abort", MissingStatusAbort, nil)
+	if !errors.Is(err, archiverV1Store.ErrNotFound) {
+		t.Fatalf("got error %v for a missing status with the abort policy, expected %v", err, archiverV1Store.ErrNotFound)
+	}
+
+	reporter := NewReporter(nil)
+	err = migrate("skip", MissingStatusSkip, reporter)
+	if err != nil {
+		t.Fatalf("migrating with the skip policy: %v", err)
+	}
+	report := reporter.Report()
+	if len(report.Epochs) != 1 || len(report.Epochs[0].MissingStatuses) != missing {
+		t.Fatalf("got report %+v, expected %d missing statuses", report.Epochs, missing)
+	}
+	for _, missingStatus := range report.Epochs[0].MissingStatuses {
+		if missingStatus.Outcome != MissingStatusSkipped {
+			t.Errorf("got outcome %s for tx %s, expected %s", missingStatus.Outcome, missingStatus.TxId, MissingStatusSkipped)
+		}
+	}
+}
@@ -3,115 +3,101 @@ package migration
 import (
 	"context"
 	"fmt"
-	"runtime"
+	"time"
 
-	pebbleV2 "github.com/cockroachdb/pebble/v2"
 	"github.com/golang/protobuf/proto"
 	migratorStore "github.com/qubic/archiver-db-migrator/store"
 	v1 "github.com/qubic/archiver-db-migrator/store/v1"
 	v2 "github.com/qubic/archiver-db-migrator/store/v2"
 	archiverV2Store "github.com/qubic/go-archiver-v2/db"
 	protoV2 "github.com/qubic/go-archiver-v2/protobuf"
+	protoV1 "github.com/qubic/go-archiver/protobuff"
 	"github.com/schollz/progressbar/v3"
 )
 
-func (m *Migrator) migrateTransactionsList(txIdsPerTick map[uint32][]string, txCount int, newStore *v2.ArchiverEpochStoreV2) error {
+func (m *Migrator) migrateTransactionsList(ctx context.Context, tickRange v1.TickRange, startTick uint32, txIds *txIdList, txCount int, newStore EpochSink) error {
 
 	bar := progressbar.Default(int64(txCount), "Migrating transactions list")
 
-	batch := newStore.ArchiverStore.GetDB().NewBatch()
-	defer batch.Close()
+	m.rangeStarted(newStore, v2.CheckpointTransactions, tickRange, startTick)
 
-	counter := 0
+	start := time.Now()
 
-	for _, txList := range txIdsPerTick {
+	writer, err := m.newRangeWriter(newStore, false)
+	if err != nil {
+		return fmt.Errorf("creating writer: %w", err)
+	}
+	defer writer.close()
 
-		for _, txId := range txList {
-			_ = bar.Add(1)
+	counter := 0
+	records := 0
 
-			txV1, err := m.oldStore.ArchiverStore.GetTransaction(context.Background(), txId)
-			if err != nil {
-				return fmt.Errorf("getting transaction %s: %w", txId, err)
-			}
+	// Ticks are processed in order and batches are only committed at tick boundaries, so the checkpoint always points
+	// at a tick whose transactions are fully written.
+	err = txIds.windows(startTick, func(window []tickTxIds) error {
+		for _, tick := range window {
 
-			txV2 := protoV2.Transaction{
-				SourceId:     txV1.SourceId,
-				DestId:       txV1.DestId,
-				Amount:       txV1.Amount,
-				TickNumber:   txV1.TickNumber,
-				InputType:    txV1.InputType,
-				InputSize:    txV1.InputSize,
-				InputHex:     txV1.InputHex,
-				SignatureHex: txV1.SignatureHex,
-				TxId:         txV1.TxId,
-			}
+			for _, txId := range tick.txIds {
+				_ = bar.Add(1)
 
-			data, err := proto.Marshal(&txV2)
-			if err != nil {
-				return fmt.Errorf("marshaling transaction v2 %s: %w", txId, err)
-			}
+				txV1, err := m.source.GetTransaction(ctx, txId)
+				if err != nil {
+					return fmt.Errorf("getting transaction %s: %w", txId, err)
+				}
+				m.recordsRead(newStore, v2.CheckpointTransactions, 1, proto.Size(txV1))
+
+				data, err := proto.Marshal(transactionV1ToV2(txV1))
+				if err != nil {
+					return fmt.Errorf("marshaling transaction v2 %s: %w", txId, err)
+				}
 
-			err = batch.Set(migratorStore.AssembleKey(archiverV2Store.Transaction, txId), data, nil)
-			if err != nil {
-				return fmt.Errorf("setting transaction %s in batch: %w", txId, err)
+				err = writer.Set(migratorStore.AssembleKey(archiverV2Store.Transaction, txId), data)
+				if err != nil {
+					return fmt.Errorf("setting transaction %s: %w", txId, err)
+				}
+				counter++
+				records++
 			}
-			counter++
 
 			if counter >= m.batchSize {
-				err = batch.Commit(pebbleV2.Sync)
+				err := writer.commit(ctx, v2.CheckpointTransactions, tickRange.Start, tick.tickNumber, false)
 				if err != nil {
-					return fmt.Errorf("committing batch: %w", err)
+					return fmt.Errorf("committing transactions: %w", err)
 				}
-
-				batch.Reset()
-				runtime.GC()
 				counter = 0
+
+				// Stop at the batch boundary once cancelled, the next run resumes from the checkpoint.
+				err = ctx.Err()
+				if err != nil {
+					return fmt.Errorf("stopped after tick %d: %w", tick.tickNumber, err)
+				}
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	err := batch.Commit(pebbleV2.Sync)
+	err = writer.commit(ctx, v2.CheckpointTransactions, tickRange.Start, tickRange.End, true)
 	if err != nil {
-		return fmt.Errorf("committing final batch: %w", err)
+		return fmt.Errorf("committing final transactions: %w", err)
 	}
+
+	logThroughput("transaction", tickRange, records, start)
 	return nil
 }
 
-func (m *Migrator) migrateTransactionsRange(tickRange v1.TickRange, newStore *v2.ArchiverEpochStoreV2) error {
-
-	bar := progressbar.Default(int64(tickRange.End-tickRange.Start), fmt.Sprintf("Migrating transactions ticks %d to %d", tickRange.Start, tickRange.End))
-
-	for tickNumber := tickRange.Start; tickNumber <= tickRange.End; tickNumber++ {
-
-		tickTransactionsV1, err := m.oldStore.ArchiverStore.GetTickTransactions(context.Background(), tickNumber)
-		if err != nil {
-			return fmt.Errorf("getting transactions for tick %d in range %v: %w", tickNumber, tickRange, err)
-		}
-
-		var tickTransactionsV2 []*protoV2.Transaction
-
-		for _, transaction := range tickTransactionsV1 {
-			tickTransactionsV2 = append(tickTransactionsV2, &protoV2.Transaction{
-				SourceId:     transaction.SourceId,
-				DestId:       transaction.DestId,
-				Amount:       transaction.Amount,
-				TickNumber:   transaction.TickNumber,
-				InputType:    transaction.InputType,
-				InputSize:    transaction.InputSize,
-				InputHex:     transaction.InputHex,
-				SignatureHex: transaction.SignatureHex,
-				TxId:         transaction.TxId,
-			})
-		}
-
-		err = newStore.ArchiverStore.SetTransactions(context.Background(), tickTransactionsV2)
-		if err != nil {
-			return fmt.Errorf("setting transactions for tick %d in range %v: %w", tickNumber, tickRange, err)
-		}
-
-		_ = bar.Add(1)
-
+func transactionV1ToV2(txV1 *protoV1.Transaction) *protoV2.Transaction {
+	return &protoV2.Transaction{
+		SourceId:     txV1.SourceId,
+		DestId:       txV1.DestId,
+		Amount:       txV1.Amount,
+		TickNumber:   txV1.TickNumber,
+		InputType:    txV1.InputType,
+		InputSize:    txV1.InputSize,
+		InputHex:     txV1.InputHex,
+		SignatureHex: txV1.SignatureHex,
+		TxId:         txV1.TxId,
 	}
-
-	return nil
 }
@@ -0,0 +1,917 @@
+package migration
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"iter"
+	"log"
+	"slices"
+
+	pebbleV1 "github.com/cockroachdb/pebble"
+	"github.com/golang/protobuf/proto"
+	migratorStore "github.com/qubic/archiver-db-migrator/store"
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+	v2 "github.com/qubic/archiver-db-migrator/store/v2"
+	archiverV2Store "github.com/qubic/go-archiver-v2/db"
+	protoV2 "github.com/qubic/go-archiver-v2/protobuf"
+	protoV1 "github.com/qubic/go-archiver/protobuff"
+	archiverV1Store "github.com/qubic/go-archiver/store"
+	"github.com/schollz/progressbar/v3"
+)
+
+var ErrVerificationFailed = errors.New("verification found mismatches between v1 and v2")
+
+const (
+	DataTypeEpochStore                          = "EpochStore"
+	DataTypeTickData                            = "TickData"
+	DataTypeQuorumData                          = "QuorumData"
+	DataTypeTransaction                         = "Transaction"
+	DataTypeTransactionStatus                   = "TransactionStatus"
+	DataTypeTickTransactionsStatus              = "TickTransactionsStatus"
+	DataTypeComputorList                        = "ComputorList"
+	DataTypeProcessedTickIntervals              = "ProcessedTickIntervals"
+	DataTypeLastProcessedTick                   = "LastProcessedTick"
+	DataTypeLastTickQuorumDataPerEpochIntervals = "LastTickQuorumDataPerEpochIntervals"
+	DataTypeTargetTickVoteSignature             = "TargetTickVoteSignature"
+	DataTypeEmptyTicksPerEpoch                  = "EmptyTicksPerEpoch"
+	DataTypeSkippedTicksIntervals               = "SkippedTicksIntervals"
+	DataTypeChainDigest                         = "ChainDigest"
+	DataTypeStoreDigest                         = "StoreDigest"
+	DataTypeRecomputedChainDigest               = "RecomputedChainDigest"
+	DataTypeRecomputedStoreDigest               = "RecomputedStoreDigest"
+	DataTypeIdentityTransfers                   = "IdentityTransfers"
+)
+
+var verifiedDataTypes = []string{
+	DataTypeEpochStore,
+	DataTypeTickData,
+	DataTypeQuorumData,
+	DataTypeTransaction,
+	DataTypeTransactionStatus,
+	DataTypeTickTransactionsStatus,
+	DataTypeComputorList,
+	DataTypeProcessedTickIntervals,
+	DataTypeLastProcessedTick,
+	DataTypeLastTickQuorumDataPerEpochIntervals,
+	DataTypeTargetTickVoteSignature,
+	DataTypeEmptyTicksPerEpoch,
+	DataTypeSkippedTicksIntervals,
+	DataTypeChainDigest,
+	DataTypeStoreDigest,
+	DataTypeRecomputedChainDigest,
+	DataTypeRecomputedStoreDigest,
+	DataTypeIdentityTransfers,
+}
+
+type MismatchKind string
+
+const (
+	MismatchMissing   MismatchKind = "missing"
+	MismatchExtra     MismatchKind = "extra"
+	MismatchDifferent MismatchKind = "different"
+)
+
+type Mismatch struct {
+	Epoch    uint32
+	DataType string
+	Key      string
+	Kind     MismatchKind
+	Details  string
+}
+
+type EpochVerification struct {
+	Epoch      uint32
+	Checked    map[string]int
+	Mismatches []Mismatch
+}
+
+func (ev *EpochVerification) addMismatch(dataType, key string, kind MismatchKind, details string) {
+	ev.Mismatches = append(ev.Mismatches, Mismatch{
+		Epoch:    ev.Epoch,
+		DataType: dataType,
+		Key:      key,
+		Kind:     kind,
+		Details:  details,
+	})
+}
+
+func (ev *EpochVerification) countMismatches(dataType string) int {
+	count := 0
+	for _, mismatch := range ev.Mismatches {
+		if mismatch.DataType == dataType {
+			count++
+		}
+	}
+	return count
+}
+
+// Verifier checks that the v2 epoch stores hold exactly what the source holds, after applying the same v1 to v2
+// mapping that the migration uses.
+type Verifier struct {
+	source              Source
+	openSink            OpenEpochSink
+	newStorePath        string
+	recomputeDigests    bool
+	missingStatusPolicy string
+	txIdBufferSize      int
+	sortBufferSize      int
+}
+
+type VerifierOptions struct {
+	// RecomputeDigests additionally recomputes the chain and store digests from the migrated tick data, quorum data,
+	// transactions and statuses, and compares them with the migrated digests.
+	RecomputeDigests bool
+	// MissingStatus is the missing status policy the epochs were migrated with. Statuses that the policy skipped are
+	// not expected in the epoch stores, rebuilt ones are expected as rebuilt. Empty is MissingStatusAbort.
+	MissingStatus string
+	// TxIdBufferSize is the number of bytes of transaction ids of a tick range held in memory, the rest is spilled to
+	// a temporary file. Zero keeps all of them in memory.
+	TxIdBufferSize int
+	// SortBufferSize is the number of bytes of expected transaction ids sorted in memory before spilling to a temporary
+	// file. They are used to find the transactions and statuses of the epoch store that the source does not have.
+	SortBufferSize int
+	// OpenSink opens the epoch stores that are verified. It must not create missing stores. Nil opens existing v2
+	// epoch stores.
+	OpenSink OpenEpochSink
+}
+
+func NewVerifier(source Source, newStorePath string, options VerifierOptions) *Verifier {
+	openSink := options.OpenSink
+	if openSink == nil {
+		openSink = OpenExistingV2EpochSink
+	}
+
+	return &Verifier{
+		source:              source,
+		openSink:            openSink,
+		newStorePath:        newStorePath,
+		recomputeDigests:    options.RecomputeDigests,
+		missingStatusPolicy: options.MissingStatus,
+		txIdBufferSize:      options.TxIdBufferSize,
+		sortBufferSize:      options.SortBufferSize,
+	}
+}
+
+func (v *Verifier) VerifyEpochs(ctx context.Context, epochs []uint32) ([]*EpochVerification, error) {
+	var results []*EpochVerification
+	for _, epoch := range epochs {
+		err := ctx.Err()
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := v.VerifyEpoch(ctx, epoch)
+		if err != nil {
+			return nil, fmt.Errorf("verifying epoch %d: %w", epoch, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (v *Verifier) VerifyEpoch(ctx context.Context, epoch uint32) (*EpochVerification, error) {
+	epochMetadata, exists := v.source.Metadata().Epochs[epoch]
+	if !exists {
+		return nil, fmt.Errorf("epoch %d metadata not found", epoch)
+	}
+
+	ev := &EpochVerification{
+		Epoch:   epoch,
+		Checked: make(map[string]int),
+	}
+
+	ev.Checked[DataTypeEpochStore]++
+	newStore, err := v.openSink(v.newStorePath, epoch)
+	if errors.Is(err, v2.ErrEpochStoreNotFound) {
+		ev.addMismatch(DataTypeEpochStore, fmt.Sprint(epoch), MismatchMissing, "missing target")
+		return ev, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening epoch store v2 for epoch %d: %w", epoch, err)
+	}
+	defer newStore.Close()
+
+	log.Printf("Verifying epoch %d\n", epoch)
+
+	err = v.verifyEpochMetadata(ctx, ev, epochMetadata, newStore)
+	if err != nil {
+		return nil, fmt.Errorf("verifying metadata: %w", err)
+	}
+
+	// The ids of the transactions and statuses the epoch store must hold, to find the ones it holds in addition.
+	expectedTransactions, err := newExternalSorter("", v.sortBufferSize)
+	if err != nil {
+		return nil, fmt.Errorf("creating sorter for expected transactions: %w", err)
+	}
+	defer expectedTransactions.close()
+	expectedStatuses, err := newExternalSorter("", v.sortBufferSize)
+	if err != nil {
+		return nil, fmt.Errorf("creating sorter for expected statuses: %w", err)
+	}
+	defer expectedStatuses.close()
+
+	for _, tickRange := range epochMetadata.ProcessedTickRanges {
+		err = v.verifyTickRange(ctx, ev, tickRange, expectedTransactions, expectedStatuses, newStore)
+		if err != nil {
+			return nil, fmt.Errorf("verifying tick range %v: %w", tickRange, err)
+		}
+	}
+
+	err = v.verifyOutOfRangeTicks(ctx, ev, epochMetadata.ProcessedTickRanges, newStore)
+	if err != nil {
+		return nil, fmt.Errorf("verifying ticks outside of processed ranges: %w", err)
+	}
+
+	err = verifyNoExtraIds(ev, DataTypeTransaction, archiverV2Store.Transaction, expectedTransactions, newStore)
+	if err != nil {
+		return nil, fmt.Errorf("verifying transactions: %w", err)
+	}
+
+	err = verifyNoExtraIds(ev, DataTypeTransactionStatus, archiverV2Store.TransactionStatus, expectedStatuses, newStore)
+	if err != nil {
+		return nil, fmt.Errorf("verifying transactions status: %w", err)
+	}
+
+	err = v.verifyIdentityTransfers(ev, epochMetadata.ProcessedTickRanges, newStore)
+	if err != nil {
+		return nil, fmt.Errorf("verifying identity transfers: %w", err)
+	}
+
+	if v.recomputeDigests {
+		for index, tickRange := range epochMetadata.ProcessedTickRanges {
+			err = v.recomputeDigestsRange(ctx, ev, tickRange, index == 0, newStore)
+			if err != nil {
+				return nil, fmt.Errorf("recomputing digests range %v: %w", tickRange, err)
+			}
+		}
+	}
+
+	return ev, nil
+}
+
+func (v *Verifier) verifyEpochMetadata(ctx context.Context, ev *EpochVerification, epochMetadata v1.EpochMetadata, newStore EpochSink) error {
+	epoch := epochMetadata.Epoch
+
+	computorsV1, err := v.source.GetComputors(ctx, epoch)
+	if err != nil {
+		return fmt.Errorf("getting v1 computors: %w", err)
+	}
+	ev.Checked[DataTypeComputorList]++
+	computorsV2, err := newStore.GetComputors(ctx, epoch)
+	if err != nil {
+		if !errors.Is(err, archiverV2Store.ErrNotFound) {
+			return fmt.Errorf("getting v2 computors: %w", err)
+		}
+		ev.addMismatch(DataTypeComputorList, fmt.Sprint(epoch), MismatchMissing, "")
+	} else if !proto.Equal(computorsV1ToV2(computorsV1), computorsV2) {
+		ev.addMismatch(DataTypeComputorList, fmt.Sprint(epoch), MismatchDifferent, "")
+	}
+
+	intervalsV1, err := v.source.GetProcessedTickIntervals(ctx)
+	if err != nil {
+		return fmt.Errorf("getting v1 processed tick intervals: %w", err)
+	}
+	ev.Checked[DataTypeProcessedTickIntervals]++
+	intervalsV2, err := newStore.GetProcessedTickIntervals(ctx)
+	if err != nil {
+		return fmt.Errorf("getting v2 processed tick intervals: %w", err)
+	}
+	expectedIntervals := processedTickIntervalsV1ToV2(epoch, intervalsV1)
+	var foundIntervals *protoV2.ProcessedTickIntervalsPerEpoch
+	for _, intervals := range intervalsV2 {
+		if intervals.Epoch == epoch {
+			foundIntervals = intervals
+			continue
+		}
+		ev.addMismatch(DataTypeProcessedTickIntervals, fmt.Sprint(intervals.Epoch), MismatchExtra, "")
+	}
+	if foundIntervals == nil {
+		ev.addMismatch(DataTypeProcessedTickIntervals, fmt.Sprint(epoch), MismatchMissing, "")
+	} else if !proto.Equal(expectedIntervals, foundIntervals) {
+		ev.addMismatch(DataTypeProcessedTickIntervals, fmt.Sprint(epoch), MismatchDifferent, "")
+	}
+
+	ev.Checked[DataTypeLastProcessedTick]++
+	lastProcessedTickV2, err := newStore.GetLastProcessedTick(ctx)
+	if err != nil {
+		if !errors.Is(err, archiverV2Store.ErrNotFound) {
+			return fmt.Errorf("getting v2 last processed tick: %w", err)
+		}
+		ev.addMismatch(DataTypeLastProcessedTick, fmt.Sprint(epoch), MismatchMissing, "")
+	} else if lastProcessedTickV2.TickNumber != epochMetadata.LastProcessedTick || lastProcessedTickV2.Epoch != epoch {
+		ev.addMismatch(DataTypeLastProcessedTick, fmt.Sprint(epoch), MismatchDifferent,
+			fmt.Sprintf("v1 tick %d, v2 tick %d in epoch %d", epochMetadata.LastProcessedTick, lastProcessedTickV2.TickNumber, lastProcessedTickV2.Epoch))
+	}
+
+	lastTickQuorumDataV1, err := v.source.GetLastTickQuorumDataListPerEpochInterval(epoch)
+	if err != nil {
+		return fmt.Errorf("getting v1 last tick quorum data: %w", err)
+	}
+	ev.Checked[DataTypeLastTickQuorumDataPerEpochIntervals]++
+	lastTickQuorumDataV2, err := newStore.GetLastTickQuorumDataListPerEpochInterval(epoch)
+	if err != nil {
+		if !errors.Is(err, archiverV2Store.ErrNotFound) {
+			return fmt.Errorf("getting v2 last tick quorum data: %w", err)
+		}
+		ev.addMismatch(DataTypeLastTickQuorumDataPerEpochIntervals, fmt.Sprint(epoch), MismatchMissing, "")
+	} else if !proto.Equal(lastTickQuorumDataV1ToV2(lastTickQuorumDataV1), lastTickQuorumDataV2) {
+		ev.addMismatch(DataTypeLastTickQuorumDataPerEpochIntervals, fmt.Sprint(epoch), MismatchDifferent, "")
+	}
+
+	emptyTicksV1, err := v.source.GetEmptyTicksForEpoch(epoch)
+	if err != nil && !errors.Is(err, pebbleV1.ErrNotFound) {
+		return fmt.Errorf("getting v1 empty ticks count: %w", err)
+	}
+	if err == nil {
+		ev.Checked[DataTypeEmptyTicksPerEpoch]++
+		emptyTicksV2, exists, err := newStore.GetEmptyTicksCount(epoch)
+		if err != nil {
+			return fmt.Errorf("getting v2 empty ticks count: %w", err)
+		}
+		if !exists {
+			ev.addMismatch(DataTypeEmptyTicksPerEpoch, fmt.Sprint(epoch), MismatchMissing, "")
+		} else if emptyTicksV1 != emptyTicksV2 {
+			ev.addMismatch(DataTypeEmptyTicksPerEpoch, fmt.Sprint(epoch), MismatchDifferent, fmt.Sprintf("v1 %d, v2 %d", emptyTicksV1, emptyTicksV2))
+		}
+	}
+
+	skippedV1, err := epochSkippedTicksIntervals(ctx, v.source, epoch)
+	if err != nil {
+		return fmt.Errorf("getting v1 skipped ticks intervals: %w", err)
+	}
+	skippedV2, err := newStore.GetSkippedTicksIntervals()
+	if err != nil {
+		return fmt.Errorf("getting v2 skipped ticks intervals: %w", err)
+	}
+	for _, interval := range skippedV1 {
+		ev.Checked[DataTypeSkippedTicksIntervals]++
+		if !slices.Contains(skippedV2, interval) {
+			ev.addMismatch(DataTypeSkippedTicksIntervals, fmt.Sprintf("%d-%d", interval.StartTick, interval.EndTick), MismatchMissing, "")
+		}
+	}
+	for _, interval := range skippedV2 {
+		if !slices.Contains(skippedV1, interval) {
+			ev.addMismatch(DataTypeSkippedTicksIntervals, fmt.Sprintf("%d-%d", interval.StartTick, interval.EndTick), MismatchExtra, "")
+		}
+	}
+
+	if epoch > 158 {
+		signatureV1, err := v.source.GetTargetTickVoteSignature(epoch)
+		if err != nil {
+			return fmt.Errorf("getting v1 target tick vote signature: %w", err)
+		}
+		ev.Checked[DataTypeTargetTickVoteSignature]++
+		signatureV2, err := newStore.GetTargetTickVoteSignature(epoch)
+		if err != nil {
+			if !errors.Is(err, archiverV2Store.ErrNotFound) {
+				return fmt.Errorf("getting v2 target tick vote signature: %w", err)
+			}
+			ev.addMismatch(DataTypeTargetTickVoteSignature, fmt.Sprint(epoch), MismatchMissing, "")
+		} else if signatureV1 != signatureV2 {
+			ev.addMismatch(DataTypeTargetTickVoteSignature, fmt.Sprint(epoch), MismatchDifferent, fmt.Sprintf("v1 %d, v2 %d", signatureV1, signatureV2))
+		}
+	}
+
+	return nil
+}
+
+// verifyTickRange verifies the tick keyed records of the tick range, and the transactions and statuses of its ticks.
+// The transaction ids of the range are held in a transaction id list, like in the migration, and the ids that the
+// epoch store must hold are added to the expected sorters.
+func (v *Verifier) verifyTickRange(ctx context.Context, ev *EpochVerification, tickRange v1.TickRange, expectedTransactions, expectedStatuses *externalSorter, newStore EpochSink) error {
+	txIds := newTxIdList("", v.txIdBufferSize)
+	defer txIds.close()
+
+	err := v.verifyTickDataRange(ctx, ev, tickRange, txIds, newStore)
+	if err != nil {
+		return fmt.Errorf("verifying tick data: %w", err)
+	}
+
+	err = v.verifyQuorumDataRange(ctx, ev, tickRange, newStore)
+	if err != nil {
+		return fmt.Errorf("verifying quorum data: %w", err)
+	}
+
+	err = v.verifyDigestsRange(ctx, ev, tickRange, newStore)
+	if err != nil {
+		return fmt.Errorf("verifying digests: %w", err)
+	}
+
+	err = v.verifyTransactions(ctx, ev, tickRange, txIds, expectedTransactions, newStore)
+	if err != nil {
+		return fmt.Errorf("verifying transactions: %w", err)
+	}
+
+	err = v.verifyTransactionsStatus(ctx, ev, tickRange, txIds, expectedStatuses, newStore)
+	if err != nil {
+		return fmt.Errorf("verifying transactions status: %w", err)
+	}
+	return nil
+}
+
+func (v *Verifier) verifyTickDataRange(ctx context.Context, ev *EpochVerification, tickRange v1.TickRange, txIds *txIdList, newStore EpochSink) error {
+	return v.verifyTickKeyedRange(ctx, ev, DataTypeTickData, tickRange, archiverV1Store.TickData, archiverV2Store.TickData, newStore,
+		func(tickNumber uint32, oldValue, newValue []byte) (bool, error) {
+			var tickDataV1 protoV1.TickData
+			err := proto.Unmarshal(oldValue, &tickDataV1)
+			if err != nil {
+				return false, fmt.Errorf("unmarshaling v1 tick data for tick %d: %w", tickNumber, err)
+			}
+			err = txIds.add(tickNumber, tickDataV1.TransactionIds)
+			if err != nil {
+				return false, fmt.Errorf("adding transaction ids of tick %d: %w", tickNumber, err)
+			}
+
+			if newValue == nil {
+				return false, nil
+			}
+
+			var tickDataV2 protoV2.TickData
+			err = proto.Unmarshal(newValue, &tickDataV2)
+			if err != nil {
+				return false, fmt.Errorf("unmarshaling v2 tick data for tick %d: %w", tickNumber, err)
+			}
+			return proto.Equal(tickDataV1ToV2(&tickDataV1), &tickDataV2), nil
+		})
+}
+
+func (v *Verifier) verifyQuorumDataRange(ctx context.Context, ev *EpochVerification, tickRange v1.TickRange, newStore EpochSink) error {
+	return v.verifyTickKeyedRange(ctx, ev, DataTypeQuorumData, tickRange, archiverV1Store.QuorumData, archiverV2Store.QuorumData, newStore,
+		func(tickNumber uint32, oldValue, newValue []byte) (bool, error) {
+			if newValue == nil {
+				return false, nil
+			}
+
+			var quorumDataV1 protoV1.QuorumTickDataStored
+			err := proto.Unmarshal(oldValue, &quorumDataV1)
+			if err != nil {
+				return false, fmt.Errorf("unmarshaling v1 quorum data for tick %d: %w", tickNumber, err)
+			}
+
+			var quorumDataV2 protoV2.QuorumTickDataStored
+			err = proto.Unmarshal(newValue, &quorumDataV2)
+			if err != nil {
+				return false, fmt.Errorf("unmarshaling v2 quorum data for tick %d: %w", tickNumber, err)
+			}
+			return proto.Equal(quorumDataV1ToV2(&quorumDataV1), &quorumDataV2), nil
+		})
+}
+
+func (v *Verifier) verifyDigestsRange(ctx context.Context, ev *EpochVerification, tickRange v1.TickRange, newStore EpochSink) error {
+	dataTypes := map[int]string{
+		v2.ChainDigest: DataTypeChainDigest,
+		v2.StoreDigest: DataTypeStoreDigest,
+	}
+
+	for _, digest := range digestTypes {
+		err := v.verifyTickKeyedRange(ctx, ev, dataTypes[digest.newPrefix], tickRange, digest.oldPrefix, digest.newPrefix, newStore,
+			func(tickNumber uint32, oldValue, newValue []byte) (bool, error) {
+				return newValue != nil && bytes.Equal(oldValue, newValue), nil
+			})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyTickKeyedRange walks the source and v2 records of a tick keyed prefix side by side. The compare function is
+// called for every source record, with a nil v2 value when the record is missing in v2, and reports whether both are
+// equal.
+func (v *Verifier) verifyTickKeyedRange(ctx context.Context, ev *EpochVerification, dataType string, tickRange v1.TickRange, oldPrefix, newPrefix int, newStore EpochSink, compare func(tickNumber uint32, oldValue, newValue []byte) (bool, error)) error {
+
+	bar := progressbar.Default(int64(tickRange.End-tickRange.Start)+1, fmt.Sprintf("Verifying %s ticks %d to %d", dataType, tickRange.Start, tickRange.End))
+
+	newRecords := newRecordCursor(func(emit func(record sourceRecord) error) error {
+		return newStore.IterateTicks(newPrefix, tickRange, func(tickNumber uint32, value []byte) error {
+			return emit(sourceRecord{tickNumber: tickNumber, value: value})
+		})
+	})
+	defer newRecords.stop()
+
+	err := v.source.IterateTicks(oldPrefix, tickRange, func(oldTick uint32, oldValue []byte) error {
+		for ; newRecords.valid && newRecords.record.tickNumber < oldTick; newRecords.advance() {
+			ev.addMismatch(dataType, fmt.Sprint(newRecords.record.tickNumber), MismatchExtra, "")
+		}
+
+		_ = bar.Add(1)
+		ev.Checked[dataType]++
+
+		if !newRecords.valid || newRecords.record.tickNumber > oldTick {
+			_, err := compare(oldTick, oldValue, nil)
+			if err != nil {
+				return err
+			}
+			ev.addMismatch(dataType, fmt.Sprint(oldTick), MismatchMissing, "")
+			return nil
+		}
+
+		equal, err := compare(oldTick, oldValue, newRecords.record.value)
+		if err != nil {
+			return err
+		}
+		if !equal {
+			ev.addMismatch(dataType, fmt.Sprint(oldTick), MismatchDifferent, "")
+		}
+		newRecords.advance()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("iterating source records: %w", err)
+	}
+
+	for ; newRecords.valid; newRecords.advance() {
+		ev.addMismatch(dataType, fmt.Sprint(newRecords.record.tickNumber), MismatchExtra, "")
+	}
+	if newRecords.err != nil {
+		return fmt.Errorf("iterating v2 records: %w", newRecords.err)
+	}
+	return nil
+}
+
+// errCursorStopped ends the iteration of a cursor that is stopped before its end.
+var errCursorStopped = errors.New("cursor stopped")
+
+// recordCursor pulls the records of an iteration one at a time, so it can be walked side by side with another one. The
+// record is only valid until the cursor advances.
+type recordCursor[T any] struct {
+	next   func() (T, bool)
+	stop   func()
+	record T
+	valid  bool
+	err    error
+}
+
+// newRecordCursor returns a cursor over the records that iterate emits, positioned at the first one.
+func newRecordCursor[T any](iterate func(emit func(record T) error) error) *recordCursor[T] {
+	c := &recordCursor[T]{}
+	c.next, c.stop = iter.Pull(func(yield func(T) bool) {
+		err := iterate(func(record T) error {
+			if !yield(record) {
+				return errCursorStopped
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errCursorStopped) {
+			c.err = err
+		}
+	})
+	c.advance()
+	return c
+}
+
+func (c *recordCursor[T]) advance() {
+	c.record, c.valid = c.next()
+}
+
+// keyedRecord is a record of the epoch store with its key.
+type keyedRecord struct {
+	key   []byte
+	value []byte
+}
+
+// verifyOutOfRangeTicks reports v2 tick keyed records that lie outside all processed tick ranges of the epoch.
+func (v *Verifier) verifyOutOfRangeTicks(ctx context.Context, ev *EpochVerification, tickRanges []v1.TickRange, newStore EpochSink) error {
+
+	prefixes := []struct {
+		dataType string
+		prefix   int
+	}{
+		{DataTypeTickData, archiverV2Store.TickData},
+		{DataTypeQuorumData, archiverV2Store.QuorumData},
+		{DataTypeTickTransactionsStatus, archiverV2Store.TickTransactionsStatus},
+		{DataTypeChainDigest, v2.ChainDigest},
+		{DataTypeStoreDigest, v2.StoreDigest},
+	}
+
+	for _, p := range prefixes {
+		err := newStore.IterateRecords(byte(p.prefix), func(key, value []byte) error {
+			tickNumber := tickFromKey(key)
+			if !tickInRanges(tickNumber, tickRanges) {
+				ev.addMismatch(p.dataType, fmt.Sprint(tickNumber), MismatchExtra, "outside of processed tick ranges")
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("iterating v2 records of %s: %w", p.dataType, err)
+		}
+	}
+	return nil
+}
+
+// verifyTransactions compares the transactions of the ticks in the transaction id list, one window at a time.
+func (v *Verifier) verifyTransactions(ctx context.Context, ev *EpochVerification, tickRange v1.TickRange, txIds *txIdList, expected *externalSorter, newStore EpochSink) error {
+
+	bar := progressbar.Default(int64(tickRange.End-tickRange.Start)+1, fmt.Sprintf("Verifying transactions ticks %d to %d", tickRange.Start, tickRange.End))
+
+	return txIds.windows(tickRange.Start, func(window []tickTxIds) error {
+		for _, tick := range window {
+			_ = bar.Set(int(tick.tickNumber-tickRange.Start) + 1)
+
+			for _, txId := range tick.txIds {
+				err := expected.add([]byte(txId), nil)
+				if err != nil {
+					return fmt.Errorf("adding expected transaction %s: %w", txId, err)
+				}
+				ev.Checked[DataTypeTransaction]++
+
+				txV1, err := v.source.GetTransaction(ctx, txId)
+				if err != nil {
+					if errors.Is(err, archiverV1Store.ErrNotFound) {
+						ev.addMismatch(DataTypeTransaction, txId, MismatchMissing, fmt.Sprintf("tick %d, not found in v1", tick.tickNumber))
+						continue
+					}
+					return fmt.Errorf("getting v1 transaction %s: %w", txId, err)
+				}
+
+				var txV2 protoV2.Transaction
+				found, err := getV2Record(newStore, migratorStore.AssembleKey(archiverV2Store.Transaction, txId), &txV2)
+				if err != nil {
+					return fmt.Errorf("getting v2 transaction %s: %w", txId, err)
+				}
+				if !found {
+					ev.addMismatch(DataTypeTransaction, txId, MismatchMissing, fmt.Sprintf("tick %d", tick.tickNumber))
+					continue
+				}
+				if !proto.Equal(transactionV1ToV2(txV1), &txV2) {
+					ev.addMismatch(DataTypeTransaction, txId, MismatchDifferent, fmt.Sprintf("tick %d", tick.tickNumber))
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// verifyTransactionsStatus compares the statuses of the transactions in the transaction id list, one window at a
+// time, and walks the v2 tick transactions status records of the range alongside the ticks. Every tick of the list must
+// have a record, listing the statuses in the order of its transaction ids.
+func (v *Verifier) verifyTransactionsStatus(ctx context.Context, ev *EpochVerification, tickRange v1.TickRange, txIds *txIdList, expected *externalSorter, newStore EpochSink) error {
+
+	bar := progressbar.Default(int64(tickRange.End-tickRange.Start)+1, fmt.Sprintf("Verifying transactions status ticks %d to %d", tickRange.Start, tickRange.End))
+
+	newRecords := newRecordCursor(func(emit func(record sourceRecord) error) error {
+		return newStore.IterateTicks(archiverV2Store.TickTransactionsStatus, tickRange, func(tickNumber uint32, value []byte) error {
+			return emit(sourceRecord{tickNumber: tickNumber, value: value})
+		})
+	})
+	defer newRecords.stop()
+
+	var tickStatuses tickStatusesV1
+	err := txIds.windows(tickRange.Start, func(window []tickTxIds) error {
+		for _, tick := range window {
+			tickNumber := tick.tickNumber
+			_ = bar.Set(int(tickNumber-tickRange.Start) + 1)
+
+			var expectedTts protoV2.TickTransactionsStatus
+
+			for _, txId := range tick.txIds {
+				ev.Checked[DataTypeTransactionStatus]++
+
+				txStatusV1, _, err := transactionStatusWithPolicy(ctx, v.source, v.missingStatusPolicy, tickNumber, txId, &tickStatuses)
+				if err != nil {
+					if errors.Is(err, archiverV1Store.ErrNotFound) {
+						ev.addMismatch(DataTypeTransactionStatus, txId, MismatchMissing, fmt.Sprintf("tick %d, not found in v1", tickNumber))
+						continue
+					}
+					return err
+				}
+				// The status was skipped by the missing status policy, so the epoch store must not have it.
+				if txStatusV1 == nil {
+					continue
+				}
+				err = expected.add([]byte(txId), nil)
+				if err != nil {
+					return fmt.Errorf("adding expected transaction status %s: %w", txId, err)
+				}
+
+				txStatusV2Expected := transactionStatusV1ToV2(txStatusV1)
+				expectedTts.Transactions = append(expectedTts.Transactions, txStatusV2Expected)
+
+				var txStatusV2 protoV2.TransactionStatus
+				found, err := getV2Record(newStore, migratorStore.AssembleKey(archiverV2Store.TransactionStatus, txId), &txStatusV2)
+				if err != nil {
+					return fmt.Errorf("getting v2 transaction status %s: %w", txId, err)
+				}
+				if !found {
+					ev.addMismatch(DataTypeTransactionStatus, txId, MismatchMissing, fmt.Sprintf("tick %d", tickNumber))
+					continue
+				}
+				if !proto.Equal(txStatusV2Expected, &txStatusV2) {
+					ev.addMismatch(DataTypeTransactionStatus, txId, MismatchDifferent, fmt.Sprintf("tick %d", tickNumber))
+				}
+			}
+
+			for ; newRecords.valid && newRecords.record.tickNumber < tickNumber; newRecords.advance() {
+				ev.addMismatch(DataTypeTickTransactionsStatus, fmt.Sprint(newRecords.record.tickNumber), MismatchExtra, "")
+			}
+
+			ev.Checked[DataTypeTickTransactionsStatus]++
+			if !newRecords.valid || newRecords.record.tickNumber > tickNumber {
+				// A tick without statuses is not required to have a record.
+				if len(expectedTts.Transactions) > 0 {
+					ev.addMismatch(DataTypeTickTransactionsStatus, fmt.Sprint(tickNumber), MismatchMissing, "")
+				}
+				continue
+			}
+
+			var ttsV2 protoV2.TickTransactionsStatus
+			err := proto.Unmarshal(newRecords.record.value, &ttsV2)
+			if err != nil {
+				return fmt.Errorf("unmarshaling v2 tick transactions status for tick %d: %w", tickNumber, err)
+			}
+			// The lookup status strategy lists the statuses in the order of the tick data, the scan in the order of the v1
+			// record.
+			sortStatusesByTxId(expectedTts.Transactions)
+			sortStatusesByTxId(ttsV2.Transactions)
+			if !proto.Equal(&expectedTts, &ttsV2) {
+				ev.addMismatch(DataTypeTickTransactionsStatus, fmt.Sprint(tickNumber), MismatchDifferent, "")
+			}
+			newRecords.advance()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for ; newRecords.valid; newRecords.advance() {
+		ev.addMismatch(DataTypeTickTransactionsStatus, fmt.Sprint(newRecords.record.tickNumber), MismatchExtra, "")
+	}
+	if newRecords.err != nil {
+		return fmt.Errorf("iterating v2 tick transactions status: %w", newRecords.err)
+	}
+	return nil
+}
+
+func sortStatusesByTxId(statuses []*protoV2.TransactionStatus) {
+	slices.SortFunc(statuses, func(a, b *protoV2.TransactionStatus) int {
+		return cmp.Compare(a.TxId, b.TxId)
+	})
+}
+
+// verifyIdentityTransfers walks the identity transfer index entries of the source in the processed tick ranges and the
+// ones of the epoch store side by side. Both are in identity order and the migration copies the values as they are.
+func (v *Verifier) verifyIdentityTransfers(ev *EpochVerification, tickRanges []v1.TickRange, newStore EpochSink) error {
+	tickRanges = slices.SortedFunc(slices.Values(tickRanges), func(a, b v1.TickRange) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+
+	bar := progressbar.Default(-1, "Verifying identity transfers")
+
+	newRecords := newRecordCursor(func(emit func(record keyedRecord) error) error {
+		return newStore.IterateRecords(v2.IdentityTransferTransactions, func(key, value []byte) error {
+			return emit(keyedRecord{key: key, value: value})
+		})
+	})
+	defer newRecords.stop()
+
+	err := v.source.IterateIdentityTransfers(tickRanges, func(identity []byte, tickNumber uint64, value []byte) error {
+		key := v2.IdentityTransferTransactionsKey(identity, tickNumber)
+		for ; newRecords.valid && bytes.Compare(newRecords.record.key, key) < 0; newRecords.advance() {
+			ev.addMismatch(DataTypeIdentityTransfers, identityTransfersKeyString(newRecords.record.key), MismatchExtra, "")
+		}
+
+		_ = bar.Add(1)
+		ev.Checked[DataTypeIdentityTransfers]++
+
+		if !newRecords.valid || !bytes.Equal(newRecords.record.key, key) {
+			ev.addMismatch(DataTypeIdentityTransfers, identityTransfersKeyString(key), MismatchMissing, "")
+			return nil
+		}
+		if !bytes.Equal(newRecords.record.value, value) {
+			ev.addMismatch(DataTypeIdentityTransfers, identityTransfersKeyString(key), MismatchDifferent, "")
+		}
+		newRecords.advance()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("iterating source identity transfers: %w", err)
+	}
+
+	for ; newRecords.valid; newRecords.advance() {
+		ev.addMismatch(DataTypeIdentityTransfers, identityTransfersKeyString(newRecords.record.key), MismatchExtra, "")
+	}
+	if newRecords.err != nil {
+		return fmt.Errorf("iterating v2 identity transfers: %w", newRecords.err)
+	}
+	_ = bar.Finish()
+	return nil
+}
+
+// identityTransfersKeyString formats an identity transfer index key as identity and tick number.
+func identityTransfersKeyString(key []byte) string {
+	if len(key) <= 9 {
+		return fmt.Sprintf("%x", key)
+	}
+	return fmt.Sprintf("%s/%d", key[1:len(key)-8], binary.BigEndian.Uint64(key[len(key)-8:]))
+}
+
+// verifyNoExtraIds reports v2 records keyed by transaction id that are not expected from the source tick data. The
+// expected ids are merged in key order with the records of the prefix.
+func verifyNoExtraIds(ev *EpochVerification, dataType string, prefix int, expected *externalSorter, newStore EpochSink) error {
+	newRecords := newRecordCursor(func(emit func(record keyedRecord) error) error {
+		return newStore.IterateRecords(byte(prefix), func(key, value []byte) error {
+			return emit(keyedRecord{key: key, value: value})
+		})
+	})
+	defer newRecords.stop()
+
+	err := expected.merge(func(txId, _ []byte) error {
+		for ; newRecords.valid && bytes.Compare(newRecords.record.key[1:], txId) < 0; newRecords.advance() {
+			ev.addMismatch(dataType, string(newRecords.record.key[1:]), MismatchExtra, "")
+		}
+		if newRecords.valid && bytes.Equal(newRecords.record.key[1:], txId) {
+			newRecords.advance()
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("merging expected ids of %s: %w", dataType, err)
+	}
+
+	for ; newRecords.valid; newRecords.advance() {
+		ev.addMismatch(dataType, string(newRecords.record.key[1:]), MismatchExtra, "")
+	}
+	if newRecords.err != nil {
+		return fmt.Errorf("iterating v2 records of %s: %w", dataType, newRecords.err)
+	}
+	return nil
+}
+
+func getV2Record(newStore EpochSink, key []byte, message proto.Message) (bool, error) {
+	value, found, err := newStore.GetRecord(key)
+	if err != nil || !found {
+		return false, err
+	}
+
+	err = proto.Unmarshal(value, message)
+	if err != nil {
+		return false, fmt.Errorf("unmarshaling record: %w", err)
+	}
+	return true, nil
+}
+
+func tickFromKey(key []byte) uint32 {
+	return binary.BigEndian.Uint32(key[len(key)-4:])
+}
+
+func tickInRanges(tickNumber uint32, tickRanges []v1.TickRange) bool {
+	for _, tickRange := range tickRanges {
+		if tickNumber >= tickRange.Start && tickNumber <= tickRange.End {
+			return true
+		}
+	}
+	return false
+}
+
+func PrintVerificationResults(results []*EpochVerification) {
+
+	for _, result := range results {
+		log.Printf("Epoch: %d\n", result.Epoch)
+		for _, dataType := range verifiedDataTypes {
+			checked, exists := result.Checked[dataType]
+			if !exists {
+				continue
+			}
+			log.Printf("  - %s: %d checked, %d mismatches\n", dataType, checked, result.countMismatches(dataType))
+		}
+	}
+
+	var mismatches []Mismatch
+	for _, result := range results {
+		mismatches = append(mismatches, result.Mismatches...)
+	}
+	if len(mismatches) == 0 {
+		log.Println("No mismatches found.")
+		return
+	}
+
+	slices.SortStableFunc(mismatches, func(a, b Mismatch) int {
+		if a.Epoch != b.Epoch {
+			return int(a.Epoch) - int(b.Epoch)
+		}
+		return slices.Index(verifiedDataTypes, a.DataType) - slices.Index(verifiedDataTypes, b.DataType)
+	})
+
+	log.Printf("Mismatches (%d):\n", len(mismatches))
+	for _, mismatch := range mismatches {
+		if mismatch.Details != "" {
+			log.Printf("  - epoch %d %s %s: %s (%s)\n", mismatch.Epoch, mismatch.DataType, mismatch.Key, mismatch.Kind, mismatch.Details)
+			continue
+		}
+		log.Printf("  - epoch %d %s %s: %s\n", mismatch.Epoch, mismatch.DataType, mismatch.Key, mismatch.Kind)
+	}
+}
+
+func HasMismatches(results []*EpochVerification) bool {
+	for _, result := range results {
+		if len(result.Mismatches) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,238 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"time"
+
+	v1 "github.com/qubic/archiver-db-migrator/store/v1"
+)
+
+const (
+	// WriteModeBatch writes records through pebble batches, committing one batch at a time.
+	WriteModeBatch = "batch"
+	// WriteModeIngest writes records into sorted external sstables that are ingested once a tick range is complete.
+	WriteModeIngest = "ingest"
+)
+
+// rangeWriter receives the records migrated for one tick range.
+type rangeWriter interface {
+	Set(key, value []byte) error
+	// commit makes the records set so far durable, together with the checkpoint of the data type. The final commit is
+	// made once the whole range is written.
+	commit(ctx context.Context, dataType byte, rangeStart, lastTick uint32, final bool) error
+	close() error
+}
+
+// newRangeWriter returns a writer for the configured write mode. Records are expected in ascending key order when
+// sorted is true, otherwise the ingest writer sorts them before writing the sstables.
+func (m *Migrator) newRangeWriter(newStore EpochSink, sorted bool) (rangeWriter, error) {
+	writer, err := m.newWriteModeRangeWriter(newStore, sorted)
+	if err != nil || (m.reporter == nil && m.metrics == nil) {
+		return writer, err
+	}
+
+	return &observedRangeWriter{
+		rangeWriter: writer,
+		reporter:    m.reporter,
+		metrics:     m.metrics,
+		epoch:       newStore.Epoch(),
+		deferred:    m.writeMode == WriteModeIngest,
+		start:       time.Now(),
+	}, nil
+}
+
+func (m *Migrator) newWriteModeRangeWriter(newStore EpochSink, sorted bool) (rangeWriter, error) {
+	if m.writeMode != WriteModeIngest {
+		return &batchRangeWriter{
+			batch: newStore.NewBatch(),
+		}, nil
+	}
+
+	tableWriter, err := newStore.NewTableWriter(m.newStorePath)
+	if err != nil {
+		return nil, fmt.Errorf("creating sstable writer: %w", err)
+	}
+
+	writer := ingestRangeWriter{
+		newStore:    newStore,
+		tableWriter: tableWriter,
+	}
+
+	if !sorted {
+		writer.sorter, err = newExternalSorter(m.newStorePath, m.sortBufferSize)
+		if err != nil {
+			_ = tableWriter.Close()
+			return nil, fmt.Errorf("creating external sorter: %w", err)
+		}
+	}
+	return &writer, nil
+}
+
+type batchRangeWriter struct {
+	batch SinkBatch
+}
+
+func (w *batchRangeWriter) Set(key, value []byte) error {
+	return w.batch.Set(key, value)
+}
+
+func (w *batchRangeWriter) commit(ctx context.Context, dataType byte, rangeStart, lastTick uint32, _ bool) error {
+	err := w.batch.SetCheckpoint(dataType, rangeStart, lastTick)
+	if err != nil {
+		return err
+	}
+
+	err = w.batch.Commit()
+	if err != nil {
+		return fmt.Errorf("committing batch: %w", err)
+	}
+
+	runtime.GC()
+	return nil
+}
+
+func (w *batchRangeWriter) close() error {
+	return w.batch.Close()
+}
+
+// ingestRangeWriter only writes on the final commit, so an interrupted range is migrated again from its start.
+type ingestRangeWriter struct {
+	newStore    EpochSink
+	tableWriter SinkTableWriter
+	sorter      *externalSorter
+}
+
+func (w *ingestRangeWriter) Set(key, value []byte) error {
+	if w.sorter != nil {
+		return w.sorter.add(key, value)
+	}
+	return w.tableWriter.Set(key, value)
+}
+
+func (w *ingestRangeWriter) commit(ctx context.Context, dataType byte, rangeStart, lastTick uint32, final bool) error {
+	if !final {
+		return nil
+	}
+
+	if w.sorter != nil {
+		err := w.sorter.merge(func(key, value []byte) error {
+			return w.tableWriter.Set(key, value)
+		})
+		if err != nil {
+			return fmt.Errorf("writing sorted records: %w", err)
+		}
+	}
+
+	err := w.tableWriter.Ingest(ctx)
+	if err != nil {
+		return err
+	}
+
+	return w.newStore.SetCheckpoint(dataType, rangeStart, lastTick)
+}
+
+func (w *ingestRangeWriter) close() error {
+	if w.sorter != nil {
+		err := w.sorter.close()
+		if err != nil {
+			return err
+		}
+	}
+	return w.tableWriter.Close()
+}
+
+// observedRangeWriter counts the records set between commits and reports them to the reporter and the metrics with
+// every commit.
+type observedRangeWriter struct {
+	rangeWriter
+	reporter *Reporter
+	metrics  *Metrics
+	epoch    uint32
+	// deferred is set for writers that only write on the final commit, whose records are reported once.
+	deferred bool
+	start    time.Time
+	records  uint64
+	bytes    uint64
+}
+
+func (w *observedRangeWriter) Set(key, value []byte) error {
+	w.records++
+	w.bytes += uint64(len(key) + len(value))
+	return w.rangeWriter.Set(key, value)
+}
+
+func (w *observedRangeWriter) commit(ctx context.Context, dataType byte, rangeStart, lastTick uint32, final bool) error {
+	start := time.Now()
+	err := w.rangeWriter.commit(ctx, dataType, rangeStart, lastTick, final)
+	if err != nil {
+		return err
+	}
+
+	if w.deferred && !final {
+		return nil
+	}
+
+	w.reporter.batchCommitted(w.epoch, dataType, rangeStart, lastTick, w.records, w.bytes)
+	w.metrics.batchCommitted(w.epoch, dataType, lastTick, w.records, w.bytes, time.Since(start))
+	w.records = 0
+	w.bytes = 0
+
+	if final {
+		w.reporter.rangeFinished(w.epoch, dataType, time.Since(w.start))
+	}
+	return nil
+}
+
+func logThroughput(description string, tickRange v1.TickRange, records int, start time.Time) {
+	elapsed := time.Since(start)
+	log.Printf("Migrated %d %s records for tick range %v in %s (%.0f records/s)\n", records, description, tickRange, elapsed.Round(time.Millisecond), float64(records)/elapsed.Seconds())
+}
+
+// BenchmarkWriteModes migrates the epoch once with every write mode into temporary stores and reports how long each
+// mode took. The temporary stores are removed afterward.
+func (m *Migrator) BenchmarkWriteModes(ctx context.Context, epoch uint32) error {
+	err := os.MkdirAll(m.newStorePath, 0755)
+	if err != nil {
+		return fmt.Errorf("creating store directory: %w", err)
+	}
+
+	durations := make(map[string]time.Duration)
+
+	for _, writeMode := range []string{WriteModeBatch, WriteModeIngest} {
+		dir, err := os.MkdirTemp(m.newStorePath, ".benchmark-"+writeMode+"-*")
+		if err != nil {
+			return fmt.Errorf("creating temporary store directory for write mode %s: %w", writeMode, err)
+		}
+
+		benchmarkMigrator := *m
+		benchmarkMigrator.newStorePath = dir
+		benchmarkMigrator.writeMode = writeMode
+		benchmarkMigrator.reporter = nil
+		benchmarkMigrator.metrics = nil
+
+		log.Printf("Migrating epoch %d with write mode %s\n", epoch, writeMode)
+
+		start := time.Now()
+		err = benchmarkMigrator.MigrateEpoch(ctx, epoch)
+		durations[writeMode] = time.Since(start)
+
+		removeErr := os.RemoveAll(dir)
+		if err != nil {
+			return fmt.Errorf("migrating epoch %d with write mode %s: %w", epoch, writeMode, err)
+		}
+		if removeErr != nil {
+			return fmt.Errorf("removing temporary store directory %s: %w", dir, removeErr)
+		}
+	}
+
+	log.Printf("Write mode benchmark for epoch %d:\n", epoch)
+	for _, writeMode := range []string{WriteModeBatch, WriteModeIngest} {
+		log.Printf("  - %s: %s\n", writeMode, durations[writeMode].Round(time.Millisecond))
+	}
+	log.Printf("  - ingest speedup: %.2fx\n", durations[WriteModeBatch].Seconds()/durations[WriteModeIngest].Seconds())
+	return nil
+}
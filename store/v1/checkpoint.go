@@ -0,0 +1,88 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// NewArchiverStoreV1Checkpoint creates a pebble checkpoint of the v1 database at checkpointPath and opens it. The
+// checkpoint hard links the table files of the database, so on the same file system it is created in moments and
+// hardly takes any space, and it copies the write ahead log. Creating it takes the directory lock of the database, so
+// the archiver has to be stopped until NewArchiverStoreV1Checkpoint returns, and can be started again while the
+// checkpoint is migrated. The checkpoint is opened read only and is not written by anything else. Closing the store
+// removes the checkpoint.
+//
+// A checkpoint left at checkpointPath by an earlier run is removed first.
+func NewArchiverStoreV1Checkpoint(ctx context.Context, path, checkpointPath string) (*ArchiverStoreV1, error) {
+	err := CheckFormatMajorVersion(path)
+	if err != nil {
+		return nil, err
+	}
+	err = removeCheckpoint(checkpointPath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = createCheckpoint(path, checkpointPath)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := openArchiverStoreV1(ctx, checkpointPath, getPebbleOptions())
+	if err != nil {
+		_ = os.RemoveAll(checkpointPath)
+		return nil, fmt.Errorf("opening checkpoint: %w", err)
+	}
+	s.checkpointPath = checkpointPath
+	return s, nil
+}
+
+// createCheckpoint creates a pebble checkpoint of the database at checkpointPath. Pebble leaves the manifest of a
+// checkpoint of a read only database empty, so the database is opened for writing, with automatic compactions
+// disabled. Opening it replays its write ahead log, like the archiver does when it starts, and changes none of its
+// records.
+func createCheckpoint(path, checkpointPath string) error {
+	options := getPebbleOptions()
+	options.ReadOnly = false
+	options.DisableAutomaticCompactions = true
+	db, err := pebble.Open(path, options)
+	if err != nil {
+		return fmt.Errorf("opening archiver v1 database, the archiver has to be stopped: %w", err)
+	}
+
+	log.Printf("Creating checkpoint of %s at %s\n", path, checkpointPath)
+	err = db.Checkpoint(checkpointPath, pebble.WithFlushedWAL())
+	closeErr := db.Close()
+	if err != nil {
+		_ = os.RemoveAll(checkpointPath)
+		return fmt.Errorf("creating checkpoint: %w", err)
+	}
+	if closeErr != nil {
+		_ = os.RemoveAll(checkpointPath)
+		return fmt.Errorf("closing archiver v1 database: %w", closeErr)
+	}
+	return nil
+}
+
+func removeCheckpoint(checkpointPath string) error {
+	_, err := os.Stat(checkpointPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("checking checkpoint %s: %w", checkpointPath, err)
+	}
+
+	log.Printf("Removing checkpoint %s\n", checkpointPath)
+	err = os.RemoveAll(checkpointPath)
+	if err != nil {
+		return fmt.Errorf("removing checkpoint %s: %w", checkpointPath, err)
+	}
+	return nil
+}
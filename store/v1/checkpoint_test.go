@@ -0,0 +1,101 @@
+package v1
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/qubic/go-archiver/protobuff"
+	"github.com/qubic/go-archiver/store"
+)
+
+// TestCheckpoint checkpoints a database that the archiver has stopped writing. The checkpoint cannot be created while
+// the archiver holds the lock, and once it is created the archiver writes the database again without changing it.
+func TestCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "archive")
+	checkpointPath := filepath.Join(dir, "checkpoint")
+
+	const epoch, firstTick = 158, 13752100
+
+	openWriter := func() (*pebble.DB, *store.PebbleStore) {
+		db, err := pebble.Open(path, &pebble.Options{FormatMajorVersion: MinFormatMajorVersion})
+		if err != nil {
+			t.Fatalf("opening database: %v", err)
+		}
+		return db, store.NewPebbleStore(db, nil)
+	}
+
+	db, writer := openWriter()
+	writeTicks(ctx, t, writer, epoch, firstTick, firstTick+99)
+
+	_, err := NewArchiverStoreV1Checkpoint(ctx, path, checkpointPath)
+	if err == nil {
+		t.Fatal("created a checkpoint while the database is locked")
+	}
+	err = db.Close()
+	if err != nil {
+		t.Fatalf("closing database: %v", err)
+	}
+
+	checkpoint, err := NewArchiverStoreV1Checkpoint(ctx, path, checkpointPath)
+	if err != nil {
+		t.Fatalf("creating checkpoint: %v", err)
+	}
+
+	db, writer = openWriter()
+	writeTicks(ctx, t, writer, epoch, firstTick+100, firstTick+199)
+	err = db.Close()
+	if err != nil {
+		t.Fatalf("closing database: %v", err)
+	}
+
+	epochMetadata := checkpoint.StoreMetadata.Epochs[epoch]
+	if epochMetadata.LastProcessedTick != firstTick+99 {
+		t.Fatalf("checkpoint ends at tick %d, expected %d", epochMetadata.LastProcessedTick, firstTick+99)
+	}
+	iter, err := checkpoint.NewIter(&pebble.IterOptions{
+		LowerBound: []byte{store.TickData},
+		UpperBound: []byte{store.TickData + 1},
+	})
+	if err != nil {
+		t.Fatalf("creating iterator: %v", err)
+	}
+	ticks := 0
+	for iter.First(); iter.Valid(); iter.Next() {
+		ticks++
+	}
+	err = iter.Close()
+	if err != nil {
+		t.Fatalf("closing iterator: %v", err)
+	}
+	if ticks != 100 {
+		t.Fatalf("checkpoint holds %d ticks, expected 100", ticks)
+	}
+
+	err = checkpoint.Close()
+	if err != nil {
+		t.Fatalf("closing checkpoint: %v", err)
+	}
+	_, err = os.Stat(checkpointPath)
+	if !os.IsNotExist(err) {
+		t.Fatalf("checkpoint %s was not removed: %v", checkpointPath, err)
+	}
+}
+
+// writeTicks writes the tick data of the ticks and sets the last one as the last processed tick, like the archiver.
+func writeTicks(ctx context.Context, t *testing.T, writer *store.PebbleStore, epoch, firstTick, lastTick uint32) {
+	for tickNumber := firstTick; tickNumber <= lastTick; tickNumber++ {
+		err := writer.SetTickData(ctx, tickNumber, &protobuff.TickData{Epoch: epoch, TickNumber: tickNumber})
+		if err != nil {
+			t.Fatalf("setting tick data for tick %d: %v", tickNumber, err)
+		}
+	}
+	err := writer.SetLastProcessedTick(ctx, &protobuff.ProcessedTick{TickNumber: lastTick, Epoch: epoch})
+	if err != nil {
+		t.Fatalf("setting last processed tick: %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package v1
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/qubic/go-archiver/store"
+)
+
+// CreateArchiverStoreV1 creates a new, writable v1 database at path in UpgradedFormatMajorVersion, the format of
+// an upgraded one. The records are written through the setters of the go-archiver store, which sync every write. The
+// database skips these syncs, its writes only become durable with Flush.
+func CreateArchiverStoreV1(path string) (*ArchiverStoreV1, error) {
+	db, err := pebble.Open(path, &pebble.Options{
+		ErrorIfExists:      true,
+		FormatMajorVersion: UpgradedFormatMajorVersion,
+		FS:                 noSyncFS{FS: vfs.Default},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating archiver v1 database: %w", err)
+	}
+
+	return &ArchiverStoreV1{
+		db:            db,
+		ArchiverStore: store.NewPebbleStore(db, nil),
+		StoreMetadata: StoreMetadata{Epochs: make(map[uint32]EpochMetadata)},
+		createdPath:   path,
+	}, nil
+}
+
+// Flush writes the records of a store created with CreateArchiverStoreV1 to table files, and syncs the files and the
+// directory of the database.
+func (s *ArchiverStoreV1) Flush() error {
+	if s.createdPath == "" {
+		return errors.New("only a created store can be flushed")
+	}
+
+	err := s.db.Flush()
+	if err != nil {
+		return fmt.Errorf("flushing memtable: %w", err)
+	}
+
+	entries, err := os.ReadDir(s.createdPath)
+	if err != nil {
+		return fmt.Errorf("listing %s: %w", s.createdPath, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		err = syncFile(filepath.Join(s.createdPath, entry.Name()))
+		// A file compacted away since the listing does not need to be synced.
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return syncFile(s.createdPath)
+}
+
+func syncFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	closeErr := file.Close()
+	if err != nil {
+		return fmt.Errorf("syncing %s: %w", path, err)
+	}
+	return closeErr
+}
+
+// noSyncFS skips the syncs of the files that pebble writes.
+type noSyncFS struct {
+	vfs.FS
+}
+
+func (fs noSyncFS) Create(name string) (vfs.File, error) {
+	return noSyncFile(fs.FS.Create(name))
+}
+
+func (fs noSyncFS) OpenReadWrite(name string, opts ...vfs.OpenOption) (vfs.File, error) {
+	return noSyncFile(fs.FS.OpenReadWrite(name, opts...))
+}
+
+func (fs noSyncFS) OpenDir(name string) (vfs.File, error) {
+	return noSyncFile(fs.FS.OpenDir(name))
+}
+
+func (fs noSyncFS) ReuseForWrite(oldname, newname string) (vfs.File, error) {
+	return noSyncFile(fs.FS.ReuseForWrite(oldname, newname))
+}
+
+func noSyncFile(file vfs.File, err error) (vfs.File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return unsyncedFile{File: file}, nil
+}
+
+type unsyncedFile struct {
+	vfs.File
+}
+
+func (f unsyncedFile) Sync() error {
+	return nil
+}
+
+func (f unsyncedFile) SyncData() error {
+	return nil
+}
+
+// SyncTo reports a full sync, so pebble does not sync the file again.
+func (f unsyncedFile) SyncTo(int64) (bool, error) {
+	return true, nil
+}
@@ -0,0 +1,145 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// MinFormatMajorVersion is the oldest pebble format major version of a v1 database that is migrated. It is the oldest
+// format that pebble v1.1.5 opens, so every database the archiver v1 wrote is read without an upgrade.
+const MinFormatMajorVersion = pebble.FormatMostCompatible
+
+// UpgradedFormatMajorVersion is the format major version that UpgradeFormatMajorVersion upgrades to and that new v1
+// databases are created in. It is the newest format of pebble v1.1.5, which is what `pebble db upgrade` of that version
+// produces.
+const UpgradedFormatMajorVersion = pebble.FormatNewest
+
+// Ways of upgrading the format major version of a v1 database.
+const (
+	// UpgradeFormatNone does not upgrade, a database with an older format fails to open.
+	UpgradeFormatNone = "none"
+	// UpgradeFormatCheckpoint upgrades a checkpoint of the database, which is then migrated.
+	UpgradeFormatCheckpoint = "checkpoint"
+	// UpgradeFormatInPlace upgrades the database itself.
+	UpgradeFormatInPlace = "in-place"
+)
+
+// ErrFormatTooOld is returned when a v1 database has an older format than MinFormatMajorVersion.
+var ErrFormatTooOld = errors.New("format major version too old")
+
+// ErrUpgradedCheckpointExists is returned when an upgraded checkpoint left by an earlier run is found and is not to be
+// reused.
+var ErrUpgradedCheckpointExists = errors.New("upgraded checkpoint exists")
+
+// ReadFormatMajorVersion returns the pebble format major version of the database without opening it.
+func ReadFormatMajorVersion(path string) (pebble.FormatMajorVersion, error) {
+	desc, err := pebble.Peek(path, vfs.Default)
+	if err != nil {
+		return 0, fmt.Errorf("reading format major version of %s: %w", path, err)
+	}
+	if !desc.Exists {
+		return 0, fmt.Errorf("reading format major version of %s: no pebble database found", path)
+	}
+	return desc.FormatMajorVersion, nil
+}
+
+// CheckFormatMajorVersion returns an error wrapping ErrFormatTooOld if the database has an older format than
+// MinFormatMajorVersion.
+func CheckFormatMajorVersion(path string) error {
+	version, err := ReadFormatMajorVersion(path)
+	if err != nil {
+		return err
+	}
+	if version < MinFormatMajorVersion {
+		return fmt.Errorf("%w: archiver v1 database %s has format major version %s, at least %s is required", ErrFormatTooOld, path, version, MinFormatMajorVersion)
+	}
+	return nil
+}
+
+// UpgradeFormatMajorVersion upgrades the database at upgradedPath to UpgradedFormatMajorVersion, and returns its format
+// major version before and after the upgrade. A database that already has UpgradedFormatMajorVersion is left as it is,
+// no checkpoint is created and the version is returned as both before and after. Unless upgradedPath is path, the
+// database is not modified, a checkpoint of it is created at upgradedPath and upgraded instead. The checkpoint hard
+// links the table files, which the upgrade never modifies, it only writes new ones. Both take the directory lock of the
+// database, so the archiver has to be stopped, for a checkpoint only while it is created.
+//
+// An upgraded checkpoint is removed with RemoveUpgradedCheckpoint once it has been migrated. One left at upgradedPath
+// by an earlier run holds the state of the database at the time it was created. It is only used as it is with
+// reuseUpgraded, otherwise an error wrapping ErrUpgradedCheckpointExists is returned.
+func UpgradeFormatMajorVersion(ctx context.Context, path, upgradedPath string, reuseUpgraded bool) (pebble.FormatMajorVersion, pebble.FormatMajorVersion, error) {
+	before, err := ReadFormatMajorVersion(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	if before >= UpgradedFormatMajorVersion {
+		return before, before, nil
+	}
+
+	if upgradedPath != path {
+		_, err = os.Stat(upgradedPath)
+		if err == nil {
+			if !reuseUpgraded {
+				return 0, 0, fmt.Errorf("%w: %s was created by an earlier run", ErrUpgradedCheckpointExists, upgradedPath)
+			}
+			after, err := ReadFormatMajorVersion(upgradedPath)
+			if err != nil {
+				return 0, 0, err
+			}
+			if after < UpgradedFormatMajorVersion {
+				return 0, 0, fmt.Errorf("%s holds a database with format major version %s that was not upgraded, remove it", upgradedPath, after)
+			}
+			log.Printf("Using the upgraded checkpoint %s created by an earlier run.\n", upgradedPath)
+			return before, after, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return 0, 0, fmt.Errorf("checking %s: %w", upgradedPath, err)
+		}
+
+		err = createCheckpoint(path, upgradedPath)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	err = upgradeFormatMajorVersion(ctx, upgradedPath)
+	if err != nil {
+		if upgradedPath != path {
+			_ = os.RemoveAll(upgradedPath)
+		}
+		return 0, 0, fmt.Errorf("upgrading %s: %w", upgradedPath, err)
+	}
+
+	after, err := ReadFormatMajorVersion(upgradedPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	return before, after, nil
+}
+
+// RemoveUpgradedCheckpoint removes the upgraded checkpoint created by UpgradeFormatMajorVersion at upgradedPath.
+func RemoveUpgradedCheckpoint(upgradedPath string) error {
+	return removeCheckpoint(upgradedPath)
+}
+
+func upgradeFormatMajorVersion(ctx context.Context, path string) error {
+	// The upgrade rewrites tables of old formats. It opens the database with the default options, like the pebble db
+	// upgrade command did, instead of the level options of the migration.
+	s, err := openArchiverStoreV1(ctx, path, &pebble.Options{})
+	if err != nil {
+		return err
+	}
+
+	err = s.db.RatchetFormatMajorVersion(UpgradedFormatMajorVersion)
+	closeErr := s.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
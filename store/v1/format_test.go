@@ -0,0 +1,100 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+)
+
+func createDatabase(t *testing.T, path string, version pebble.FormatMajorVersion) {
+	db, err := pebble.Open(path, &pebble.Options{FormatMajorVersion: version})
+	if err != nil {
+		t.Fatalf("creating database: %v", err)
+	}
+	err = db.Set([]byte{0x01}, []byte{0x02}, pebble.Sync)
+	if err != nil {
+		t.Fatalf("writing database: %v", err)
+	}
+	err = db.Close()
+	if err != nil {
+		t.Fatalf("closing database: %v", err)
+	}
+}
+
+func TestUpgradeFormatMajorVersionCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "archive")
+	upgradedPath := path + ".upgraded"
+
+	createDatabase(t, path, MinFormatMajorVersion)
+
+	// A database in the oldest format is read without an upgrade.
+	s, err := NewArchiverStoreV1(ctx, path)
+	if err != nil {
+		t.Fatalf("opening a database with format major version %s: %v", MinFormatMajorVersion, err)
+	}
+	err = s.Close()
+	if err != nil {
+		t.Fatalf("closing database: %v", err)
+	}
+
+	before, after, err := UpgradeFormatMajorVersion(ctx, path, upgradedPath, false)
+	if err != nil {
+		t.Fatalf("upgrading a checkpoint: %v", err)
+	}
+	if before != MinFormatMajorVersion || after != UpgradedFormatMajorVersion {
+		t.Fatalf("got format major versions %s and %s, expected %s and %s", before, after, MinFormatMajorVersion, UpgradedFormatMajorVersion)
+	}
+	version, err := ReadFormatMajorVersion(path)
+	if err != nil {
+		t.Fatalf("reading format major version: %v", err)
+	}
+	if version != MinFormatMajorVersion {
+		t.Fatalf("database was upgraded to %s instead of its checkpoint", version)
+	}
+
+	_, _, err = UpgradeFormatMajorVersion(ctx, path, upgradedPath, false)
+	if !errors.Is(err, ErrUpgradedCheckpointExists) {
+		t.Fatalf("got error %v for an upgraded checkpoint of an earlier run, expected %v", err, ErrUpgradedCheckpointExists)
+	}
+
+	_, _, err = UpgradeFormatMajorVersion(ctx, path, upgradedPath, true)
+	if err != nil {
+		t.Fatalf("reusing the upgraded checkpoint: %v", err)
+	}
+
+	err = RemoveUpgradedCheckpoint(upgradedPath)
+	if err != nil {
+		t.Fatalf("removing the upgraded checkpoint: %v", err)
+	}
+	_, err = os.Stat(upgradedPath)
+	if !os.IsNotExist(err) {
+		t.Fatalf("upgraded checkpoint %s was not removed: %v", upgradedPath, err)
+	}
+}
+
+func TestUpgradeFormatMajorVersionCurrent(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "archive")
+	upgradedPath := path + ".upgraded"
+
+	createDatabase(t, path, UpgradedFormatMajorVersion)
+
+	before, after, err := UpgradeFormatMajorVersion(ctx, path, upgradedPath, false)
+	if err != nil {
+		t.Fatalf("upgrading a current database: %v", err)
+	}
+	if before != UpgradedFormatMajorVersion || after != before {
+		t.Fatalf("got format major versions %s and %s, expected %s for both", before, after, UpgradedFormatMajorVersion)
+	}
+	_, err = os.Stat(upgradedPath)
+	if !os.IsNotExist(err) {
+		t.Fatalf("a checkpoint was created at %s for a current database: %v", upgradedPath, err)
+	}
+}
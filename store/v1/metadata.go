@@ -1,6 +1,10 @@
 package v1
 
-import "log"
+import (
+	"log"
+	"maps"
+	"slices"
+)
 
 type TickRange struct {
 	Start uint32
@@ -19,7 +23,8 @@ type StoreMetadata struct {
 
 func (sm *StoreMetadata) PrintStoreMetadata() {
 
-	for epoch, metadata := range sm.Epochs {
+	for _, epoch := range slices.Sorted(maps.Keys(sm.Epochs)) {
+		metadata := sm.Epochs[epoch]
 
 		log.Printf("Epoch: %d\n", epoch)
 		log.Printf("  - Last processed tick: %d\n", metadata.LastProcessedTick)
@@ -2,10 +2,14 @@ package v1
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"runtime"
 
 	"github.com/cockroachdb/pebble"
+	"github.com/golang/protobuf/proto"
+	"github.com/qubic/go-archiver/protobuff"
 	"github.com/qubic/go-archiver/store"
 )
 
@@ -13,10 +17,31 @@ type ArchiverStoreV1 struct {
 	db            *pebble.DB
 	ArchiverStore *store.PebbleStore
 	StoreMetadata StoreMetadata
+	// snapshot is the state of the database that StoreMetadata was loaded from and that the iterators of NewIter read,
+	// it is nil for a store created with CreateArchiverStoreV1.
+	snapshot *pebble.Snapshot
+	// checkpointPath is the checkpoint opened with NewArchiverStoreV1Checkpoint, it is removed on close.
+	checkpointPath string
+	// createdPath is the database created with CreateArchiverStoreV1, whose files are synced by Flush.
+	createdPath string
 }
 
-func NewArchiverStoreV1(path string) (*ArchiverStoreV1, error) {
-	db, err := pebble.Open(path, getPebbleOptions())
+// NewArchiverStoreV1 opens the v1 database read only. It fails if the database has an older format than
+// MinFormatMajorVersion, which pebble v1.1.5 cannot open.
+//
+// Opening takes the directory lock of the database, so it fails while the archiver is running, which holds the lock.
+// The metadata and the iterators of NewIter read one snapshot of the database. The getters of ArchiverStore read the
+// database itself, which nothing writes while the lock is held, so they read the same state.
+func NewArchiverStoreV1(ctx context.Context, path string) (*ArchiverStoreV1, error) {
+	err := CheckFormatMajorVersion(path)
+	if err != nil {
+		return nil, err
+	}
+	return openArchiverStoreV1(ctx, path, getPebbleOptions())
+}
+
+func openArchiverStoreV1(ctx context.Context, path string, options *pebble.Options) (*ArchiverStoreV1, error) {
+	db, err := pebble.Open(path, options)
 	if err != nil {
 		return nil, fmt.Errorf("opening archiver v1 database: %w", err)
 	}
@@ -26,23 +51,25 @@ func NewArchiverStoreV1(path string) (*ArchiverStoreV1, error) {
 	s := ArchiverStoreV1{
 		db:            db,
 		ArchiverStore: archiverStore,
+		snapshot:      db.NewSnapshot(),
 	}
-	err = s.loadStoreMetadata()
+	err = s.loadStoreMetadata(ctx)
 	if err != nil {
+		_ = s.Close()
 		return nil, fmt.Errorf("loading archiver store v1 metadata: %w", err)
 	}
 
 	return &s, nil
 }
 
-func (s *ArchiverStoreV1) loadStoreMetadata() error {
+func (s *ArchiverStoreV1) loadStoreMetadata(ctx context.Context) error {
 
-	lastProcessedTickPerEpoch, err := s.ArchiverStore.GetLastProcessedTicksPerEpoch(context.Background())
+	lastProcessedTickPerEpoch, err := s.getLastProcessedTicksPerEpoch()
 	if err != nil {
 		return fmt.Errorf("getting last processed tick per epoch: %w", err)
 	}
 
-	processedTickIntervalsPerEpoch, err := s.ArchiverStore.GetProcessedTickIntervals(context.Background())
+	processedTickIntervalsPerEpoch, err := s.getProcessedTickIntervals()
 	if err != nil {
 		return fmt.Errorf("getting processed tick intervals per epoch: %w", err)
 	}
@@ -70,12 +97,80 @@ func (s *ArchiverStoreV1) loadStoreMetadata() error {
 	return nil
 }
 
+// getLastProcessedTicksPerEpoch reads the last processed ticks like the go-archiver store, from the snapshot.
+func (s *ArchiverStoreV1) getLastProcessedTicksPerEpoch() (map[uint32]uint32, error) {
+	ticksPerEpoch := make(map[uint32]uint32)
+	err := s.iteratePrefix(store.LastProcessedTickPerEpoch, func(key, value []byte) error {
+		if len(key) != 5 || len(value) != 4 {
+			return fmt.Errorf("invalid last processed tick record %x", key)
+		}
+		ticksPerEpoch[binary.BigEndian.Uint32(key[1:])] = binary.LittleEndian.Uint32(value)
+		return nil
+	})
+	return ticksPerEpoch, err
+}
+
+// getProcessedTickIntervals reads the processed tick intervals like the go-archiver store, from the snapshot.
+func (s *ArchiverStoreV1) getProcessedTickIntervals() ([]*protobuff.ProcessedTickIntervalsPerEpoch, error) {
+	var processedTickIntervals []*protobuff.ProcessedTickIntervalsPerEpoch
+	err := s.iteratePrefix(store.ProcessedTickIntervals, func(key, value []byte) error {
+		var intervals protobuff.ProcessedTickIntervalsPerEpoch
+		err := proto.Unmarshal(value, &intervals)
+		if err != nil {
+			return fmt.Errorf("unmarshaling processed tick intervals %x: %w", key, err)
+		}
+		processedTickIntervals = append(processedTickIntervals, &intervals)
+		return nil
+	})
+	return processedTickIntervals, err
+}
+
+func (s *ArchiverStoreV1) iteratePrefix(prefix byte, handle func(key, value []byte) error) error {
+	iter, err := s.NewIter(&pebble.IterOptions{
+		LowerBound: []byte{prefix},
+		UpperBound: []byte{prefix + 1},
+	})
+	if err != nil {
+		return fmt.Errorf("creating iterator: %w", err)
+	}
+	defer iter.Close()
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		value, err := iter.ValueAndErr()
+		if err != nil {
+			return fmt.Errorf("getting value for key %x: %w", iter.Key(), err)
+		}
+		err = handle(iter.Key(), value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewIter returns an iterator over the snapshot the metadata was loaded from, or over the database for a store created
+// with CreateArchiverStoreV1.
+func (s *ArchiverStoreV1) NewIter(o *pebble.IterOptions) (*pebble.Iterator, error) {
+	if s.snapshot == nil {
+		return s.db.NewIter(o)
+	}
+	return s.snapshot.NewIter(o)
+}
+
 func (s *ArchiverStoreV1) GetDB() *pebble.DB {
 	return s.db
 }
 
 func (s *ArchiverStoreV1) Close() error {
-	return s.db.Close()
+	var err error
+	if s.snapshot != nil {
+		err = s.snapshot.Close()
+	}
+	err = errors.Join(err, s.db.Close())
+	if s.checkpointPath != "" {
+		err = errors.Join(err, removeCheckpoint(s.checkpointPath))
+	}
+	return err
 }
 
 func getPebbleOptions() *pebble.Options {
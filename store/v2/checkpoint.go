@@ -0,0 +1,135 @@
+package v2
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/cockroachdb/pebble/v2"
+)
+
+// Key prefixes owned by the migrator. They sit far above the archiver v2 prefixes, so they never collide with archived
+// data. They are only kept while an epoch store is migrated in the staging directory, RemoveMigrationKeys deletes them
+// before the store is published.
+const (
+	MigrationCheckpoint    = 0xF0
+	MigrationEpochComplete = 0xF1
+)
+
+// Data types tracked by the migration checkpoints.
+const (
+	CheckpointTickData           = 0x00
+	CheckpointQuorumData         = 0x01
+	CheckpointTransactions       = 0x02
+	CheckpointTransactionsStatus = 0x03
+	CheckpointIdentityTransfers  = 0x04
+	CheckpointChainDigest        = 0x05
+	CheckpointStoreDigest        = 0x06
+)
+
+func checkpointKey(dataType byte, rangeStart uint32) []byte {
+	key := []byte{MigrationCheckpoint, dataType}
+	key = binary.BigEndian.AppendUint32(key, rangeStart)
+	return key
+}
+
+// GetCheckpoint returns the last tick that was committed for the given data type and tick range.
+// The boolean is false if nothing has been committed for the range yet.
+func (s *ArchiverEpochStoreV2) GetCheckpoint(dataType byte, rangeStart uint32) (uint32, bool, error) {
+	value, closer, err := s.ArchiverStore.GetDB().Get(checkpointKey(dataType, rangeStart))
+	if err != nil {
+		if errors.Is(err, pebble.ErrNotFound) {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("getting checkpoint for data type %d and range start %d: %w", dataType, rangeStart, err)
+	}
+	defer closer.Close()
+
+	if len(value) != 4 {
+		return 0, false, fmt.Errorf("invalid checkpoint value length %d for data type %d and range start %d", len(value), dataType, rangeStart)
+	}
+	return binary.BigEndian.Uint32(value), true, nil
+}
+
+// SetCheckpoint writes the checkpoint. When the writer is a batch, the checkpoint is committed atomically with the data
+// it describes.
+func SetCheckpoint(writer pebble.Writer, dataType byte, rangeStart, lastTick uint32) error {
+	err := writer.Set(checkpointKey(dataType, rangeStart), binary.BigEndian.AppendUint32(nil, lastTick), nil)
+	if err != nil {
+		return fmt.Errorf("setting checkpoint for data type %d and range start %d: %w", dataType, rangeStart, err)
+	}
+	return nil
+}
+
+// DeleteCheckpoints removes the checkpoints of every tick range of the data type, so that it is migrated again from the
+// start of its ranges.
+func (s *ArchiverEpochStoreV2) DeleteCheckpoints(dataType byte) error {
+	err := s.ArchiverStore.GetDB().DeleteRange([]byte{MigrationCheckpoint, dataType}, []byte{MigrationCheckpoint, dataType + 1}, pebble.Sync)
+	if err != nil {
+		return fmt.Errorf("deleting checkpoints for data type %d: %w", dataType, err)
+	}
+	return nil
+}
+
+func (s *ArchiverEpochStoreV2) IsEpochComplete() (bool, error) {
+	_, closer, err := s.ArchiverStore.GetDB().Get([]byte{MigrationEpochComplete})
+	if err != nil {
+		if errors.Is(err, pebble.ErrNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("getting epoch complete marker: %w", err)
+	}
+	defer closer.Close()
+	return true, nil
+}
+
+func (s *ArchiverEpochStoreV2) MarkEpochComplete() error {
+	err := s.ArchiverStore.GetDB().Set([]byte{MigrationEpochComplete}, []byte{0x01}, pebble.Sync)
+	if err != nil {
+		return fmt.Errorf("setting epoch complete marker: %w", err)
+	}
+	return nil
+}
+
+// MarkEpochIncomplete removes the epoch complete marker, so that a store that is migrated further is not taken as
+// finished.
+func (s *ArchiverEpochStoreV2) MarkEpochIncomplete() error {
+	err := s.ArchiverStore.GetDB().Delete([]byte{MigrationEpochComplete}, pebble.Sync)
+	if err != nil {
+		return fmt.Errorf("deleting epoch complete marker: %w", err)
+	}
+	return nil
+}
+
+// HasCheckpoints reports whether the store holds a checkpoint of any data type.
+func (s *ArchiverEpochStoreV2) HasCheckpoints() (bool, error) {
+	iter, err := s.ArchiverStore.GetDB().NewIter(&pebble.IterOptions{
+		LowerBound: []byte{MigrationCheckpoint},
+		UpperBound: []byte{MigrationCheckpoint + 1},
+	})
+	if err != nil {
+		return false, fmt.Errorf("creating checkpoint iterator: %w", err)
+	}
+	exists := iter.First()
+	err = iter.Close()
+	if err != nil {
+		return false, fmt.Errorf("iterating checkpoints: %w", err)
+	}
+	return exists, nil
+}
+
+// RemoveMigrationKeys deletes the checkpoints and the epoch complete marker, which only the migrator reads, and compacts
+// their range, so that they are neither read nor kept in the tables of a published store.
+func (s *ArchiverEpochStoreV2) RemoveMigrationKeys(ctx context.Context) error {
+	start, end := []byte{MigrationCheckpoint}, []byte{MigrationEpochComplete + 1}
+	err := s.ArchiverStore.GetDB().DeleteRange(start, end, pebble.Sync)
+	if err != nil {
+		return fmt.Errorf("deleting migration keys: %w", err)
+	}
+	err = s.ArchiverStore.GetDB().Compact(ctx, start, end, false)
+	if err != nil {
+		return fmt.Errorf("compacting migration keys: %w", err)
+	}
+	return nil
+}
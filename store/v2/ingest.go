@@ -0,0 +1,123 @@
+package v2
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cockroachdb/pebble/v2"
+	"github.com/cockroachdb/pebble/v2/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/v2/sstable"
+	"github.com/cockroachdb/pebble/v2/vfs"
+)
+
+const maxSSTableSize = 536870912 // 512 MB
+
+// SSTableWriter writes records in ascending key order into external sstables and ingests them into the epoch store.
+// This skips the WAL, memtable flushes and most compactions that writing through batches costs.
+type SSTableWriter struct {
+	store   *ArchiverEpochStoreV2
+	dir     string
+	writer  *sstable.Writer
+	paths   []string
+	lastKey []byte
+}
+
+// NewSSTableWriter creates a writer that keeps its sstables in a temporary directory inside tempDir. The directory
+// should be on the same filesystem as the epoch store, so that ingestion can link the files instead of copying them.
+func (s *ArchiverEpochStoreV2) NewSSTableWriter(tempDir string) (*SSTableWriter, error) {
+	dir, err := os.MkdirTemp(tempDir, ".ingest-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating temporary sstable directory: %w", err)
+	}
+
+	return &SSTableWriter{
+		store: s,
+		dir:   dir,
+	}, nil
+}
+
+// Set adds the record to the current sstable. Keys must be strictly ascending. The signature matches pebble.Batch, so
+// both can be used interchangeably.
+func (w *SSTableWriter) Set(key, value []byte, _ *pebble.WriteOptions) error {
+	if w.lastKey != nil && bytes.Compare(key, w.lastKey) <= 0 {
+		return fmt.Errorf("key %x is not greater than previous key %x", key, w.lastKey)
+	}
+
+	if w.writer == nil {
+		err := w.openNextFile()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := w.writer.Set(key, value)
+	if err != nil {
+		return fmt.Errorf("adding key %x to sstable: %w", key, err)
+	}
+	w.lastKey = append(w.lastKey[:0], key...)
+
+	if w.writer.Raw().EstimatedSize() >= maxSSTableSize {
+		err = w.closeCurrentFile()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *SSTableWriter) openNextFile() error {
+	path := filepath.Join(w.dir, fmt.Sprintf("%06d.sst", len(w.paths)))
+	file, err := vfs.Default.Create(path, vfs.WriteCategoryUnspecified)
+	if err != nil {
+		return fmt.Errorf("creating sstable %s: %w", path, err)
+	}
+
+	w.writer = sstable.NewWriter(objstorageprovider.NewFileWritable(file), sstable.WriterOptions{
+		TableFormat: w.store.ArchiverStore.GetDB().TableFormat(),
+	})
+	w.paths = append(w.paths, path)
+	return nil
+}
+
+func (w *SSTableWriter) closeCurrentFile() error {
+	if w.writer == nil {
+		return nil
+	}
+	err := w.writer.Close()
+	w.writer = nil
+	if err != nil {
+		return fmt.Errorf("closing sstable: %w", err)
+	}
+	return nil
+}
+
+// Ingest finishes the written sstables and ingests them into the epoch store. The writer can not be used afterward.
+func (w *SSTableWriter) Ingest(ctx context.Context) error {
+	err := w.closeCurrentFile()
+	if err != nil {
+		return err
+	}
+
+	if len(w.paths) == 0 {
+		return nil
+	}
+
+	err = w.store.ArchiverStore.GetDB().Ingest(ctx, w.paths)
+	if err != nil {
+		return fmt.Errorf("ingesting %d sstables: %w", len(w.paths), err)
+	}
+	w.paths = nil
+	return nil
+}
+
+// Close removes the temporary directory with any sstables that have not been ingested.
+func (w *SSTableWriter) Close() error {
+	if w.writer != nil {
+		_ = w.writer.Close()
+		w.writer = nil
+	}
+	return os.RemoveAll(w.dir)
+}
@@ -0,0 +1,35 @@
+package v2
+
+import "encoding/binary"
+
+// Prefixes for v1 data that has no native place in the archiver v2 schema. Like the migration checkpoints, they are
+// kept clear of the archiver v2 prefixes. Unlike them, they stay in the published epoch stores, since verify mode and
+// reverse read them. They rely on archiver v2 reading its records only by the keys and ranges of its own prefixes.
+// Compacting an epoch store, or pruning it by removing its directory, treats them like any other key.
+const (
+	IdentityTransferTransactions = 0xE0
+	ChainDigest                  = 0xE1
+	StoreDigest                  = 0xE2
+	EmptyTicksPerEpoch           = 0xE3
+	SkippedTicksIntervals        = 0xE4
+)
+
+// IdentityTransferTransactionsKey uses the same layout as the v1 index: identity followed by the tick number.
+func IdentityTransferTransactionsKey(identity []byte, tickNumber uint64) []byte {
+	key := []byte{IdentityTransferTransactions}
+	key = append(key, identity...)
+	key = binary.BigEndian.AppendUint64(key, tickNumber)
+	return key
+}
+
+func emptyTicksPerEpochKey(epoch uint32) []byte {
+	key := []byte{EmptyTicksPerEpoch}
+	key = binary.BigEndian.AppendUint32(key, epoch)
+	return key
+}
+
+func skippedTicksIntervalKey(startTick uint32) []byte {
+	key := []byte{SkippedTicksIntervals}
+	key = binary.BigEndian.AppendUint32(key, startTick)
+	return key
+}
@@ -0,0 +1,96 @@
+package v2
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/cockroachdb/pebble/v2"
+)
+
+// SkippedTicksInterval is a range of ticks, including both ends, that the v1 archiver skipped.
+type SkippedTicksInterval struct {
+	StartTick uint32
+	EndTick   uint32
+}
+
+func (s *ArchiverEpochStoreV2) SetEmptyTicksCount(epoch, count uint32) error {
+	err := s.ArchiverStore.GetDB().Set(emptyTicksPerEpochKey(epoch), binary.BigEndian.AppendUint32(nil, count), pebble.Sync)
+	if err != nil {
+		return fmt.Errorf("setting empty ticks count for epoch %d: %w", epoch, err)
+	}
+	return nil
+}
+
+// GetEmptyTicksCount returns the number of empty ticks of the epoch. The boolean is false if no count is stored.
+func (s *ArchiverEpochStoreV2) GetEmptyTicksCount(epoch uint32) (uint32, bool, error) {
+	value, closer, err := s.ArchiverStore.GetDB().Get(emptyTicksPerEpochKey(epoch))
+	if err != nil {
+		if errors.Is(err, pebble.ErrNotFound) {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("getting empty ticks count for epoch %d: %w", epoch, err)
+	}
+	defer closer.Close()
+
+	if len(value) != 4 {
+		return 0, false, fmt.Errorf("invalid empty ticks count length %d for epoch %d", len(value), epoch)
+	}
+	return binary.BigEndian.Uint32(value), true, nil
+}
+
+// SetSkippedTicksIntervals replaces the stored skipped tick intervals. Every interval is stored as its own record,
+// keyed by its start tick.
+func (s *ArchiverEpochStoreV2) SetSkippedTicksIntervals(intervals []SkippedTicksInterval) error {
+	batch := s.ArchiverStore.GetDB().NewBatch()
+	defer batch.Close()
+
+	err := batch.DeleteRange([]byte{SkippedTicksIntervals}, []byte{SkippedTicksIntervals + 1}, nil)
+	if err != nil {
+		return fmt.Errorf("deleting skipped ticks intervals: %w", err)
+	}
+
+	for _, interval := range intervals {
+		err = batch.Set(skippedTicksIntervalKey(interval.StartTick), binary.BigEndian.AppendUint32(nil, interval.EndTick), nil)
+		if err != nil {
+			return fmt.Errorf("setting skipped ticks interval %d to %d: %w", interval.StartTick, interval.EndTick, err)
+		}
+	}
+
+	err = batch.Commit(pebble.Sync)
+	if err != nil {
+		return fmt.Errorf("committing skipped ticks intervals: %w", err)
+	}
+	return nil
+}
+
+// GetSkippedTicksIntervals returns the stored skipped tick intervals ordered by start tick.
+func (s *ArchiverEpochStoreV2) GetSkippedTicksIntervals() ([]SkippedTicksInterval, error) {
+	iter, err := s.ArchiverStore.GetDB().NewIter(
+		&pebble.IterOptions{
+			LowerBound: []byte{SkippedTicksIntervals},
+			UpperBound: []byte{SkippedTicksIntervals + 1},
+		})
+	if err != nil {
+		return nil, fmt.Errorf("creating skipped ticks intervals iterator: %w", err)
+	}
+	defer iter.Close()
+
+	var intervals []SkippedTicksInterval
+	for iter.First(); iter.Valid(); iter.Next() {
+		key := iter.Key()
+		value, err := iter.ValueAndErr()
+		if err != nil {
+			return nil, fmt.Errorf("getting skipped ticks interval: %w", err)
+		}
+		if len(key) != 5 || len(value) != 4 {
+			return nil, fmt.Errorf("invalid skipped ticks interval record %x", key)
+		}
+
+		intervals = append(intervals, SkippedTicksInterval{
+			StartTick: binary.BigEndian.Uint32(key[1:]),
+			EndTick:   binary.BigEndian.Uint32(value),
+		})
+	}
+	return intervals, nil
+}
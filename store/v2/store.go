@@ -1,13 +1,24 @@
 package v2
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 
+	"github.com/cockroachdb/pebble/v2"
 	"github.com/qubic/go-archiver-v2/db"
 )
 
+// ErrEpochStoreNotFound is returned when opening the store of an epoch that does not exist.
+var ErrEpochStoreNotFound = errors.New("epoch store not found")
+
 type ArchiverEpochStoreV2 struct {
 	ArchiverStore *db.PebbleStore
+	epoch         uint32
 }
 
 func NewArchiverEpochStoreV2(directory string, epoch uint32) (*ArchiverEpochStoreV2, error) {
@@ -18,9 +29,77 @@ func NewArchiverEpochStoreV2(directory string, epoch uint32) (*ArchiverEpochStor
 
 	return &ArchiverEpochStoreV2{
 		ArchiverStore: store,
+		epoch:         epoch,
 	}, nil
 }
 
+// OpenArchiverEpochStoreV2 opens the store of the epoch if it exists. Unlike NewArchiverEpochStoreV2 it never creates
+// an empty store, so modes that only read the epoch stores leave the directory untouched.
+func OpenArchiverEpochStoreV2(directory string, epoch uint32) (*ArchiverEpochStoreV2, error) {
+	path := EpochStorePath(directory, epoch)
+	_, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrEpochStoreNotFound, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("checking archiver v2 database %s: %w", path, err)
+	}
+	return NewArchiverEpochStoreV2(directory, epoch)
+}
+
+// EpochStorePath returns the directory in which db.CreateStore keeps the store of the epoch.
+func EpochStorePath(directory string, epoch uint32) string {
+	return filepath.Join(directory, strconv.Itoa(int(uint16(epoch))))
+}
+
+// ListEpochStores returns the epochs whose stores are in the directory, in ascending order. Other entries, such as the
+// staging directory of the migration, are ignored.
+func ListEpochStores(directory string) ([]uint32, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, fmt.Errorf("reading epoch store directory %s: %w", directory, err)
+	}
+
+	var epochs []uint32
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		epoch, err := strconv.ParseUint(entry.Name(), 10, 16)
+		if err != nil || strconv.Itoa(int(epoch)) != entry.Name() {
+			continue
+		}
+		epochs = append(epochs, uint32(epoch))
+	}
+	slices.Sort(epochs)
+	return epochs, nil
+}
+
+// CheckpointEpochStore copies the store of the epoch under directory to the store of the epoch under destDirectory,
+// which must not exist yet. The copy is a pebble checkpoint: the table files are hard linked where the file system
+// allows it, the manifest, options and write ahead log are copied.
+func CheckpointEpochStore(directory string, epoch uint32, destDirectory string) error {
+	store, err := OpenArchiverEpochStoreV2(directory, epoch)
+	if err != nil {
+		return err
+	}
+
+	destPath := EpochStorePath(destDirectory, epoch)
+	err = store.ArchiverStore.GetDB().Checkpoint(destPath, pebble.WithFlushedWAL())
+	closeErr := store.Close()
+	if err != nil {
+		return fmt.Errorf("checkpointing archiver v2 database to %s: %w", destPath, err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("closing archiver v2 database: %w", closeErr)
+	}
+	return nil
+}
+
+func (s *ArchiverEpochStoreV2) Epoch() uint32 {
+	return s.epoch
+}
+
 func (s *ArchiverEpochStoreV2) Close() error {
 	return s.ArchiverStore.Close()
 }